@@ -16,6 +16,9 @@ package main
 import (
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	endpoints "github.com/tektoncd/experimental/webhooks-extension/pkg/endpoints"
@@ -29,6 +32,39 @@ func main() {
 		logging.Log.Fatalf("Fatal error creating resource: %s.", err.Error())
 	}
 
+	// Warn if the triggers will reference a ClusterInterceptor that was not installed
+	r.CheckClusterInterceptor()
+
+	// Label the credentials of webhooks created before credentials were labelled
+	go r.LabelExistingCredentials()
+
+	// Roll back, or resume, webhook creations interrupted by a restart of any replica
+	go r.RecoverWebhookCreations(strings.ToLower(os.Getenv("INTERRUPTED_CREATIONS")) == "resume", time.Minute)
+
+	// Keep credentials synchronized into pipeline namespaces in step with their rotation
+	syncInterval := 60 * time.Second
+	if seconds, err := strconv.Atoi(os.Getenv("CREDENTIAL_SYNC_INTERVAL")); err == nil && seconds > 0 {
+		syncInterval = time.Duration(seconds) * time.Second
+	}
+	go r.SyncCredentials(syncInterval)
+
+	// Run the pipelines of webhooks with cron schedules as they fall due
+	go r.RunSchedules()
+
+	// Delete the PipelineRuns webhooks' retention policies do not keep
+	pruneInterval := 10 * time.Minute
+	if seconds, err := strconv.Atoi(os.Getenv("RETENTION_PRUNE_INTERVAL")); err == nil && seconds > 0 {
+		pruneInterval = time.Duration(seconds) * time.Second
+	}
+	go r.PruneRuns(pruneInterval)
+
+	// Report the status of the PipelineRuns started by pull requests on the pull requests
+	reportTimeout := time.Hour
+	if seconds, err := strconv.Atoi(os.Getenv("STATUS_REPORT_TIMEOUT")); err == nil && seconds > 0 {
+		reportTimeout = time.Duration(seconds) * time.Second
+	}
+	go r.ReportStatuses(reportTimeout)
+
 	// Set up routes
 	wsContainer := restful.NewContainer()
 	wsContainer.Router(restful.CurlyRouter{})
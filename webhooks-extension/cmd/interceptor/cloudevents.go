@@ -0,0 +1,145 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// The URL CloudEvents are sent to, no events are sent if this is not set
+	envCloudEventsSink = "CLOUDEVENTS_SINK"
+
+	cloudEventsSpecVersion = "1.0"
+	cloudEventSource       = "/tekton-webhooks-extension-validator"
+
+	deliveryAcceptedEventType = "dev.tekton.webhooks.delivery.accepted"
+	deliverySkippedEventType  = "dev.tekton.webhooks.delivery.skipped"
+	deliveryRejectedEventType = "dev.tekton.webhooks.delivery.rejected"
+)
+
+// The data of the CloudEvent sent for each decision made on a delivery
+type deliveryEventData struct {
+	TriggerName string `json:"triggerName"`
+	Repository  string `json:"repository"`
+	Event       string `json:"event"`
+	DeliveryID  string `json:"deliveryID"`
+	Status      int    `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type cloudEvent struct {
+	ID      string
+	Type    string
+	Subject string
+	Time    time.Time
+	Data    deliveryEventData
+}
+
+// Sends CloudEvents to the sink using the binary content mode of the HTTP binding
+type cloudEventSender struct {
+	sink   string
+	client *http.Client
+}
+
+// Emits the CloudEvents for interceptor decisions, configured from the environment
+var events = newCloudEventSender(os.Getenv(envCloudEventsSink))
+
+func newCloudEventSender(sink string) *cloudEventSender {
+	return &cloudEventSender{sink: sink, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Creates the event describing the decision made on a delivery for a trigger
+func newDeliveryEvent(params triggerParams, header http.Header, result decision) cloudEvent {
+	eventType := deliveryRejectedEventType
+	reason := result.Message
+	if result.Status == http.StatusOK {
+		eventType = deliveryAcceptedEventType
+		reason = ""
+	} else if result.Status == http.StatusAccepted {
+		eventType = deliverySkippedEventType
+	}
+
+	return cloudEvent{
+		ID:      newCloudEventID(),
+		Type:    eventType,
+		Subject: params.TriggerName,
+		Time:    time.Now().UTC(),
+		Data: deliveryEventData{
+			TriggerName: params.TriggerName,
+			Repository:  params.RepositoryURL,
+			Event:       header.Get("X-Github-Event"),
+			DeliveryID:  header.Get("X-Github-Delivery"),
+			Status:      result.Status,
+			Reason:      reason,
+		},
+	}
+}
+
+// Sends the event for a decision without holding up the response to the eventlistener
+func (s *cloudEventSender) emit(params triggerParams, header http.Header, result decision) {
+	if s.sink == "" {
+		return
+	}
+	event := newDeliveryEvent(params, header, result)
+	go func() {
+		if err := s.send(event); err != nil {
+			log.Printf("[%s] Failed to send %s CloudEvent for Github event ID: %s. Error: %s", params.TriggerName, event.Type, event.Data.DeliveryID, err.Error())
+		}
+	}()
+}
+
+func (s *cloudEventSender) send(event cloudEvent) error {
+	body, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, s.sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Ce-Specversion", cloudEventsSpecVersion)
+	request.Header.Set("Ce-Id", event.ID)
+	request.Header.Set("Ce-Type", event.Type)
+	request.Header.Set("Ce-Source", cloudEventSource)
+	request.Header.Set("Ce-Subject", event.Subject)
+	request.Header.Set("Ce-Time", event.Time.Format(time.RFC3339Nano))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("sink returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func newCloudEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
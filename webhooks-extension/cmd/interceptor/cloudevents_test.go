@@ -0,0 +1,116 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func deliveryHeader() http.Header {
+	header := http.Header{}
+	header.Set("X-Github-Event", "push")
+	header.Set("X-Github-Delivery", "delivery-1")
+	return header
+}
+
+func TestNewDeliveryEventTypes(t *testing.T) {
+	params := triggerParams{TriggerName: "name-ns-push-event", RepositoryURL: "https://github.com/owner/repo"}
+
+	tests := []struct {
+		result       decision
+		expectedType string
+		reason       string
+	}{
+		{decision{Status: http.StatusOK, Payload: []byte("{}")}, deliveryAcceptedEventType, ""},
+		{decision{Status: http.StatusAccepted, Message: "skipped: [skip ci] directive found"}, deliverySkippedEventType, "skipped: [skip ci] directive found"},
+		{decision{Status: http.StatusExpectationFailed, Message: "Validation failed, repository URL does not match"}, deliveryRejectedEventType, "Validation failed, repository URL does not match"},
+		{decision{Status: http.StatusTooManyRequests, Message: "rate limit exceeded for trigger name-ns-push-event"}, deliveryRejectedEventType, "rate limit exceeded for trigger name-ns-push-event"},
+	}
+	for _, tt := range tests {
+		event := newDeliveryEvent(params, deliveryHeader(), tt.result)
+		if event.Type != tt.expectedType {
+			t.Errorf("Event type for status %d was %s, expected %s", tt.result.Status, event.Type, tt.expectedType)
+		}
+		expectedData := deliveryEventData{
+			TriggerName: "name-ns-push-event",
+			Repository:  "https://github.com/owner/repo",
+			Event:       "push",
+			DeliveryID:  "delivery-1",
+			Status:      tt.result.Status,
+			Reason:      tt.reason,
+		}
+		if !reflect.DeepEqual(event.Data, expectedData) {
+			t.Errorf("Event data for status %d was %+v, expected %+v", tt.result.Status, event.Data, expectedData)
+		}
+	}
+}
+
+func TestSendDeliveryEvent(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var receivedData deliveryEventData
+	sink := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := json.NewDecoder(request.Body).Decode(&receivedData); err != nil {
+			t.Errorf("Sink failed to decode event data: %s", err)
+		}
+		received <- request
+		writer.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	params := triggerParams{TriggerName: "name-ns-push-event", RepositoryURL: "https://github.com/owner/repo"}
+	newCloudEventSender(sink.URL).emit(params, deliveryHeader(), decision{Status: http.StatusAccepted, Message: "skipped: [skip ci] directive found"})
+
+	var request *http.Request
+	select {
+	case request = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sink did not receive an event")
+	}
+
+	expectedHeaders := map[string]string{
+		"Ce-Specversion": cloudEventsSpecVersion,
+		"Ce-Type":        deliverySkippedEventType,
+		"Ce-Source":      cloudEventSource,
+		"Ce-Subject":     "name-ns-push-event",
+		"Content-Type":   "application/json",
+	}
+	for name, expected := range expectedHeaders {
+		if value := request.Header.Get(name); value != expected {
+			t.Errorf("Header %s was %s, expected %s", name, value, expected)
+		}
+	}
+	if request.Header.Get("Ce-Id") == "" || request.Header.Get("Ce-Time") == "" {
+		t.Error("Event was sent without an id or time")
+	}
+	if receivedData.DeliveryID != "delivery-1" || receivedData.Reason != "skipped: [skip ci] directive found" {
+		t.Errorf("Unexpected event data received: %+v", receivedData)
+	}
+}
+
+func TestSendDeliveryEventSinkError(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer sink.Close()
+
+	event := newDeliveryEvent(triggerParams{TriggerName: "name-ns-push-event"}, deliveryHeader(), decision{Status: http.StatusOK})
+	if err := newCloudEventSender(sink.URL).send(event); err == nil {
+		t.Error("Expected an error when the sink fails")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The Wext-Webhook-Type of webhooks for event sources other than a Git provider
+	webhookTypeGeneric = "generic"
+	// Used when a generic webhook does not name its signature algorithm
+	defaultSignatureAlgorithm = "sha256"
+)
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Validates a delivery for a generic webhook: the HMAC of the body in the configured
+// signature header, then the value at the identity path of the payload. The payload
+// is passed on unchanged as none of the Git extras apply.
+func validateGenericDelivery(params triggerParams, request *http.Request, secretToken []byte) decision {
+	triggerName := params.TriggerName
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Printf("[%s] Validation FAIL (error %s reading payload)", triggerName, err.Error())
+		return decision{Status: http.StatusBadRequest, Message: fmt.Sprint(err)}
+	}
+
+	if err := validateSignature(request.Header.Get(params.SignatureHeader), params.SignatureAlgorithm, payload, secretToken); err != nil {
+		log.Printf("[%s] Validation FAIL (error %s validating the %s header)", triggerName, err.Error(), params.SignatureHeader)
+		return decision{Status: http.StatusExpectationFailed, Message: fmt.Sprint(err)}
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(payload, &document); err != nil {
+		log.Printf("[%s] Validation FAIL (error %s marshalling payload as JSON)", triggerName, err.Error())
+		return decision{Status: http.StatusBadRequest, Message: fmt.Sprint(err)}
+	}
+	found, err := lookupPath(document, params.IdentityPath)
+	if err != nil {
+		log.Printf("[%s] Validation FAIL (%s)", triggerName, err.Error())
+		return decision{Status: http.StatusExpectationFailed, Message: "Validation failed, " + err.Error()}
+	}
+	if identity := fmt.Sprint(found); identity != params.IdentityValue {
+		log.Printf("[%s] Validation FAIL (identity at %s does not match, got %s but wanted %s)", triggerName, params.IdentityPath, identity, params.IdentityValue)
+		return decision{Status: http.StatusExpectationFailed, Message: "Validation failed, identity does not match"}
+	}
+
+	log.Printf("[%s] Validation PASS (signature and identity at %s checked)", triggerName, params.IdentityPath)
+	return decision{Status: http.StatusOK, Payload: payload}
+}
+
+// Checks the signature is the hex encoded HMAC of the payload with the secret token,
+// optionally prefixed by the algorithm as in "sha256=<hex>".
+func validateSignature(signature, algorithm string, payload, secretToken []byte) error {
+	if algorithm == "" {
+		algorithm = defaultSignatureAlgorithm
+	}
+	algorithm = strings.ToLower(algorithm)
+	newHash, ok := signatureAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %s", algorithm)
+	}
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+	signature = strings.TrimPrefix(signature, algorithm+"=")
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %s", err)
+	}
+
+	mac := hmac.New(newHash, secretToken)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("payload signature check failed")
+	}
+	return nil
+}
+
+// Finds the value at a JSONPath-style path of dotted keys and array indexes, such as
+// $.repository.repo_full_name or $.event_data.resources[0].resource_url. The leading
+// $ is optional.
+func lookupPath(document interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return document, nil
+	}
+	current := document
+	for _, segment := range strings.Split(path, ".") {
+		key := segment
+		var indexes []string
+		if bracket := strings.Index(segment, "["); bracket >= 0 {
+			key = segment[:bracket]
+			if !strings.HasSuffix(segment, "]") {
+				return nil, fmt.Errorf("path segment %s is not a key followed by [index]", segment)
+			}
+			indexes = strings.Split(segment[bracket+1:len(segment)-1], "][")
+		}
+		if key != "" {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("no object with key %s at %s", key, path)
+			}
+			if current, ok = object[key]; !ok {
+				return nil, fmt.Errorf("key %s of %s not found in payload", key, path)
+			}
+		}
+		for _, index := range indexes {
+			i, err := strconv.Atoi(index)
+			if err != nil {
+				return nil, fmt.Errorf("index %s in %s is not a number", index, path)
+			}
+			array, ok := current.([]interface{})
+			if !ok || i < 0 || i >= len(array) {
+				return nil, fmt.Errorf("index %d of %s not found in payload", i, path)
+			}
+			current = array[i]
+		}
+	}
+	return current, nil
+}
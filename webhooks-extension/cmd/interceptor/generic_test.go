@@ -0,0 +1,105 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const registryPushBody = `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"tag":"v1","resource_url":"registry.example.com/project/app:v1"}],"repository":{"repo_full_name":"project/app"}}}`
+
+var genericParams = triggerParams{
+	TriggerName:     "name-namespace-generic-event",
+	RepositoryURL:   "https://registry.example.com/project/app",
+	WebhookType:     webhookTypeGeneric,
+	SignatureHeader: "X-Registry-Signature",
+	IdentityPath:    "$.event_data.repository.repo_full_name",
+	IdentityValue:   "project/app",
+}
+
+func genericDelivery(body, signature string) *http.Request {
+	request, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set("X-Registry-Signature", signature)
+	return request
+}
+
+func sha256Signature(body string) string {
+	mac := hmac.New(sha256.New, []byte(testSecretToken))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestGenericDelivery(t *testing.T) {
+	for _, signature := range []string{sha256Signature(registryPushBody), "sha256=" + sha256Signature(registryPushBody)} {
+		result := validateDelivery(genericParams, genericDelivery(registryPushBody, signature), []byte(testSecretToken))
+		if result.Status != http.StatusOK || string(result.Payload) != registryPushBody {
+			t.Errorf("Delivery signed %s returned %d with payload %s, expected 200 with the payload unchanged", signature, result.Status, result.Payload)
+		}
+	}
+}
+
+func TestGenericDeliveryBadSignature(t *testing.T) {
+	for _, signature := range []string{"", "0000", "not hex", sha256Signature(registryPushBody + " ")} {
+		result := validateDelivery(genericParams, genericDelivery(registryPushBody, signature), []byte(testSecretToken))
+		if result.Status != http.StatusExpectationFailed {
+			t.Errorf("Delivery signed %q returned %d, expected %d", signature, result.Status, http.StatusExpectationFailed)
+		}
+	}
+
+	params := genericParams
+	params.SignatureAlgorithm = "sha512"
+	result := validateDelivery(params, genericDelivery(registryPushBody, sha256Signature(registryPushBody)), []byte(testSecretToken))
+	if result.Status != http.StatusExpectationFailed {
+		t.Errorf("Delivery signed with the wrong algorithm returned %d, expected %d", result.Status, http.StatusExpectationFailed)
+	}
+}
+
+func TestGenericDeliveryIdentityMismatch(t *testing.T) {
+	body := strings.Replace(registryPushBody, "project/app", "project/other", -1)
+	result := validateDelivery(genericParams, genericDelivery(body, sha256Signature(body)), []byte(testSecretToken))
+	if result.Status != http.StatusExpectationFailed {
+		t.Errorf("Delivery for another repository returned %d, expected %d", result.Status, http.StatusExpectationFailed)
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	document := map[string]interface{}{
+		"count": 2.0,
+		"items": []interface{}{
+			map[string]interface{}{"name": "first"},
+			map[string]interface{}{"name": "second", "nested": []interface{}{[]interface{}{"deep"}}},
+		},
+	}
+	for path, expected := range map[string]interface{}{
+		"$.count":                 2.0,
+		"count":                   2.0,
+		"$.items[1].name":         "second",
+		"$.items[1].nested[0][0]": "deep",
+	} {
+		found, err := lookupPath(document, path)
+		if err != nil || found != expected {
+			t.Errorf("Path %s found %v with error %v, expected %v", path, found, err, expected)
+		}
+	}
+	for _, path := range []string{"$.missing", "$.items[2].name", "$.items[x]", "$.count.name", "$.items[0"} {
+		if found, err := lookupPath(document, path); err == nil {
+			t.Errorf("Path %s found %v, expected an error", path, found)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/deliveryhistory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	envDeliveryHistorySize     = "DELIVERY_HISTORY_SIZE"
+	defaultDeliveryHistorySize = 10
+)
+
+// The suffixes of the triggers a webhook has, the monitor trigger is shared by a repository's webhooks
+var webhookTriggerSuffixes = []string{"-push-event", "-pullrequest-event", "-generic-event"}
+
+// Headers that are not kept with a recorded delivery
+var unrecordedHeaders = []string{"Authorization", "Cookie"}
+
+// Records the deliveries to each webhook and the decisions made on them
+type deliveryRecorder struct {
+	size int
+}
+
+// Records deliveries in the install namespace, configured from the environment
+var history = getDeliveryRecorderFromEnv()
+
+func getDeliveryRecorderFromEnv() *deliveryRecorder {
+	size := defaultDeliveryHistorySize
+	if value := os.Getenv(envDeliveryHistorySize); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s %s, keeping %d deliveries", envDeliveryHistorySize, value, size)
+		} else {
+			size = parsed
+		}
+	}
+	return &deliveryRecorder{size: size}
+}
+
+// The <name>-<namespace> prefix identifying the webhook of a trigger, empty for a monitor trigger
+func webhookOfTrigger(triggerName string) string {
+	for _, suffix := range webhookTriggerSuffixes {
+		if strings.HasSuffix(triggerName, suffix) {
+			return strings.TrimSuffix(triggerName, suffix)
+		}
+	}
+	return ""
+}
+
+// Whether the payload claims to be for the trigger's repository, or for a generic
+// webhook its source. Every trigger sees every delivery, so only these are recorded,
+// whatever the decision and whether or not the claim is signed.
+func deliveryConcernsTrigger(params triggerParams, payload []byte) bool {
+	if params.WebhookType == webhookTypeGeneric {
+		var document interface{}
+		if err := json.Unmarshal(payload, &document); err != nil {
+			return false
+		}
+		found, err := lookupPath(document, params.IdentityPath)
+		return err == nil && fmt.Sprint(found) == params.IdentityValue
+	}
+	var result Result
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return false
+	}
+	return sanitizeGitInput(result.Repository.CloneURL) == sanitizeGitInput(params.RepositoryURL)
+}
+
+// The ID of a delivery, which is GitHub's delivery ID or else made from the payload
+func deliveryID(header http.Header, payload []byte) string {
+	if id := header.Get("X-Github-Delivery"); id != "" {
+		return id
+	}
+	return fmt.Sprintf("sha256-%x", sha256.Sum256(payload))[:23]
+}
+
+func newRecordedDelivery(header http.Header, payload []byte) deliveryhistory.Delivery {
+	recorded := map[string][]string{}
+	for name, values := range header {
+		canonical := http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(canonical, "Wext-") {
+			continue
+		}
+		keep := true
+		for _, unrecorded := range unrecordedHeaders {
+			keep = keep && canonical != unrecorded
+		}
+		if keep {
+			recorded[canonical] = values
+		}
+	}
+	return deliveryhistory.Delivery{
+		ID:       deliveryID(header, payload),
+		Received: time.Now().UTC(),
+		Event:    header.Get("X-Github-Event"),
+		Header:   recorded,
+		Payload:  string(payload),
+	}
+}
+
+// Records the decision on a delivery without holding up the response to the eventlistener
+func (h *deliveryRecorder) record(params triggerParams, header http.Header, payload []byte, result decision) {
+	webhook := webhookOfTrigger(params.TriggerName)
+	if h.size <= 0 || webhook == "" || !deliveryConcernsTrigger(params, payload) {
+		return
+	}
+	delivery := newRecordedDelivery(header, payload)
+	go func() {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			log.Printf("[%s] Error creating in cluster config: %s", params.TriggerName, err.Error())
+			return
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			log.Printf("[%s] Error creating new clientset: %s", params.TriggerName, err.Error())
+			return
+		}
+		store := deliveryhistory.Store{Client: clientset, Namespace: os.Getenv("INSTALLED_NAMESPACE"), Size: h.size}
+		err = store.Record(webhook, delivery, deliveryhistory.Decision{Trigger: params.TriggerName, Status: result.Status, Message: result.Message})
+		if err != nil {
+			log.Printf("[%s] Failed to record delivery ID: %s. Error: %s", params.TriggerName, delivery.ID, err.Error())
+		}
+	}()
+}
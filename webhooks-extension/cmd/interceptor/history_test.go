@@ -0,0 +1,73 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWebhookOfTrigger(t *testing.T) {
+	tests := map[string]string{
+		"hook-green-push-event":        "hook-green",
+		"hook-green-pullrequest-event": "hook-green",
+		"hook-green-generic-event":     "hook-green",
+		"github.com/owner/repo":        "",
+	}
+	for trigger, expected := range tests {
+		if webhook := webhookOfTrigger(trigger); webhook != expected {
+			t.Errorf("Webhook of trigger %s was %q, expected %q", trigger, webhook, expected)
+		}
+	}
+}
+
+func TestDeliveryConcernsTrigger(t *testing.T) {
+	params := triggerParams{RepositoryURL: "https://github.com/owner/repo"}
+	if !deliveryConcernsTrigger(params, []byte(`{"repository":{"clone_url":"https://github.com/owner/repo.git"}}`)) {
+		t.Error("Expected a delivery for the trigger's repository to concern it")
+	}
+	if deliveryConcernsTrigger(params, []byte(`{"repository":{"clone_url":"https://github.com/owner/other.git"}}`)) {
+		t.Error("Expected a delivery for another repository not to concern the trigger")
+	}
+
+	generic := triggerParams{WebhookType: webhookTypeGeneric, IdentityPath: "$.repository.name", IdentityValue: "app"}
+	if !deliveryConcernsTrigger(generic, []byte(`{"repository":{"name":"app"}}`)) {
+		t.Error("Expected a delivery with the generic webhook's identity to concern it")
+	}
+	if deliveryConcernsTrigger(generic, []byte(`not json`)) {
+		t.Error("Expected a delivery that is not JSON not to concern a generic webhook")
+	}
+}
+
+func TestNewRecordedDelivery(t *testing.T) {
+	header := http.Header{
+		"X-Github-Event":    {"push"},
+		"X-Github-Delivery": {"a-delivery-id"},
+		"Authorization":     {"Bearer token"},
+		"Wext-Trigger-Name": {"hook-green-push-event"},
+	}
+	delivery := newRecordedDelivery(header, []byte("{}"))
+	if delivery.ID != "a-delivery-id" || delivery.Event != "push" || delivery.Payload != "{}" {
+		t.Errorf("Recorded delivery was %+v", delivery)
+	}
+	if _, found := delivery.Header["Authorization"]; found || len(delivery.Header) != 2 {
+		t.Errorf("Recorded headers were %v, expected Authorization and Wext- headers to be dropped", delivery.Header)
+	}
+
+	header.Del("X-Github-Delivery")
+	if id := newRecordedDelivery(header, []byte("{}")).ID; !strings.HasPrefix(id, "sha256-") || len(id) != 23 {
+		t.Errorf("Delivery without an ID was given %s, expected one from its payload", id)
+	}
+}
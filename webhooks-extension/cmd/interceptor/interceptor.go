@@ -14,21 +14,31 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/github"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/credentialstore"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 const (
 	envSecret = "GITHUB_SECRET_TOKEN"
+	// Set by the extension on the deliveries it sends for a webhook's schedules, or replays,
+	// naming the only triggers, comma separated, that should process them
+	targetTriggersHeader = "X-Webhooks-Tekton-Trigger"
+	// Set by the extension on the deliveries it sends for a webhook's schedules
+	scheduleHeader = "X-Webhooks-Tekton-Schedule"
+	// Set by the extension on the deliveries it replays, holding the ID of the original delivery
+	replayOfHeader = "X-Webhooks-Tekton-Replay-Of"
 )
 
 type Result struct {
@@ -42,137 +52,276 @@ type PushPayload struct {
 	github.PushEvent
 	WebhookBranch            string `json:"webhooks-tekton-git-branch"`
 	WebhookSuggestedImageTag string `json:"webhooks-tekton-image-tag"`
+	WebhookSupersedeKey      string `json:"webhooks-tekton-supersede-key"`
 }
 
 type PullRequestPayload struct {
 	github.PullRequestEvent
 	WebhookBranch            string `json:"webhooks-tekton-git-branch"`
 	WebhookSuggestedImageTag string `json:"webhooks-tekton-image-tag"`
+	WebhookSupersedeKey      string `json:"webhooks-tekton-supersede-key"`
 }
 
+// The values configured on each trigger by the extension. These arrive as Wext-* headers
+// from a v1alpha1 webhook interceptor, or as interceptor params in an InterceptorRequest.
+type triggerParams struct {
+	TriggerName     string
+	RepositoryURL   string
+	IncomingEvent   string
+	IncomingActions string
+	SecretName      string
+	// Only set when the webhook caps its in-flight PipelineRuns
+	MaxConcurrentRuns string
+	// Only set when the webhook cancels in-flight PipelineRuns superseded by a new commit
+	CancelSuperseded bool
+	// Where the webhook's PipelineRuns are, set when either of the above is
+	ConcurrencyNamespace string
+	ConcurrencySelector  string
+	// Only set when the webhook has its own allowlist of source ranges
+	AllowedSourceRanges string
+	// Only set for webhooks on event sources other than a Git provider
+	WebhookType        string
+	SignatureHeader    string
+	SignatureAlgorithm string
+	IdentityPath       string
+	IdentityValue      string
+}
+
+// The outcome of validating a delivery for a trigger. Payload is only set when the trigger
+// should be processed, in which case Status is http.StatusOK.
+type decision struct {
+	Status  int
+	Message string
+	Payload []byte
+}
+
+// Rate limits and concurrency caps, configured from the environment
+var limits = getDeliveryLimitsFromEnv()
+
 func main() {
 	log.Print("Interceptor started")
 
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		foundTriggerName := request.Header.Get("Wext-Trigger-Name")
+	http.HandleFunc("/", handleWebhookInterceptorRequest)
+	http.HandleFunc(interceptorRequestPath, handleInterceptorRequest)
 
-		config, err := rest.InClusterConfig()
-		if err != nil {
-			log.Printf("[%s] Error creating in cluster config: %s", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
-		}
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", 8080), nil))
+}
 
-		clientset, err := kubernetes.NewForConfig(config)
+// Handles the v1alpha1 webhook interceptor contract, the trigger configuration is read from the
+// Wext-* headers and the payload with extras is written back as the body of the response.
+func handleWebhookInterceptorRequest(writer http.ResponseWriter, request *http.Request) {
+	params := getTriggerParamsFromHeaders(request.Header)
+
+	// Read so that the payload can be recorded once it has been validated
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Printf("[%s] Error reading payload: %s", params.TriggerName, err.Error())
+		http.Error(writer, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(payload))
+
+	var result decision
+	secretToken, status, err := getSecretToken(params.TriggerName, params.SecretName)
+	if err != nil {
+		result = decision{Status: status, Message: fmt.Sprint(err)}
+	} else {
+		result = processDelivery(params, request, secretToken)
+	}
+	events.emit(params, request.Header, result)
+	history.record(params, request.Header, payload, result)
+
+	if result.Status == http.StatusOK {
+		log.Printf("[%s] Validation PASS so writing response", params.TriggerName)
+		_, err = writer.Write(result.Payload)
 		if err != nil {
-			log.Printf("[%s] Error creating new clientset: %s", foundTriggerName, err.Error())
+			log.Printf("[%s] Failed to write response for Github event ID: %s. Error: %s", params.TriggerName, github.DeliveryID(request), err.Error())
 			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
 		}
+		return
+	}
+	if result.Status < http.StatusMultipleChoices {
+		// Any status other than 200 stops the eventlistener from processing the trigger
+		writer.WriteHeader(result.Status)
+		fmt.Fprint(writer, result.Message)
+		return
+	}
+	http.Error(writer, result.Message, result.Status)
+}
 
-		foundNamespace := os.Getenv("INSTALLED_NAMESPACE")
-		foundSecretName := request.Header.Get("Wext-Secret-Name")
+func getTriggerParamsFromHeaders(header http.Header) triggerParams {
+	return triggerParams{
+		TriggerName:     header.Get("Wext-Trigger-Name"),
+		RepositoryURL:   header.Get("Wext-Repository-Url"),
+		IncomingEvent:   header.Get("Wext-Incoming-Event"),
+		IncomingActions: header.Get("Wext-Incoming-Actions"),
+		SecretName:      header.Get("Wext-Secret-Name"),
 
-		foundSecret, err := clientset.CoreV1().Secrets(foundNamespace).Get(foundSecretName, metav1.GetOptions{})
+		MaxConcurrentRuns:    header.Get("Wext-Max-Concurrent-Runs"),
+		CancelSuperseded:     header.Get("Wext-Cancel-Superseded") == "true",
+		ConcurrencyNamespace: header.Get("Wext-Concurrency-Namespace"),
+		ConcurrencySelector:  header.Get("Wext-Concurrency-Selector"),
 
-		if err != nil {
-			log.Printf("[%s] Error getting the secret %s to validate: %s", foundTriggerName, foundSecretName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusBadRequest)
-			return
-		}
+		AllowedSourceRanges: header.Get("Wext-Allowed-Source-Ranges"),
 
-		wantedRepoURL := request.Header.Get("Wext-Repository-Url")
+		WebhookType:        header.Get("Wext-Webhook-Type"),
+		SignatureHeader:    header.Get("Wext-Signature-Header"),
+		SignatureAlgorithm: header.Get("Wext-Signature-Algorithm"),
+		IdentityPath:       header.Get("Wext-Identity-Path"),
+		IdentityValue:      header.Get("Wext-Identity-Value"),
+	}
+}
 
-		payload, err := github.ValidatePayload(request, foundSecret.Data["secretToken"])
-		if err != nil {
-			log.Printf("[%s] Validation FAIL (error %s validating payload)", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
-			return
-		}
+// Returns the secretToken from the named secret in the install namespace, and on error the
+// http status that should be returned to the caller
+func getSecretToken(triggerName, secretName string) ([]byte, int, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("[%s] Error creating in cluster config: %s", triggerName, err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
 
-		var result Result
-		err = json.Unmarshal(payload, &result)
-		if err != nil {
-			log.Printf("[%s] Validation FAIL (error %s marshalling payload as JSON)", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
-		}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Printf("[%s] Error creating new clientset: %s", triggerName, err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
 
-		cloneURL := result.Repository.CloneURL
-		log.Printf("[%s] Clone URL coming in as JSON: %s", foundTriggerName, cloneURL)
+	store, err := credentialstore.NewFromEnv(clientset, os.Getenv("INSTALLED_NAMESPACE"))
+	if err != nil {
+		log.Printf("[%s] Error creating the credential store: %s", triggerName, err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	_, secretToken, err := store.GetTokens(secretName)
+	if err != nil {
+		log.Printf("[%s] Error getting the secret %s to validate from the %s credential store: %s", triggerName, secretName, store.Backend(), err.Error())
+		return nil, http.StatusBadRequest, err
+	}
+	return []byte(secretToken), http.StatusOK, nil
+}
 
-		id := github.DeliveryID(request)
-		if err != nil {
-			log.Printf("[%s] Error handling GitHub Event with delivery ID %s: %s", foundTriggerName, id, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
-		}
+// Checks the source address of the delivery, validates it and, if it passes, applies the configured limits
+func processDelivery(params triggerParams, request *http.Request, secretToken []byte) decision {
+	sourceRanges, err := getSourceRangeConfig(params.TriggerName)
+	if err != nil {
+		return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+	}
+	if allowed := checkSourceAddress(params, request, sourceRanges, getGitHubHookRanges); allowed.Status != http.StatusOK {
+		return allowed
+	}
 
-		log.Printf("[%s] Handling GitHub Event with delivery ID: %s", foundTriggerName, id)
-
-		validationPassed := false
-
-		if sanitizeGitInput(cloneURL) == sanitizeGitInput(wantedRepoURL) {
-			if request.Header.Get("Wext-Incoming-Event") != "" {
-				wantedEvent := request.Header.Get("Wext-Incoming-Event")
-				foundEvent := request.Header.Get("X-Github-Event")
-				if wantedEvent == foundEvent { // Wanted GitHub event type provided AND repository URL matches so all is well
-					wantedActions := request.Header["Wext-Incoming-Actions"]
-					if len(wantedActions) == 0 {
-						validationPassed = true
-						log.Printf("[%s] Validation PASS (repository URL, secret payload, event type checked)", foundTriggerName)
-					} else {
-						actions := strings.Split(wantedActions[0], ",")
-						for _, action := range actions {
-							if action == result.Action {
-								validationPassed = true
-								log.Printf("[%s] Validation PASS (repository URL, secret payload, event type, action:%s checked)", foundTriggerName, action)
-							}
-						}
-					}
-				} else {
-					log.Printf("[%s] Validation FAIL (event type does not match, got %s but wanted %s)", foundTriggerName, foundEvent, wantedEvent)
-					http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
-					return
-				}
-			} else { // No wanted GitHub event type provided, but the repository URL matches so all is well
-				log.Printf("[%s] Validation PASS (repository URL and secret payload checked)", foundTriggerName)
-				validationPassed = true
-			}
+	result := validateDelivery(params, request, secretToken)
+	if result.Status != http.StatusOK {
+		return result
+	}
+	return admitDelivery(params, request, result)
+}
 
-			if validationPassed {
-				returnPayload, err := addExtrasToPayload(request.Header.Get("X-Github-Event"), payload)
-				if err != nil {
-					log.Printf("[%s] Failed to add branch to payload processing Github event ID: %s. Error: %s", foundTriggerName, id, err.Error())
-					http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-					return
-				}
+// Applies the configured limits to a delivery that passed validation and, if it is admitted,
+// cancels the webhook's runs it supersedes
+func admitDelivery(params triggerParams, request *http.Request, result decision) decision {
+	if limited := limits.check(params, github.DeliveryID(request)); limited.Status != http.StatusOK {
+		return limited
+	}
+	// A replay is of an older delivery, so it must not cancel the runs of newer commits
+	if params.CancelSuperseded && request.Header.Get(replayOfHeader) == "" {
+		cancelSuperseded(params, request.Header.Get("X-Github-Event"), result.Payload, time.Now())
+	}
+	return result
+}
+
+// Validates the delivery in the request against the trigger's configuration: the payload signature,
+// the repository URL, the event type and the actions. Deliveries asking to skip ci are not processed.
+func validateDelivery(params triggerParams, request *http.Request, secretToken []byte) decision {
+	// Scheduled and replayed deliveries sent by the extension are for a single webhook's triggers
+	if forTriggers := request.Header.Get(targetTriggersHeader); forTriggers != "" && !containsString(strings.Split(forTriggers, ","), params.TriggerName) {
+		log.Printf("[%s] Skipping delivery for triggers %s", params.TriggerName, forTriggers)
+		return decision{Status: http.StatusAccepted, Message: fmt.Sprintf("skipped: delivery for triggers %s", forTriggers)}
+	}
+
+	if params.WebhookType == webhookTypeGeneric {
+		return validateGenericDelivery(params, request, secretToken)
+	}
+
+	foundTriggerName := params.TriggerName
+	wantedRepoURL := params.RepositoryURL
+
+	payload, err := github.ValidatePayload(request, secretToken)
+	if err != nil {
+		log.Printf("[%s] Validation FAIL (error %s validating payload)", foundTriggerName, err.Error())
+		return decision{Status: http.StatusExpectationFailed, Message: fmt.Sprint(err)}
+	}
+
+	var result Result
+	err = json.Unmarshal(payload, &result)
+	if err != nil {
+		log.Printf("[%s] Validation FAIL (error %s marshalling payload as JSON)", foundTriggerName, err.Error())
+		return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+	}
+
+	cloneURL := result.Repository.CloneURL
+	log.Printf("[%s] Clone URL coming in as JSON: %s", foundTriggerName, cloneURL)
+
+	id := github.DeliveryID(request)
+	log.Printf("[%s] Handling GitHub Event with delivery ID: %s", foundTriggerName, id)
+
+	if sanitizeGitInput(cloneURL) != sanitizeGitInput(wantedRepoURL) {
+		log.Printf("[%s] Validation FAIL (repository URL does not match, got %s but wanted %s): ",
+			foundTriggerName,
+			sanitizeGitInput(cloneURL),
+			sanitizeGitInput(wantedRepoURL))
+		return decision{Status: http.StatusExpectationFailed, Message: "Validation failed, repository URL does not match"}
+	}
 
-				log.Printf("[%s] Validation PASS so writing response", foundTriggerName)
-				_, err = writer.Write(returnPayload)
-				if err != nil {
-					log.Printf("[%s] Failed to write response for Github event ID: %s. Error: %s", foundTriggerName, id, err.Error())
-					http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-					return
+	validationPassed := false
+	foundEvent := request.Header.Get("X-Github-Event")
+	if params.IncomingEvent != "" {
+		wantedEvent := params.IncomingEvent
+		if wantedEvent != foundEvent {
+			log.Printf("[%s] Validation FAIL (event type does not match, got %s but wanted %s)", foundTriggerName, foundEvent, wantedEvent)
+			return decision{Status: http.StatusExpectationFailed, Message: "Validation failed, event type does not match"}
+		}
+		// Wanted GitHub event type provided AND repository URL matches so all is well
+		if params.IncomingActions == "" {
+			validationPassed = true
+			log.Printf("[%s] Validation PASS (repository URL, secret payload, event type checked)", foundTriggerName)
+		} else {
+			actions := strings.Split(params.IncomingActions, ",")
+			for _, action := range actions {
+				if action == result.Action {
+					validationPassed = true
+					log.Printf("[%s] Validation PASS (repository URL, secret payload, event type, action:%s checked)", foundTriggerName, action)
 				}
-			} else {
-				http.Error(writer, "Validation failed", http.StatusExpectationFailed)
 			}
-		} else {
-			log.Printf("[%s] Validation FAIL (repository URL does not match, got %s but wanted %s): ",
-				foundTriggerName,
-				sanitizeGitInput(cloneURL),
-				sanitizeGitInput(wantedRepoURL))
-
-			http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
-			return
 		}
-	})
+	} else { // No wanted GitHub event type provided, but the repository URL matches so all is well
+		log.Printf("[%s] Validation PASS (repository URL and secret payload checked)", foundTriggerName)
+		validationPassed = true
+	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", 8080), nil))
+	if !validationPassed {
+		return decision{Status: http.StatusExpectationFailed, Message: "Validation failed"}
+	}
+
+	skip, directive, err := shouldSkipCI(foundEvent, payload, getSkipCIDirectives())
+	if err != nil {
+		log.Printf("[%s] Failed to check for skip directives processing Github event ID: %s. Error: %s", foundTriggerName, id, err.Error())
+		return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+	}
+	if skip {
+		log.Printf("[%s] Skipping Github event ID: %s as the directive %s was found", foundTriggerName, id, directive)
+		return decision{Status: http.StatusAccepted, Message: fmt.Sprintf("skipped: %s directive found", directive)}
+	}
+
+	returnPayload, err := addExtrasToPayload(foundEvent, payload)
+	if err != nil {
+		log.Printf("[%s] Failed to add branch to payload processing Github event ID: %s. Error: %s", foundTriggerName, id, err.Error())
+		return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+	}
+	return decision{Status: http.StatusOK, Payload: returnPayload}
 }
 
-// Adds branch and a suggested image tag
+// Adds branch, a suggested image tag and the key of the runs a delivery supersedes
 func addExtrasToPayload(event string, payload []byte) ([]byte, error) {
 	if "push" == event {
 		var toReturn PushPayload
@@ -185,6 +334,7 @@ func addExtrasToPayload(event string, payload []byte) ([]byte, error) {
 			PushEvent:                p,
 			WebhookBranch:            p.GetRef()[strings.LastIndex(p.GetRef(), "/")+1:],
 			WebhookSuggestedImageTag: getSuggestedTag(p.GetRef(), *p.HeadCommit.ID),
+			WebhookSupersedeKey:      p.GetRef(),
 		}
 		return json.Marshal(toReturn)
 	} else if "pull_request" == event {
@@ -199,6 +349,7 @@ func addExtrasToPayload(event string, payload []byte) ([]byte, error) {
 			PullRequestEvent:         pr,
 			WebhookBranch:            ref[strings.LastIndex(ref, "/")+1:],
 			WebhookSuggestedImageTag: getSuggestedTag(ref, *pr.PullRequest.Head.SHA),
+			WebhookSupersedeKey:      pullRequestKey(pr),
 		}
 		return json.Marshal(toReturn)
 	} else {
@@ -206,6 +357,26 @@ func addExtrasToPayload(event string, payload []byte) ([]byte, error) {
 	}
 }
 
+// Identifies a pull request by its number and the repository it was opened against, empty
+// if either is missing
+func pullRequestKey(pr github.PullRequestEvent) string {
+	repo := pr.GetPullRequest().GetBase().GetRepo().GetFullName()
+	number := pr.GetPullRequest().GetNumber()
+	if repo == "" || number == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s#%d", repo, number)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
 func sanitizeGitInput(input string) string {
 	noGitSuffix := strings.TrimSuffix(input, ".git")
 	asLower := strings.ToLower(noGitSuffix)
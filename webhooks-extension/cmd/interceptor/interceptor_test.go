@@ -51,6 +51,9 @@ func TestAddExtrasToPushPayload(t *testing.T) {
 	if "12dee23" != p.WebhookSuggestedImageTag {
 		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p.WebhookSuggestedImageTag)
 	}
+	if "refs/heads/master" != p.WebhookSupersedeKey {
+		t.Errorf("Supersede key not added as expected, key was returned as %s", p.WebhookSupersedeKey)
+	}
 }
 
 func TestAddExtrasToPushPayloadForTag(t *testing.T) {
@@ -92,12 +95,18 @@ func TestAddExtrasToPullRequestPayload(t *testing.T) {
 
 	ref := "refs/heads/master"
 	commit := "9h3f39fu3hf39uh33"
+	number := 42
+	fullName := "owner/repo"
 	pullrequestPayloadStruct := github.PullRequestEvent{
 		PullRequest: &github.PullRequest{
+			Number: &number,
 			Head: &github.PullRequestBranch{
 				Ref: &ref,
 				SHA: &commit,
 			},
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{FullName: &fullName},
+			},
 		},
 	}
 
@@ -123,6 +132,9 @@ func TestAddExtrasToPullRequestPayload(t *testing.T) {
 	if "9h3f39f" != p.WebhookSuggestedImageTag {
 		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p.WebhookSuggestedImageTag)
 	}
+	if "owner/repo#42" != p.WebhookSupersedeKey {
+		t.Errorf("Supersede key not added as expected, key was returned as %s", p.WebhookSupersedeKey)
+	}
 }
 
 func TestAddExtrasToOtherEventPayload(t *testing.T) {
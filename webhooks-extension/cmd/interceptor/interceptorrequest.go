@@ -0,0 +1,203 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// The path a Triggers ClusterInterceptor should reference to use the InterceptorRequest protocol
+	interceptorRequestPath = "/interceptor"
+)
+
+// Status codes used by Triggers in an InterceptorResponse, these are gRPC codes
+const (
+	codeOK                 = 0
+	codeInvalidArgument    = 3
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeInternal           = 13
+)
+
+// The extensions added to an InterceptorResponse, named as the fields added to the payload by
+// addExtrasToPayload so bindings only need to switch from $(body.x) to $(extensions.x)
+var extensionKeys = []string{"webhooks-tekton-git-branch", "webhooks-tekton-image-tag", "webhooks-tekton-supersede-key"}
+
+// InterceptorRequest is the request sent by a Triggers EventListener to a ClusterInterceptor
+type InterceptorRequest struct {
+	Body              string                 `json:"body,omitempty"`
+	Header            map[string][]string    `json:"header,omitempty"`
+	Extensions        map[string]interface{} `json:"extensions,omitempty"`
+	InterceptorParams map[string]interface{} `json:"interceptor_params,omitempty"`
+	Context           *TriggerContext        `json:"context"`
+}
+
+// TriggerContext identifies the event and trigger an InterceptorRequest is for
+type TriggerContext struct {
+	EventURL  string `json:"event_url,omitempty"`
+	EventID   string `json:"event_id,omitempty"`
+	TriggerID string `json:"trigger_id,omitempty"`
+}
+
+// InterceptorResponse is returned to the EventListener, Continue decides whether the trigger is processed
+type InterceptorResponse struct {
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+	Continue   bool                   `json:"continue"`
+	Status     Status                 `json:"status"`
+}
+
+// Status explains the decision in an InterceptorResponse
+type Status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Handles the Triggers InterceptorRequest contract. The trigger configuration is read from the
+// interceptor params, which use the same names as the Wext-* headers of the webhook interceptor.
+// The response is always a 200 carrying an InterceptorResponse, as Triggers expects.
+func handleInterceptorRequest(writer http.ResponseWriter, request *http.Request) {
+	var interceptorRequest InterceptorRequest
+	if err := json.NewDecoder(request.Body).Decode(&interceptorRequest); err != nil {
+		log.Printf("Error decoding InterceptorRequest: %s", err.Error())
+		http.Error(writer, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	params := getTriggerParamsFromInterceptorParams(interceptorRequest.InterceptorParams)
+
+	var result decision
+	secretToken, status, err := getSecretToken(params.TriggerName, params.SecretName)
+	if err != nil {
+		result = decision{Status: status, Message: fmt.Sprint(err)}
+	} else {
+		deliveryRequest, err := interceptorRequest.toHTTPRequest()
+		if err != nil {
+			result = decision{Status: http.StatusBadRequest, Message: fmt.Sprint(err)}
+		} else {
+			result = processDelivery(params, deliveryRequest, secretToken)
+		}
+	}
+	events.emit(params, interceptorRequest.canonicalHeader(), result)
+	history.record(params, interceptorRequest.canonicalHeader(), []byte(interceptorRequest.Body), result)
+
+	response, err := toInterceptorResponse(result)
+	if err != nil {
+		log.Printf("[%s] Failed to create InterceptorResponse: %s", params.TriggerName, err.Error())
+		http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		log.Printf("[%s] Failed to write InterceptorResponse: %s", params.TriggerName, err.Error())
+	}
+}
+
+func getTriggerParamsFromInterceptorParams(interceptorParams map[string]interface{}) triggerParams {
+	get := func(name string) string {
+		if value, ok := interceptorParams[name].(string); ok {
+			return value
+		}
+		return ""
+	}
+	return triggerParams{
+		TriggerName:     get("Wext-Trigger-Name"),
+		RepositoryURL:   get("Wext-Repository-Url"),
+		IncomingEvent:   get("Wext-Incoming-Event"),
+		IncomingActions: get("Wext-Incoming-Actions"),
+		SecretName:      get("Wext-Secret-Name"),
+
+		MaxConcurrentRuns:    get("Wext-Max-Concurrent-Runs"),
+		CancelSuperseded:     get("Wext-Cancel-Superseded") == "true",
+		ConcurrencyNamespace: get("Wext-Concurrency-Namespace"),
+		ConcurrencySelector:  get("Wext-Concurrency-Selector"),
+
+		AllowedSourceRanges: get("Wext-Allowed-Source-Ranges"),
+
+		WebhookType:        get("Wext-Webhook-Type"),
+		SignatureHeader:    get("Wext-Signature-Header"),
+		SignatureAlgorithm: get("Wext-Signature-Algorithm"),
+		IdentityPath:       get("Wext-Identity-Path"),
+		IdentityValue:      get("Wext-Identity-Value"),
+	}
+}
+
+// Rebuilds the original delivery so it can be validated exactly as one arriving via the webhook interceptor
+func (ir InterceptorRequest) toHTTPRequest() (*http.Request, error) {
+	request, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(ir.Body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header = ir.canonicalHeader()
+	return request, nil
+}
+
+func (ir InterceptorRequest) canonicalHeader() http.Header {
+	header := http.Header{}
+	for name, values := range ir.Header {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	return header
+}
+
+func toInterceptorResponse(result decision) (InterceptorResponse, error) {
+	if result.Status != http.StatusOK {
+		return InterceptorResponse{
+			Continue: false,
+			Status:   Status{Code: statusCodeFor(result.Status), Message: result.Message},
+		}, nil
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(result.Payload, &payload); err != nil {
+		return InterceptorResponse{}, err
+	}
+	extensions := make(map[string]interface{})
+	for _, key := range extensionKeys {
+		if value, ok := payload[key]; ok {
+			extensions[key] = value
+		}
+	}
+	return InterceptorResponse{
+		Extensions: extensions,
+		Continue:   true,
+		Status:     Status{Code: codeOK},
+	}, nil
+}
+
+// Maps the http status used by the webhook interceptor onto the code for an InterceptorResponse
+func statusCodeFor(httpStatus int) int {
+	switch httpStatus {
+	case http.StatusOK:
+		return codeOK
+	case http.StatusBadRequest:
+		return codeInvalidArgument
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusTooManyRequests:
+		return codeResourceExhausted
+	case http.StatusAccepted, http.StatusExpectationFailed:
+		return codeFailedPrecondition
+	default:
+		return codeInternal
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+const testSecretToken = "aSecretTokenForTesting"
+
+func TestInterceptorRequestContinues(t *testing.T) {
+	body := `{"ref":"refs/heads/master","head_commit":{"id":"1234567890abcdef","message":"A change"},"repository":{"clone_url":"https://github.com/owner/repo.git"}}`
+	ir := signedInterceptorRequest(body, "push")
+
+	response := interceptorResponseFor(ir, t)
+	if !response.Continue || response.Status.Code != codeOK {
+		t.Fatalf("Expected the InterceptorResponse to continue, got %+v", response)
+	}
+	if response.Extensions["webhooks-tekton-git-branch"] != "master" {
+		t.Errorf("Branch extension was %v but expected master", response.Extensions["webhooks-tekton-git-branch"])
+	}
+	if response.Extensions["webhooks-tekton-image-tag"] != "1234567" {
+		t.Errorf("Image tag extension was %v but expected 1234567", response.Extensions["webhooks-tekton-image-tag"])
+	}
+}
+
+func TestInterceptorRequestRepositoryMismatch(t *testing.T) {
+	body := `{"ref":"refs/heads/master","head_commit":{"id":"1234567890abcdef"},"repository":{"clone_url":"https://github.com/owner/other.git"}}`
+	ir := signedInterceptorRequest(body, "push")
+
+	response := interceptorResponseFor(ir, t)
+	if response.Continue || response.Status.Code != codeFailedPrecondition {
+		t.Errorf("Expected the InterceptorResponse not to continue with a failed precondition, got %+v", response)
+	}
+}
+
+func TestInterceptorRequestBadSignature(t *testing.T) {
+	body := `{"ref":"refs/heads/master","head_commit":{"id":"1234567890abcdef"},"repository":{"clone_url":"https://github.com/owner/repo.git"}}`
+	ir := signedInterceptorRequest(body, "push")
+	ir.Header["X-Hub-Signature"] = []string{"sha1=0000"}
+
+	response := interceptorResponseFor(ir, t)
+	if response.Continue {
+		t.Errorf("Expected the InterceptorResponse not to continue for a bad signature, got %+v", response)
+	}
+}
+
+func TestInterceptorRequestSkipped(t *testing.T) {
+	body := `{"ref":"refs/heads/master","head_commit":{"id":"1234567890abcdef","message":"Docs [skip ci]"},"repository":{"clone_url":"https://github.com/owner/repo.git"}}`
+	ir := signedInterceptorRequest(body, "push")
+
+	response := interceptorResponseFor(ir, t)
+	if response.Continue || response.Status.Code != codeFailedPrecondition {
+		t.Errorf("Expected the InterceptorResponse not to continue for a skipped delivery, got %+v", response)
+	}
+}
+
+func TestInterceptorRequestScheduledForAnotherTrigger(t *testing.T) {
+	body := `{"ref":"refs/heads/master","head_commit":{"id":"1234567890abcdef","message":"Scheduled run"},"repository":{"clone_url":"https://github.com/owner/repo.git"}}`
+	ir := signedInterceptorRequest(body, "push")
+	ir.Header[targetTriggersHeader] = []string{"other-namespace-push-event"}
+
+	response := interceptorResponseFor(ir, t)
+	if response.Continue || response.Status.Code != codeFailedPrecondition {
+		t.Errorf("Expected the InterceptorResponse not to continue for another trigger's scheduled delivery, got %+v", response)
+	}
+
+	ir.Header[targetTriggersHeader] = []string{"name-namespace-push-event"}
+	if response := interceptorResponseFor(ir, t); !response.Continue {
+		t.Errorf("Expected the InterceptorResponse to continue for the trigger's scheduled delivery, got %+v", response)
+	}
+}
+
+func signedInterceptorRequest(body, event string) InterceptorRequest {
+	mac := hmac.New(sha1.New, []byte(testSecretToken))
+	mac.Write([]byte(body))
+	return InterceptorRequest{
+		Body: body,
+		Header: map[string][]string{
+			"Content-Type":      {"application/json"},
+			"X-Github-Event":    {event},
+			"X-Github-Delivery": {"a-delivery-id"},
+			"X-Hub-Signature":   {"sha1=" + hex.EncodeToString(mac.Sum(nil))},
+		},
+		InterceptorParams: map[string]interface{}{
+			"Wext-Trigger-Name":   "name-namespace-push-event",
+			"Wext-Repository-Url": "https://github.com/owner/repo",
+			"Wext-Incoming-Event": event,
+			"Wext-Secret-Name":    "secret",
+		},
+	}
+}
+
+func interceptorResponseFor(ir InterceptorRequest, t *testing.T) InterceptorResponse {
+	request, err := ir.toHTTPRequest()
+	if err != nil {
+		t.Fatalf("Error in toHTTPRequest %s", err)
+	}
+	result := validateDelivery(getTriggerParamsFromInterceptorParams(ir.InterceptorParams), request, []byte(testSecretToken))
+	response, err := toInterceptorResponse(result)
+	if err != nil {
+		t.Fatalf("Error in toInterceptorResponse %s", err)
+	}
+	return response
+}
@@ -0,0 +1,190 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektoncdclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"knative.dev/pkg/apis"
+)
+
+const (
+	// Deliveries per minute allowed for each repository, and the burst above that rate
+	envRepositoryRateLimit = "REPOSITORY_RATE_LIMIT"
+	envRepositoryRateBurst = "REPOSITORY_RATE_BURST"
+	// Deliveries per minute allowed for each trigger, and the burst above that rate
+	envTriggerRateLimit = "TRIGGER_RATE_LIMIT"
+	envTriggerRateBurst = "TRIGGER_RATE_BURST"
+
+	// How many repository decisions are remembered, see deliveryLimits
+	maxRememberedDeliveries = 1000
+)
+
+// Token bucket limits keyed by repository or trigger name. A rate of zero means no limit.
+type rateLimiter struct {
+	lock      sync.Mutex
+	perMinute float64
+	burst     int
+	limiters  map[string]*rate.Limiter
+}
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(perMinute)))
+	}
+	return &rateLimiter{perMinute: perMinute, burst: burst, limiters: make(map[string]*rate.Limiter)}
+}
+
+func (rl *rateLimiter) allow(key string) bool {
+	if rl.perMinute <= 0 {
+		return true
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	limiter, found := rl.limiters[key]
+	if !found {
+		limiter = rate.NewLimiter(rate.Limit(rl.perMinute/60), rl.burst)
+		rl.limiters[key] = limiter
+	}
+	return limiter.Allow()
+}
+
+// The limits applied to deliveries that pass validation. The eventlistener sends each delivery
+// once for every trigger, so the repository decision is remembered by delivery ID to make sure
+// a delivery only takes a single token from its repository's bucket.
+type deliveryLimits struct {
+	repository *rateLimiter
+	trigger    *rateLimiter
+
+	lock                sync.Mutex
+	repositoryDecisions map[string]bool
+	deliveryOrder       []string
+
+	countInFlight func(namespace, selector string) (int, error)
+}
+
+func newDeliveryLimits(repository, trigger *rateLimiter) *deliveryLimits {
+	return &deliveryLimits{
+		repository:          repository,
+		trigger:             trigger,
+		repositoryDecisions: make(map[string]bool),
+		countInFlight:       countInFlightPipelineRuns,
+	}
+}
+
+func getDeliveryLimitsFromEnv() *deliveryLimits {
+	return newDeliveryLimits(
+		newRateLimiter(getFloatFromEnv(envRepositoryRateLimit), int(getFloatFromEnv(envRepositoryRateBurst))),
+		newRateLimiter(getFloatFromEnv(envTriggerRateLimit), int(getFloatFromEnv(envTriggerRateBurst))))
+}
+
+func getFloatFromEnv(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Ignoring %s as %s is not a number", name, value)
+		return 0
+	}
+	return parsed
+}
+
+// Checks the rate limits and the in-flight PipelineRun cap for a delivery that passed validation.
+// Returns a decision with http.StatusOK if the trigger can be processed.
+func (l *deliveryLimits) check(params triggerParams, deliveryID string) decision {
+	repository := sanitizeGitInput(params.RepositoryURL)
+	if !l.allowRepository(repository, deliveryID) {
+		log.Printf("[%s] Rate limit hit for repository %s, rejecting Github event ID: %s", params.TriggerName, repository, deliveryID)
+		return decision{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("rate limit exceeded for repository %s", repository)}
+	}
+
+	if !l.trigger.allow(params.TriggerName) {
+		log.Printf("[%s] Rate limit hit for trigger, rejecting Github event ID: %s", params.TriggerName, deliveryID)
+		return decision{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("rate limit exceeded for trigger %s", params.TriggerName)}
+	}
+
+	if params.MaxConcurrentRuns != "" {
+		maxRuns, err := strconv.Atoi(params.MaxConcurrentRuns)
+		if err != nil {
+			log.Printf("[%s] Ignoring invalid maximum concurrent runs %s", params.TriggerName, params.MaxConcurrentRuns)
+			return decision{Status: http.StatusOK}
+		}
+		inFlight, err := l.countInFlight(params.ConcurrencyNamespace, params.ConcurrencySelector)
+		if err != nil {
+			log.Printf("[%s] Error counting in-flight PipelineRuns: %s", params.TriggerName, err.Error())
+			return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+		}
+		if inFlight >= maxRuns {
+			log.Printf("[%s] Concurrency cap hit with %d of %d PipelineRuns in flight, rejecting Github event ID: %s", params.TriggerName, inFlight, maxRuns, deliveryID)
+			return decision{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("%d PipelineRuns already in flight, the maximum is %d", inFlight, maxRuns)}
+		}
+	}
+	return decision{Status: http.StatusOK}
+}
+
+func (l *deliveryLimits) allowRepository(repository, deliveryID string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := repository + "/" + deliveryID
+	if allowed, found := l.repositoryDecisions[key]; found && deliveryID != "" {
+		return allowed
+	}
+
+	allowed := l.repository.allow(repository)
+	l.repositoryDecisions[key] = allowed
+	l.deliveryOrder = append(l.deliveryOrder, key)
+	if len(l.deliveryOrder) > maxRememberedDeliveries {
+		delete(l.repositoryDecisions, l.deliveryOrder[0])
+		l.deliveryOrder = l.deliveryOrder[1:]
+	}
+	return allowed
+}
+
+// Counts the PipelineRuns matching the selector in the namespace that have not yet completed,
+// other than those already being cancelled
+func countInFlightPipelineRuns(namespace, selector string) (int, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return 0, err
+	}
+	tektonClient, err := tektoncdclientset.NewForConfig(config)
+	if err != nil {
+		return 0, err
+	}
+	pipelineRuns, err := tektonClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return 0, err
+	}
+	inFlight := 0
+	for _, pipelineRun := range pipelineRuns.Items {
+		if pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsUnknown() && pipelineRun.Spec.Status != v1alpha1.PipelineRunSpecStatusCancelled {
+			inFlight++
+		}
+	}
+	return inFlight, nil
+}
@@ -0,0 +1,98 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	rl := newRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if !rl.allow("key") {
+			t.Fatalf("Rate limiter with no rate denied request %d", i)
+		}
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	rl := newRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !rl.allow("key") {
+			t.Fatalf("Rate limiter denied request %d within the burst", i)
+		}
+	}
+	if rl.allow("key") {
+		t.Error("Rate limiter allowed a request beyond the burst")
+	}
+	if !rl.allow("other-key") {
+		t.Error("Rate limiter denied the first request for a different key")
+	}
+}
+
+func TestRepositoryLimitTakesOneTokenPerDelivery(t *testing.T) {
+	limits := newDeliveryLimits(newRateLimiter(1, 1), newRateLimiter(0, 0))
+	params := triggerParams{TriggerName: "name-ns-push-event", RepositoryURL: "https://github.com/owner/repo"}
+
+	// The same delivery arrives once for each trigger on the repository
+	for _, trigger := range []string{"name-ns-push-event", "other-ns-push-event"} {
+		params.TriggerName = trigger
+		if result := limits.check(params, "delivery-1"); result.Status != http.StatusOK {
+			t.Errorf("Delivery was rejected for trigger %s with status %d", trigger, result.Status)
+		}
+	}
+
+	if result := limits.check(params, "delivery-2"); result.Status != http.StatusTooManyRequests {
+		t.Errorf("Second delivery for the repository was expected to be rate limited, status was %d", result.Status)
+	}
+}
+
+func TestTriggerLimit(t *testing.T) {
+	limits := newDeliveryLimits(newRateLimiter(0, 0), newRateLimiter(1, 1))
+	params := triggerParams{TriggerName: "name-ns-push-event", RepositoryURL: "https://github.com/owner/repo"}
+
+	if result := limits.check(params, "delivery-1"); result.Status != http.StatusOK {
+		t.Errorf("First delivery for the trigger was rejected with status %d", result.Status)
+	}
+	if result := limits.check(params, "delivery-2"); result.Status != http.StatusTooManyRequests {
+		t.Errorf("Second delivery for the trigger was expected to be rate limited, status was %d", result.Status)
+	}
+}
+
+func TestConcurrencyCap(t *testing.T) {
+	limits := newDeliveryLimits(newRateLimiter(0, 0), newRateLimiter(0, 0))
+	inFlight := 1
+	limits.countInFlight = func(namespace, selector string) (int, error) {
+		if namespace != "foo" || selector != "tekton.dev/pipeline=pipeline1" {
+			t.Errorf("Unexpected namespace %s or selector %s counting PipelineRuns", namespace, selector)
+		}
+		return inFlight, nil
+	}
+	params := triggerParams{
+		TriggerName:          "name-foo-push-event",
+		RepositoryURL:        "https://github.com/owner/repo",
+		MaxConcurrentRuns:    "2",
+		ConcurrencyNamespace: "foo",
+		ConcurrencySelector:  "tekton.dev/pipeline=pipeline1",
+	}
+
+	if result := limits.check(params, "delivery-1"); result.Status != http.StatusOK {
+		t.Errorf("Delivery was rejected with %d PipelineRuns in flight, status was %d", inFlight, result.Status)
+	}
+	inFlight = 2
+	if result := limits.check(params, "delivery-2"); result.Status != http.StatusTooManyRequests {
+		t.Errorf("Delivery was expected to be rejected with %d PipelineRuns in flight, status was %d", inFlight, result.Status)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	envSkipCIDirectives = "SKIP_CI_DIRECTIVES"
+)
+
+// Directives that are always honoured, further directives can be added through
+// the SKIP_CI_DIRECTIVES environment variable as a comma separated list
+var defaultSkipCIDirectives = []string{"[skip ci]", "[ci skip]"}
+
+// Returns the default skip directives plus any configured in the environment, lowercased
+func getSkipCIDirectives() []string {
+	directives := []string{}
+	for _, directive := range defaultSkipCIDirectives {
+		directives = append(directives, strings.ToLower(directive))
+	}
+	for _, directive := range strings.Split(os.Getenv(envSkipCIDirectives), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+	return directives
+}
+
+// Checks the head commit message of a push event, or the title of a pull request event,
+// for any of the directives. Returns true and the directive found if the event should be skipped.
+func shouldSkipCI(event string, payload []byte, directives []string) (bool, string, error) {
+	text := ""
+	if "push" == event {
+		var p github.PushEvent
+		err := json.Unmarshal(payload, &p)
+		if err != nil {
+			return false, "", err
+		}
+		text = p.GetHeadCommit().GetMessage()
+	} else if "pull_request" == event {
+		var pr github.PullRequestEvent
+		err := json.Unmarshal(payload, &pr)
+		if err != nil {
+			return false, "", err
+		}
+		text = pr.GetPullRequest().GetTitle()
+	} else {
+		return false, "", nil
+	}
+
+	text = strings.ToLower(text)
+	for _, directive := range directives {
+		if strings.Contains(text, directive) {
+			return true, directive, nil
+		}
+	}
+	return false, "", nil
+}
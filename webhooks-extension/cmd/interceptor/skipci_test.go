@@ -0,0 +1,93 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestShouldSkipCIForPush(t *testing.T) {
+	messages := map[string]bool{
+		"Update README [skip ci]":      true,
+		"[CI SKIP] fix typo":           true,
+		"Add a new feature":            false,
+		"Mention skip ci without tags": false,
+	}
+
+	for message, expected := range messages {
+		msg := message
+		payload, err := json.Marshal(github.PushEvent{HeadCommit: &github.PushEventCommit{Message: &msg}})
+		if err != nil {
+			t.Errorf("Error in json.Marshal %s", err)
+		}
+		skip, _, err := shouldSkipCI("push", payload, getSkipCIDirectives())
+		if err != nil {
+			t.Errorf("Error in shouldSkipCI %s", err)
+		}
+		if skip != expected {
+			t.Errorf("Skip for commit message %q was %t but expected %t", message, skip, expected)
+		}
+	}
+}
+
+func TestShouldSkipCIForPullRequest(t *testing.T) {
+	title := "WIP: docs only [skip ci]"
+	payload, err := json.Marshal(github.PullRequestEvent{PullRequest: &github.PullRequest{Title: &title}})
+	if err != nil {
+		t.Errorf("Error in json.Marshal %s", err)
+	}
+	skip, directive, err := shouldSkipCI("pull_request", payload, getSkipCIDirectives())
+	if err != nil {
+		t.Errorf("Error in shouldSkipCI %s", err)
+	}
+	if !skip || directive != "[skip ci]" {
+		t.Errorf("Pull request with title %q was not skipped, directive returned was %q", title, directive)
+	}
+}
+
+func TestShouldSkipCIWithConfiguredDirectives(t *testing.T) {
+	os.Setenv(envSkipCIDirectives, "[no build], [skip tekton]")
+	defer os.Unsetenv(envSkipCIDirectives)
+
+	message := "Bump dependencies [Skip Tekton]"
+	payload, err := json.Marshal(github.PushEvent{HeadCommit: &github.PushEventCommit{Message: &message}})
+	if err != nil {
+		t.Errorf("Error in json.Marshal %s", err)
+	}
+	skip, directive, err := shouldSkipCI("push", payload, getSkipCIDirectives())
+	if err != nil {
+		t.Errorf("Error in shouldSkipCI %s", err)
+	}
+	if !skip || directive != "[skip tekton]" {
+		t.Errorf("Push with message %q was not skipped, directive returned was %q", message, directive)
+	}
+}
+
+func TestShouldSkipCIForOtherEvent(t *testing.T) {
+	payload, err := json.Marshal(github.PingEvent{})
+	if err != nil {
+		t.Errorf("Error in json.Marshal %s", err)
+	}
+	skip, _, err := shouldSkipCI("ping", payload, getSkipCIDirectives())
+	if err != nil {
+		t.Errorf("Error in shouldSkipCI %s", err)
+	}
+	if skip {
+		t.Error("Ping event should never be skipped")
+	}
+}
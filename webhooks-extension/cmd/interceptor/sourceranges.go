@@ -0,0 +1,246 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// The ConfigMap in the install namespace holding the global allowlist
+	envSourceRangesConfigMap     = "SOURCE_RANGES_CONFIGMAP"
+	defaultSourceRangesConfigMap = "webhooks-extension-source-ranges"
+	allowedSourceRangesKey       = "allowedSourceRanges"
+	trustedProxiesKey            = "trustedProxies"
+
+	// Stands for the hook ranges GitHub publishes at https://api.github.com/meta
+	githubSourceRanges = "github"
+	// How long the published GitHub ranges are cached for
+	githubSourceRangesRefresh = time.Hour
+)
+
+// The allowlist and the proxies trusted to append to X-Forwarded-For. Entries
+// are CIDRs or single addresses, and the allowlist may also contain "github".
+type sourceRangeConfig struct {
+	AllowedSourceRanges []string
+	TrustedProxies      []string
+}
+
+// Reads the global configuration from the ConfigMap, a missing ConfigMap means no global allowlist
+func getSourceRangeConfig(triggerName string) (sourceRangeConfig, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("[%s] Error creating in cluster config: %s", triggerName, err.Error())
+		return sourceRangeConfig{}, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Printf("[%s] Error creating new clientset: %s", triggerName, err.Error())
+		return sourceRangeConfig{}, err
+	}
+
+	configMapName := os.Getenv(envSourceRangesConfigMap)
+	if configMapName == "" {
+		configMapName = defaultSourceRangesConfigMap
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps(os.Getenv("INSTALLED_NAMESPACE")).Get(configMapName, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return sourceRangeConfig{}, nil
+		}
+		log.Printf("[%s] Error getting the configmap %s: %s", triggerName, configMapName, err.Error())
+		return sourceRangeConfig{}, err
+	}
+	return sourceRangeConfig{
+		AllowedSourceRanges: splitSourceRanges(configMap.Data[allowedSourceRangesKey]),
+		TrustedProxies:      splitSourceRanges(configMap.Data[trustedProxiesKey]),
+	}, nil
+}
+
+// Splits a list of ranges separated by commas, spaces or newlines
+func splitSourceRanges(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
+
+// Checks the client address of the delivery against the trigger's allowlist, or the global
+// allowlist if the trigger has none. Returns a decision with http.StatusOK if the delivery
+// may be processed, and http.StatusForbidden if the address is not allowed.
+func checkSourceAddress(params triggerParams, request *http.Request, config sourceRangeConfig, githubRanges func() ([]string, error)) decision {
+	if isScheduledDelivery(request) {
+		return decision{Status: http.StatusOK}
+	}
+	allowed := config.AllowedSourceRanges
+	if params.AllowedSourceRanges != "" {
+		allowed = splitSourceRanges(params.AllowedSourceRanges)
+	}
+	if len(allowed) == 0 {
+		return decision{Status: http.StatusOK}
+	}
+
+	expanded := []string{}
+	for _, sourceRange := range allowed {
+		if strings.ToLower(sourceRange) != githubSourceRanges {
+			expanded = append(expanded, sourceRange)
+			continue
+		}
+		published, err := githubRanges()
+		if err != nil {
+			log.Printf("[%s] Error getting the published GitHub hook ranges: %s", params.TriggerName, err.Error())
+			return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+		}
+		expanded = append(expanded, published...)
+	}
+
+	allowedNets, err := parseSourceRanges(expanded)
+	if err != nil {
+		log.Printf("[%s] Invalid allowed source ranges: %s", params.TriggerName, err.Error())
+		return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+	}
+	trustedNets, err := parseSourceRanges(config.TrustedProxies)
+	if err != nil {
+		log.Printf("[%s] Invalid trusted proxies: %s", params.TriggerName, err.Error())
+		return decision{Status: http.StatusInternalServerError, Message: fmt.Sprint(err)}
+	}
+
+	address, err := clientAddress(request, trustedNets)
+	if err != nil {
+		log.Printf("[%s] Validation FAIL (%s) for Github event ID: %s", params.TriggerName, err.Error(), github.DeliveryID(request))
+		return decision{Status: http.StatusForbidden, Message: fmt.Sprint(err)}
+	}
+	if !containsAddress(allowedNets, address) {
+		log.Printf("[%s] Validation FAIL (source address %s is not allowed) for Github event ID: %s", params.TriggerName, address, github.DeliveryID(request))
+		return decision{Status: http.StatusForbidden, Message: fmt.Sprintf("source address %s is not allowed", address)}
+	}
+	return decision{Status: http.StatusOK}
+}
+
+// Whether the delivery was sent by the extension for a webhook's schedule. These are sent to the
+// eventlistener's service from inside the cluster, so unlike deliveries that come through the
+// ingress or route they have no X-Forwarded-For header to check. They are still validated
+// against the webhook's secret token.
+func isScheduledDelivery(request *http.Request) bool {
+	return request.Header.Get(scheduleHeader) != "" && len(request.Header[http.CanonicalHeaderKey("X-Forwarded-For")]) == 0
+}
+
+// Returns the address of the client that sent the delivery. The interceptor is only called by
+// the eventlistener, so the address comes from the X-Forwarded-For header set by the ingress or
+// route in front of it. Entries appended by trusted proxies are skipped from the right, the first
+// untrusted entry is the client. A client can put anything at the start of the header, so the
+// entries to the left of it are never used.
+func clientAddress(request *http.Request, trustedProxies []*net.IPNet) (net.IP, error) {
+	forwarded := []string{}
+	for _, value := range request.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				forwarded = append(forwarded, entry)
+			}
+		}
+	}
+	if len(forwarded) == 0 {
+		return nil, errors.New("no forwarded client address")
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := net.ParseIP(forwarded[i])
+		if address == nil {
+			return nil, fmt.Errorf("invalid forwarded address %s", forwarded[i])
+		}
+		if i == 0 || !containsAddress(trustedProxies, address) {
+			return address, nil
+		}
+	}
+	return nil, errors.New("no forwarded client address")
+}
+
+func parseSourceRanges(sourceRanges []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, sourceRange := range sourceRanges {
+		if !strings.Contains(sourceRange, "/") {
+			address := net.ParseIP(sourceRange)
+			if address == nil {
+				return nil, fmt.Errorf("invalid address %s", sourceRange)
+			}
+			bits := 8 * net.IPv6len
+			if address.To4() != nil {
+				address = address.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: address, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(sourceRange)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsAddress(nets []*net.IPNet, address net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// The hook ranges published by GitHub, cached for githubSourceRangesRefresh
+type publishedRanges struct {
+	lock    sync.Mutex
+	ranges  []string
+	fetched time.Time
+}
+
+var githubPublishedRanges = &publishedRanges{}
+
+func getGitHubHookRanges() ([]string, error) {
+	githubPublishedRanges.lock.Lock()
+	defer githubPublishedRanges.lock.Unlock()
+
+	if githubPublishedRanges.ranges != nil && time.Since(githubPublishedRanges.fetched) < githubSourceRangesRefresh {
+		return githubPublishedRanges.ranges, nil
+	}
+	meta, _, err := github.NewClient(nil).APIMeta(context.Background())
+	if err != nil {
+		if githubPublishedRanges.ranges != nil {
+			// Keep using the last known ranges rather than rejecting every delivery
+			log.Printf("Error refreshing the published GitHub hook ranges, using the cached ranges: %s", err.Error())
+			return githubPublishedRanges.ranges, nil
+		}
+		return nil, err
+	}
+	githubPublishedRanges.ranges = meta.Hooks
+	githubPublishedRanges.fetched = time.Now()
+	return githubPublishedRanges.ranges, nil
+}
@@ -0,0 +1,146 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func requestForwardedFor(forwardedFor ...string) *http.Request {
+	request, _ := http.NewRequest(http.MethodPost, "/", nil)
+	for _, value := range forwardedFor {
+		request.Header.Add("X-Forwarded-For", value)
+	}
+	return request
+}
+
+func noGitHubRanges() ([]string, error) {
+	return nil, errors.New("GitHub ranges should not be needed")
+}
+
+func TestClientAddress(t *testing.T) {
+	trusted, err := parseSourceRanges([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error parsing trusted proxies: %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		forwardedFor []string
+		expected     string
+	}{
+		{"single entry", []string{"140.82.115.1"}, "140.82.115.1"},
+		{"trusted hops are skipped", []string{"140.82.115.1, 192.168.1.1, 10.1.2.3"}, "140.82.115.1"},
+		{"entries before the client are ignored", []string{"1.2.3.4, 140.82.115.1, 10.1.2.3"}, "140.82.115.1"},
+		{"untrusted proxy is the client", []string{"140.82.115.1, 172.16.0.1, 10.1.2.3"}, "172.16.0.1"},
+		{"multiple headers", []string{"140.82.115.1", "10.1.2.3"}, "140.82.115.1"},
+		{"only trusted entries", []string{"10.1.2.3, 10.1.2.4"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := clientAddress(requestForwardedFor(tt.forwardedFor...), trusted)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if address.String() != tt.expected {
+				t.Errorf("Client address was %s, expected %s", address, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClientAddressErrors(t *testing.T) {
+	if _, err := clientAddress(requestForwardedFor(), nil); err == nil {
+		t.Error("Expected an error when there is no X-Forwarded-For header")
+	}
+	if _, err := clientAddress(requestForwardedFor("not-an-address"), nil); err == nil {
+		t.Error("Expected an error for an invalid forwarded address")
+	}
+}
+
+func TestCheckSourceAddressNoAllowlist(t *testing.T) {
+	result := checkSourceAddress(triggerParams{}, requestForwardedFor(), sourceRangeConfig{}, noGitHubRanges)
+	if result.Status != http.StatusOK {
+		t.Errorf("Delivery was rejected without an allowlist, status was %d", result.Status)
+	}
+}
+
+func TestCheckSourceAddressGlobalAllowlist(t *testing.T) {
+	config := sourceRangeConfig{AllowedSourceRanges: []string{"203.0.113.0/24"}, TrustedProxies: []string{"10.0.0.0/8"}}
+
+	if result := checkSourceAddress(triggerParams{}, requestForwardedFor("203.0.113.7, 10.0.0.1"), config, noGitHubRanges); result.Status != http.StatusOK {
+		t.Errorf("Delivery from an allowed address was rejected with status %d", result.Status)
+	}
+	if result := checkSourceAddress(triggerParams{}, requestForwardedFor("198.51.100.7, 10.0.0.1"), config, noGitHubRanges); result.Status != http.StatusForbidden {
+		t.Errorf("Delivery from an address outside the allowlist was expected to be forbidden, status was %d", result.Status)
+	}
+	if result := checkSourceAddress(triggerParams{}, requestForwardedFor(), config, noGitHubRanges); result.Status != http.StatusForbidden {
+		t.Errorf("Delivery without a forwarded address was expected to be forbidden, status was %d", result.Status)
+	}
+}
+
+func TestCheckSourceAddressScheduledDelivery(t *testing.T) {
+	config := sourceRangeConfig{AllowedSourceRanges: []string{"203.0.113.0/24"}}
+	params := triggerParams{AllowedSourceRanges: "198.51.100.0/24"}
+
+	scheduled := requestForwardedFor()
+	scheduled.Header.Set(scheduleHeader, "@daily")
+	if result := checkSourceAddress(triggerParams{}, scheduled, config, noGitHubRanges); result.Status != http.StatusOK {
+		t.Errorf("Scheduled delivery was rejected by the global allowlist with status %d", result.Status)
+	}
+	if result := checkSourceAddress(params, scheduled, config, noGitHubRanges); result.Status != http.StatusOK {
+		t.Errorf("Scheduled delivery was rejected by the webhook's allowlist with status %d", result.Status)
+	}
+
+	// Deliveries that came through the ingress are checked whatever headers they carry
+	forwarded := requestForwardedFor("198.51.100.7")
+	forwarded.Header.Set(scheduleHeader, "@daily")
+	if result := checkSourceAddress(triggerParams{}, forwarded, config, noGitHubRanges); result.Status != http.StatusForbidden {
+		t.Errorf("Forwarded delivery claiming to be scheduled was expected to be forbidden, status was %d", result.Status)
+	}
+}
+
+func TestCheckSourceAddressWebhookAllowlistOverridesGlobal(t *testing.T) {
+	config := sourceRangeConfig{AllowedSourceRanges: []string{"203.0.113.0/24"}}
+	params := triggerParams{AllowedSourceRanges: "198.51.100.0/24,2001:db8::/32"}
+
+	if result := checkSourceAddress(params, requestForwardedFor("198.51.100.7"), config, noGitHubRanges); result.Status != http.StatusOK {
+		t.Errorf("Delivery allowed by the webhook was rejected with status %d", result.Status)
+	}
+	if result := checkSourceAddress(params, requestForwardedFor("2001:db8::1"), config, noGitHubRanges); result.Status != http.StatusOK {
+		t.Errorf("IPv6 delivery allowed by the webhook was rejected with status %d", result.Status)
+	}
+	if result := checkSourceAddress(params, requestForwardedFor("203.0.113.7"), config, noGitHubRanges); result.Status != http.StatusForbidden {
+		t.Errorf("Delivery only allowed globally was expected to be forbidden, status was %d", result.Status)
+	}
+}
+
+func TestCheckSourceAddressGitHubRanges(t *testing.T) {
+	params := triggerParams{AllowedSourceRanges: "github"}
+	githubRanges := func() ([]string, error) {
+		return []string{"192.30.252.0/22", "140.82.112.0/20"}, nil
+	}
+
+	if result := checkSourceAddress(params, requestForwardedFor("140.82.115.1"), sourceRangeConfig{}, githubRanges); result.Status != http.StatusOK {
+		t.Errorf("Delivery from a published GitHub range was rejected with status %d", result.Status)
+	}
+	if result := checkSourceAddress(params, requestForwardedFor("198.51.100.7"), sourceRangeConfig{}, githubRanges); result.Status != http.StatusForbidden {
+		t.Errorf("Delivery from outside the GitHub ranges was expected to be forbidden, status was %d", result.Status)
+	}
+	if result := checkSourceAddress(params, requestForwardedFor("140.82.115.1"), sourceRangeConfig{}, noGitHubRanges); result.Status != http.StatusInternalServerError {
+		t.Errorf("Failing to get the GitHub ranges was expected to be an internal error, status was %d", result.Status)
+	}
+}
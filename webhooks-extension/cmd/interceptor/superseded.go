@@ -0,0 +1,161 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektoncdclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+)
+
+// The annotation the extension documents for the supersede key of a webhook's PipelineRuns,
+// see docs/SupersededRuns.md
+const supersedeKeyAnnotation = "webhooks.tekton.dev/supersedeKey"
+
+// The key and commit of a push, or of a pull request's new commits, and when the git
+// provider saw them
+type deliveryHead struct {
+	Key    string `json:"webhooks-tekton-supersede-key"`
+	After  string `json:"after"`
+	Action string `json:"action"`
+	// Set for pushes
+	Repository struct {
+		PushedAt github.Timestamp `json:"pushed_at"`
+	} `json:"repository"`
+	// Set for pull requests
+	PullRequest struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		UpdatedAt github.Timestamp `json:"updated_at"`
+	} `json:"pull_request"`
+}
+
+// What a delivery supersedes: the runs with its key for other commits that were created before it
+type supersedingDelivery struct {
+	key, sha string
+	at       time.Time
+}
+
+// Cancels the webhook's in-flight PipelineRuns superseded by a delivery, replaced in tests
+var cancelSuperseded = cancelSupersededPipelineRuns
+
+// Reads the supersede key and commit of a push, or of a pull request's synchronize event,
+// from the payload passed on to the eventlistener. The key is the full ref of a push, and
+// the base repository and number of a pull request. The delivery supersedes runs created
+// before the push or pull request update it reports, so a late or redelivered payload does
+// not cancel runs for newer commits, or before it was received if the payload has no time.
+// ok is false for other events, pushes that delete a branch and deliveries without a key,
+// which supersede nothing.
+func headOfDelivery(event string, payload []byte, received time.Time) (supersedingDelivery, bool) {
+	var head deliveryHead
+	if err := json.Unmarshal(payload, &head); err != nil || head.Key == "" {
+		return supersedingDelivery{}, false
+	}
+	delivery := supersedingDelivery{key: head.Key, at: received}
+	switch {
+	case event == "push" && head.After != "" && head.After != "0000000000000000000000000000000000000000":
+		delivery.sha = head.After
+		if !head.Repository.PushedAt.IsZero() {
+			delivery.at = head.Repository.PushedAt.Time
+		}
+	case event == "pull_request" && head.Action == "synchronize" && head.PullRequest.Head.SHA != "":
+		delivery.sha = head.PullRequest.Head.SHA
+		if !head.PullRequest.UpdatedAt.IsZero() {
+			delivery.at = head.PullRequest.UpdatedAt.Time
+		}
+	default:
+		return supersedingDelivery{}, false
+	}
+	return delivery, true
+}
+
+// The names of the in-flight runs with the delivery's key for another commit, created
+// before the delivery. Runs without the key annotation, or whose commit or event is not
+// known from the labels or params described in docs/Labels.md, are never superseded.
+func supersededRuns(pipelineRuns []v1alpha1.PipelineRun, event string, delivery supersedingDelivery) []string {
+	superseded := []string{}
+	for _, pipelineRun := range pipelineRuns {
+		if !pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsUnknown() || pipelineRun.Spec.Status == v1alpha1.PipelineRunSpecStatusCancelled {
+			continue
+		}
+		if pipelineRun.Annotations[supersedeKeyAnnotation] != delivery.key || !pipelineRun.CreationTimestamp.Time.Before(delivery.at) {
+			continue
+		}
+		runSHA := pipelineRun.Labels["webhooks.tekton.dev/gitCommit"]
+		runEvent := pipelineRun.Labels["webhooks.tekton.dev/gitEvent"]
+		for _, param := range pipelineRun.Spec.Params {
+			if param.Name == "gitrevision" && runSHA == "" {
+				runSHA = param.Value.StringVal
+			}
+			if param.Name == "event-type" && runEvent == "" {
+				runEvent = param.Value.StringVal
+			}
+		}
+		if runSHA != "" && runSHA != delivery.sha && runEvent == event {
+			superseded = append(superseded, pipelineRun.Name)
+		}
+	}
+	return superseded
+}
+
+// Cancels the in-flight runs of the webhook's pipeline with the delivery's key for older commits
+func cancelSupersededPipelineRuns(params triggerParams, event string, payload []byte, received time.Time) {
+	delivery, ok := headOfDelivery(event, payload, received)
+	if !ok {
+		return
+	}
+	go func() {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			log.Printf("[%s] Error creating in cluster config: %s", params.TriggerName, err.Error())
+			return
+		}
+		tektonClient, err := tektoncdclientset.NewForConfig(config)
+		if err != nil {
+			log.Printf("[%s] Error creating new clientset: %s", params.TriggerName, err.Error())
+			return
+		}
+		pipelineRuns := tektonClient.TektonV1alpha1().PipelineRuns(params.ConcurrencyNamespace)
+		list, err := pipelineRuns.List(metav1.ListOptions{LabelSelector: params.ConcurrencySelector})
+		if err != nil {
+			log.Printf("[%s] Error listing PipelineRuns to cancel: %s", params.TriggerName, err.Error())
+			return
+		}
+		for _, name := range supersededRuns(list.Items, event, delivery) {
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				pipelineRun, err := pipelineRuns.Get(name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				pipelineRun.Spec.Status = v1alpha1.PipelineRunSpecStatusCancelled
+				_, err = pipelineRuns.Update(pipelineRun)
+				return err
+			})
+			if err != nil {
+				log.Printf("[%s] Error cancelling superseded PipelineRun %s: %s", params.TriggerName, name, err.Error())
+				continue
+			}
+			log.Printf("[%s] Cancelled PipelineRun %s superseded by commit %s of %s", params.TriggerName, name, delivery.sha, delivery.key)
+		}
+	}()
+}
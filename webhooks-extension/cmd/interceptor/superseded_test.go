@@ -0,0 +1,148 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+func TestHeadOfDelivery(t *testing.T) {
+	received := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	pushed := time.Date(2019, 10, 1, 11, 0, 0, 0, time.UTC)
+	tests := []struct {
+		event, payload string
+		expected       supersedingDelivery
+		ok             bool
+	}{
+		{"push", `{"after":"abc","webhooks-tekton-supersede-key":"refs/heads/team/feature"}`, supersedingDelivery{"refs/heads/team/feature", "abc", received}, true},
+		{"push", fmt.Sprintf(`{"after":"abc","repository":{"pushed_at":%d},"webhooks-tekton-supersede-key":"refs/heads/master"}`, pushed.Unix()), supersedingDelivery{"refs/heads/master", "abc", pushed}, true},
+		{"push", `{"after":"0000000000000000000000000000000000000000","webhooks-tekton-supersede-key":"refs/heads/gone"}`, supersedingDelivery{}, false},
+		{"push", `{"after":"abc","webhooks-tekton-git-branch":"master"}`, supersedingDelivery{}, false},
+		{"pull_request", `{"action":"synchronize","pull_request":{"head":{"sha":"def"},"updated_at":"2019-10-01T11:00:00Z"},"webhooks-tekton-supersede-key":"owner/repo#42"}`, supersedingDelivery{"owner/repo#42", "def", pushed}, true},
+		{"pull_request", `{"action":"synchronize","pull_request":{"head":{"sha":"def"}},"webhooks-tekton-supersede-key":""}`, supersedingDelivery{}, false},
+		{"pull_request", `{"action":"opened","pull_request":{"head":{"sha":"def"}},"webhooks-tekton-supersede-key":"owner/repo#42"}`, supersedingDelivery{}, false},
+		{"ping", `{"webhooks-tekton-supersede-key":"refs/heads/master"}`, supersedingDelivery{}, false},
+	}
+	for _, test := range tests {
+		delivery, ok := headOfDelivery(test.event, []byte(test.payload), received)
+		if delivery.key != test.expected.key || delivery.sha != test.expected.sha || !delivery.at.Equal(test.expected.at) || ok != test.ok {
+			t.Errorf("Head of %s %s was %+v (%t), expected %+v (%t)", test.event, test.payload, delivery, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func inFlightRun(name, key, sha, event string, status corev1.ConditionStatus) v1alpha1.PipelineRun {
+	pipelineRun := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Annotations:       map[string]string{supersedeKeyAnnotation: key},
+			CreationTimestamp: metav1.NewTime(time.Date(2019, 10, 1, 10, 0, 0, 0, time.UTC)),
+		},
+		Spec: v1alpha1.PipelineRunSpec{
+			Params: []v1alpha1.Param{
+				{Name: "gitrevision", Value: v1alpha1.ArrayOrString{Type: v1alpha1.ParamTypeString, StringVal: sha}},
+				{Name: "event-type", Value: v1alpha1.ArrayOrString{Type: v1alpha1.ParamTypeString, StringVal: event}},
+			},
+		},
+	}
+	pipelineRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: status})
+	return pipelineRun
+}
+
+func TestSupersededRuns(t *testing.T) {
+	const key = "refs/heads/team/feature"
+	at := time.Date(2019, 10, 1, 11, 0, 0, 0, time.UTC)
+	cancelling := inFlightRun("cancelling", key, "old", "push", corev1.ConditionUnknown)
+	cancelling.Spec.Status = v1alpha1.PipelineRunSpecStatusCancelled
+	labelled := inFlightRun("labelled", key, "", "", corev1.ConditionUnknown)
+	labelled.Labels = map[string]string{"webhooks.tekton.dev/gitCommit": "older", "webhooks.tekton.dev/gitEvent": "push"}
+	unkeyed := inFlightRun("unkeyed", "", "old", "push", corev1.ConditionUnknown)
+	unkeyed.Annotations = nil
+	// Started for a newer push before a late delivery of an older one arrived
+	newer := inFlightRun("newer", key, "newer", "push", corev1.ConditionUnknown)
+	newer.CreationTimestamp = metav1.NewTime(at.Add(time.Minute))
+	pipelineRuns := []v1alpha1.PipelineRun{
+		inFlightRun("older", key, "old", "push", corev1.ConditionUnknown),
+		inFlightRun("same-commit", key, "new", "push", corev1.ConditionUnknown),
+		inFlightRun("pull-request", key, "old", "pull_request", corev1.ConditionUnknown),
+		inFlightRun("completed", key, "old", "push", corev1.ConditionTrue),
+		inFlightRun("unknown-commit", key, "", "push", corev1.ConditionUnknown),
+		// Same last path segment as the delivery's branch, but a different ref
+		inFlightRun("other-ref", "refs/heads/feature", "old", "push", corev1.ConditionUnknown),
+		newer,
+		unkeyed,
+		cancelling,
+		labelled,
+	}
+	if superseded := supersededRuns(pipelineRuns, "push", supersedingDelivery{key, "new", at}); !reflect.DeepEqual(superseded, []string{"older", "labelled"}) {
+		t.Errorf("Superseded runs were %v, expected the in-flight pushes of older commits to the same ref", superseded)
+	}
+
+	pullRequests := []v1alpha1.PipelineRun{
+		inFlightRun("same-pull-request", "owner/repo#42", "old", "pull_request", corev1.ConditionUnknown),
+		inFlightRun("other-pull-request", "owner/repo#43", "old", "pull_request", corev1.ConditionUnknown),
+		inFlightRun("fork-pull-request", "fork/repo#42", "old", "pull_request", corev1.ConditionUnknown),
+	}
+	if superseded := supersededRuns(pullRequests, "pull_request", supersedingDelivery{"owner/repo#42", "new", at}); !reflect.DeepEqual(superseded, []string{"same-pull-request"}) {
+		t.Errorf("Superseded runs were %v, expected only the runs of the same pull request", superseded)
+	}
+}
+
+func TestAdmitDeliveryCancelsSuperseded(t *testing.T) {
+	var cancelledFor []string
+	defer func(cancel func(triggerParams, string, []byte, time.Time)) { cancelSuperseded = cancel }(cancelSuperseded)
+	cancelSuperseded = func(params triggerParams, event string, payload []byte, received time.Time) {
+		cancelledFor = append(cancelledFor, params.TriggerName+" "+event)
+	}
+
+	body := `{"ref":"refs/heads/master","after":"1234567890abcdef","head_commit":{"id":"1234567890abcdef","message":"Change"},"repository":{"clone_url":"https://github.com/owner/repo.git"}}`
+	ir := signedInterceptorRequest(body, "push")
+	admit := func() decision {
+		request, err := ir.toHTTPRequest()
+		if err != nil {
+			t.Fatalf("Error in toHTTPRequest %s", err)
+		}
+		params := getTriggerParamsFromInterceptorParams(ir.InterceptorParams)
+		return admitDelivery(params, request, validateDelivery(params, request, []byte(testSecretToken)))
+	}
+	admit()
+	if len(cancelledFor) != 0 {
+		t.Errorf("Superseded runs were cancelled for %v without the webhook asking", cancelledFor)
+	}
+
+	ir.InterceptorParams["Wext-Cancel-Superseded"] = "true"
+	if result := admit(); result.Status != http.StatusOK {
+		t.Fatalf("Expected the delivery to be admitted, got %+v", result)
+	}
+	if !reflect.DeepEqual(cancelledFor, []string{"name-namespace-push-event push"}) {
+		t.Errorf("Superseded runs were cancelled for %v, expected the push trigger", cancelledFor)
+	}
+
+	ir.Header[replayOfHeader] = []string{"an-older-delivery-id"}
+	if result := admit(); result.Status != http.StatusOK {
+		t.Fatalf("Expected the replayed delivery to be admitted, got %+v", result)
+	}
+	if len(cancelledFor) != 1 {
+		t.Errorf("Superseded runs were cancelled for %v, expected a replay to cancel none", cancelledFor)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package credentialstore reads the access token and secret token of a credential from
+// wherever they are kept: Kubernetes Secrets, files mounted into the pod or a Vault KV store.
+package credentialstore
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	k8sclient "k8s.io/client-go/kubernetes"
+)
+
+// The backends a CredentialStore can be created for, selected with the CREDENTIAL_STORE environment variable
+const (
+	Kubernetes = "kubernetes"
+	File       = "file"
+	Vault      = "vault"
+)
+
+// CredentialStore returns the tokens of a credential by name. The access token is used to
+// register webhooks with the git provider, the secret token to sign and validate deliveries.
+type CredentialStore interface {
+	GetTokens(name string) (accessToken, secretToken string, err error)
+	// The names of the credentials in the store
+	List() ([]string, error)
+	// The backend, one of Kubernetes, File or Vault
+	Backend() string
+}
+
+// NewFromEnv creates the CredentialStore configured in the environment, credentials in
+// Kubernetes Secrets are read from namespace
+func NewFromEnv(kubeClient k8sclient.Interface, namespace string) (CredentialStore, error) {
+	switch backend := os.Getenv("CREDENTIAL_STORE"); backend {
+	case Kubernetes, "":
+		return NewKubernetesStore(kubeClient, namespace), nil
+	case File:
+		directory := os.Getenv("CREDENTIAL_STORE_PATH")
+		if directory == "" {
+			return nil, fmt.Errorf("CREDENTIAL_STORE_PATH must be set for the %s credential store", File)
+		}
+		return NewFileStore(directory), nil
+	case Vault:
+		address := os.Getenv("VAULT_ADDR")
+		if address == "" {
+			return nil, fmt.Errorf("VAULT_ADDR must be set for the %s credential store", Vault)
+		}
+		store := NewVaultStore(address, os.Getenv("VAULT_KV_MOUNT"), os.Getenv("VAULT_KV_PREFIX"), &http.Client{Timeout: 10 * time.Second})
+		store.Token = os.Getenv("VAULT_TOKEN")
+		store.TokenFile = os.Getenv("VAULT_TOKEN_FILE")
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %s, expected %s, %s or %s", backend, Kubernetes, File, Vault)
+	}
+}
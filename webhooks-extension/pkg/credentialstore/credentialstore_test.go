@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentialstore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func checkTokens(store CredentialStore, name, expectedAccessToken, expectedSecretToken string, t *testing.T) {
+	accessToken, secretToken, err := store.GetTokens(name)
+	if err != nil {
+		t.Fatalf("Error getting tokens for %s from the %s store: %s", name, store.Backend(), err)
+	}
+	if accessToken != expectedAccessToken || secretToken != expectedSecretToken {
+		t.Errorf("Tokens for %s from the %s store were %s and %s, expected %s and %s", name, store.Backend(), accessToken, secretToken, expectedAccessToken, expectedSecretToken)
+	}
+}
+
+func TestKubernetesStore(t *testing.T) {
+	client := fakek8sclientset.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "tekton-pipelines", Labels: map[string]string{CredentialLabel: "true"}},
+			Data:       map[string][]byte{"accessToken": []byte("access"), "secretToken": []byte("secret")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "basic", Namespace: "tekton-pipelines"},
+			Type:       corev1.SecretTypeBasicAuth,
+			Data:       map[string][]byte{"username": []byte("user"), "password": []byte("password"), "secretToken": []byte("secret")},
+		},
+	)
+	store := NewKubernetesStore(client, "tekton-pipelines")
+
+	checkTokens(store, "token", "access", "secret", t)
+	checkTokens(store, "basic", "password", "secret", t)
+	if _, _, err := store.GetTokens("missing"); err == nil {
+		t.Error("Expected an error getting a missing credential")
+	}
+	if names, err := store.List(); err != nil || !reflect.DeepEqual(names, []string{"token"}) {
+		t.Errorf("Credentials listed were %v with error %v, expected only the labelled secret", names, err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	directory, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	os.Mkdir(filepath.Join(directory, "token"), 0700)
+	ioutil.WriteFile(filepath.Join(directory, "token", "accessToken"), []byte("access\n"), 0600)
+	ioutil.WriteFile(filepath.Join(directory, "token", "secretToken"), []byte("secret\n"), 0600)
+	os.Mkdir(filepath.Join(directory, "validation-only"), 0700)
+	ioutil.WriteFile(filepath.Join(directory, "validation-only", "secretToken"), []byte("secret"), 0600)
+	os.Mkdir(filepath.Join(directory, "..data"), 0700)
+
+	store := NewFileStore(directory)
+	checkTokens(store, "token", "access", "secret", t)
+	checkTokens(store, "validation-only", "", "secret", t)
+	for _, name := range []string{"missing", "../token", ".."} {
+		if _, _, err := store.GetTokens(name); err == nil {
+			t.Errorf("Expected an error getting credential %s", name)
+		}
+	}
+	if names, err := store.List(); err != nil || !reflect.DeepEqual(names, []string{"token", "validation-only"}) {
+		t.Errorf("Credentials listed were %v with error %v", names, err)
+	}
+}
+
+// A stand-in for the Vault KV version 2 API
+func dummyVault(t *testing.T, token string, secrets map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Vault-Token") != token {
+			writer.WriteHeader(http.StatusForbidden)
+			writer.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		secret, found := secrets[request.URL.Path]
+		if !found {
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		writer.Write([]byte(secret))
+	}))
+}
+
+func TestVaultStore(t *testing.T) {
+	vault := dummyVault(t, "vault-token", map[string]string{
+		"/v1/kv/data/webhooks/token": `{"data": {"data": {"accessToken": "access", "secretToken": "secret"}, "metadata": {"version": 2}}}`,
+		"/v1/kv/metadata/webhooks/":  `{"data": {"keys": ["token", "team/"]}}`,
+	})
+	defer vault.Close()
+
+	store := NewVaultStore(vault.URL, "kv", "webhooks/", vault.Client())
+	store.Token = "vault-token"
+	checkTokens(store, "token", "access", "secret", t)
+	if _, _, err := store.GetTokens("missing"); err == nil {
+		t.Error("Expected an error getting a missing credential")
+	}
+	if names, err := store.List(); err != nil || !reflect.DeepEqual(names, []string{"token"}) {
+		t.Errorf("Credentials listed were %v with error %v, expected the secret but not the folder", names, err)
+	}
+
+	store.Token = "wrong-token"
+	if _, _, err := store.GetTokens("token"); err == nil {
+		t.Error("Expected an error getting a credential with the wrong vault token")
+	}
+
+	tokenFile, err := ioutil.TempFile("", "vault-token")
+	if err != nil {
+		t.Fatalf("Error creating token file: %s", err)
+	}
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("vault-token\n")
+	tokenFile.Close()
+	store.TokenFile = tokenFile.Name()
+	checkTokens(store, "token", "access", "secret", t)
+}
+
+func TestNewFromEnvDefaultsToKubernetes(t *testing.T) {
+	os.Unsetenv("CREDENTIAL_STORE")
+	store, err := NewFromEnv(fakek8sclientset.NewSimpleClientset(), "tekton-pipelines")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if store.Backend() != Kubernetes {
+		t.Errorf("Default credential store was %s, expected %s", store.Backend(), Kubernetes)
+	}
+
+	os.Setenv("CREDENTIAL_STORE", "etcd")
+	defer os.Unsetenv("CREDENTIAL_STORE")
+	if _, err := NewFromEnv(fakek8sclientset.NewSimpleClientset(), "tekton-pipelines"); err == nil {
+		t.Error("Expected an error for an unknown credential store")
+	}
+}
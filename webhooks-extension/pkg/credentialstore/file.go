@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentialstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore reads credentials from files mounted into the pod, for example by a CSI driver or
+// an agent sidecar. Each credential is a directory holding accessToken and secretToken files.
+type FileStore struct {
+	directory string
+}
+
+// NewFileStore creates a store for the credential directories in directory
+func NewFileStore(directory string) *FileStore {
+	return &FileStore{directory: directory}
+}
+
+// GetTokens returns the contents of the accessToken and secretToken files of the credential,
+// without surrounding whitespace. A missing accessToken file is allowed for credentials only
+// used to validate deliveries.
+func (s *FileStore) GetTokens(name string) (accessToken, secretToken string, err error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", "", fmt.Errorf("invalid credential name %q", name)
+	}
+	credentialDirectory := filepath.Join(s.directory, name)
+	if _, err := os.Stat(credentialDirectory); err != nil {
+		return "", "", fmt.Errorf("credential %s not found: %s", name, err)
+	}
+
+	secretToken, err = readTokenFile(filepath.Join(credentialDirectory, "secretToken"))
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, err = readTokenFile(filepath.Join(credentialDirectory, "accessToken"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	return accessToken, secretToken, nil
+}
+
+// List returns the names of the credential directories
+func (s *FileStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		// Mounted volumes hold hidden directories such as ..data that are not credentials
+		if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// Backend returns File
+func (s *FileStore) Backend() string {
+	return File
+}
+
+func readTokenFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
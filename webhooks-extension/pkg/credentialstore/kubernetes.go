@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentialstore
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+)
+
+// CredentialLabel is set to "true" by the extension on the Secrets it creates for credentials
+const CredentialLabel = "webhooks.tekton.dev/credential"
+
+// KubernetesStore reads credentials from the Secrets created by the extension
+type KubernetesStore struct {
+	client    k8sclient.Interface
+	namespace string
+}
+
+// NewKubernetesStore creates a store for the Secrets in namespace
+func NewKubernetesStore(client k8sclient.Interface, namespace string) *KubernetesStore {
+	return &KubernetesStore{client: client, namespace: namespace}
+}
+
+// GetTokens returns the "accessToken" and "secretToken" stored in the Secret with the given name
+func (s *KubernetesStore) GetTokens(name string) (accessToken, secretToken string, err error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	accessToken = string(secret.Data["accessToken"])
+	secretToken = string(secret.Data["secretToken"])
+	// A basic-auth credential without an access token uses its password, which can be a personal access token
+	if accessToken == "" && secret.Type == corev1.SecretTypeBasicAuth {
+		accessToken = string(secret.Data[corev1.BasicAuthPasswordKey])
+	}
+	return accessToken, secretToken, nil
+}
+
+// List returns the names of the Secrets labelled as credentials
+func (s *KubernetesStore) List() ([]string, error) {
+	secrets, err := s.client.CoreV1().Secrets(s.namespace).List(metav1.ListOptions{LabelSelector: CredentialLabel + "=true"})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	return names, nil
+}
+
+// Backend returns Kubernetes
+func (s *KubernetesStore) Backend() string {
+	return Kubernetes
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentialstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultVaultMount  = "secret"
+	defaultVaultPrefix = "tekton-webhooks/"
+)
+
+// VaultStore reads credentials from a Vault compatible KV version 2 secrets engine over HTTP.
+// Each credential is a secret at <prefix><name> holding accessToken and secretToken keys.
+type VaultStore struct {
+	address string
+	mount   string
+	prefix  string
+	client  *http.Client
+	// The token sent to Vault, or the file it is read from on each request when set, as
+	// written by an agent that renews it
+	Token     string
+	TokenFile string
+}
+
+// NewVaultStore creates a store for the secrets under prefix in the KV engine at mount
+func NewVaultStore(address, mount, prefix string, client *http.Client) *VaultStore {
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	if prefix == "" {
+		prefix = defaultVaultPrefix
+	}
+	return &VaultStore{
+		address: strings.TrimSuffix(address, "/"),
+		mount:   strings.Trim(mount, "/"),
+		prefix:  strings.TrimPrefix(prefix, "/"),
+		client:  client,
+	}
+}
+
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+}
+
+type vaultListResponse struct {
+	Data struct {
+		Keys []string `json:"keys"`
+	} `json:"data"`
+}
+
+// GetTokens returns the accessToken and secretToken keys of the latest version of the credential's secret
+func (s *VaultStore) GetTokens(name string) (accessToken, secretToken string, err error) {
+	if name == "" || strings.Contains(name, "..") {
+		return "", "", fmt.Errorf("invalid credential name %q", name)
+	}
+	status, body, err := s.request(http.MethodGet, fmt.Sprintf("%s/v1/%s/data/%s%s", s.address, s.mount, s.prefix, url.PathEscape(name)))
+	if err != nil {
+		return "", "", err
+	}
+	if status == http.StatusNotFound {
+		return "", "", fmt.Errorf("credential %s not found in vault", name)
+	}
+	if status != http.StatusOK {
+		return "", "", fmt.Errorf("error reading credential %s from vault, status %d: %s", name, status, strings.TrimSpace(string(body)))
+	}
+
+	var kv vaultKVResponse
+	if err := json.Unmarshal(body, &kv); err != nil {
+		return "", "", err
+	}
+	return kv.Data.Data["accessToken"], kv.Data.Data["secretToken"], nil
+}
+
+// List returns the names of the secrets under the prefix, not including those in folders below it
+func (s *VaultStore) List() ([]string, error) {
+	status, body, err := s.request("LIST", fmt.Sprintf("%s/v1/%s/metadata/%s", s.address, s.mount, s.prefix))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	if status == http.StatusNotFound {
+		// Vault responds 404 when there are no secrets under the prefix
+		return names, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("error listing credentials in vault, status %d: %s", status, strings.TrimSpace(string(body)))
+	}
+	var list vaultListResponse
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	for _, key := range list.Data.Keys {
+		if !strings.HasSuffix(key, "/") {
+			names = append(names, key)
+		}
+	}
+	return names, nil
+}
+
+// Sends a request to vault with the token, returning the status and body of the response
+func (s *VaultStore) request(method, requestURL string) (int, []byte, error) {
+	token := s.Token
+	if s.TokenFile != "" {
+		var err error
+		if token, err = readTokenFile(s.TokenFile); err != nil {
+			return 0, nil, err
+		}
+	}
+	request, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("X-Vault-Token", token)
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	return response.StatusCode, body, err
+}
+
+// Backend returns Vault
+func (s *VaultStore) Backend() string {
+	return Vault
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package deliveryhistory keeps the most recent deliveries to each webhook, along with the
+// interceptor's decision for each of the webhook's triggers, in a ConfigMap per webhook.
+package deliveryhistory
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Set on the ConfigMaps holding delivery histories
+	Label   = "webhooks.tekton.dev/deliveries"
+	dataKey = "deliveries"
+	// ConfigMaps are limited to 1MiB, the oldest deliveries are dropped to stay below this
+	maxHistoryBytes = 900 * 1024
+)
+
+// Delivery is a delivery as received by the interceptor
+type Delivery struct {
+	ID        string              `json:"id"`
+	Received  time.Time           `json:"received"`
+	Event     string              `json:"event,omitempty"`
+	Header    map[string][]string `json:"header"`
+	Payload   string              `json:"payload"`
+	Decisions []Decision          `json:"decisions"`
+}
+
+// Decision is the interceptor's decision on a delivery for one trigger, as an http status
+type Decision struct {
+	Trigger string `json:"trigger"`
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Store keeps the last Size deliveries to each webhook in ConfigMaps in Namespace
+type Store struct {
+	Client    k8sclient.Interface
+	Namespace string
+	Size      int
+}
+
+// ConfigMapName is the name of the ConfigMap holding the history of a webhook, identified
+// by the <name>-<namespace> prefix of its trigger names
+func ConfigMapName(webhook string) string {
+	return "webhook-deliveries-" + webhook
+}
+
+// Record adds the decision for a trigger to the delivery in the webhook's history. A
+// delivery not yet in the history is added, dropping the oldest beyond the Store's
+// size. Each trigger of a webhook records its decision on the same delivery, possibly
+// at the same time, so updates that conflict are retried.
+func (s Store) Record(webhook string, delivery Delivery, decision Decision) error {
+	if s.Size <= 0 {
+		return nil
+	}
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(ConfigMapName(webhook), metav1.GetOptions{})
+		notFound := k8serrors.IsNotFound(err)
+		if err != nil && !notFound {
+			return err
+		}
+		if notFound {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ConfigMapName(webhook),
+					Namespace: s.Namespace,
+					Labels:    map[string]string{Label: "true"},
+				},
+			}
+		}
+		deliveries, err := deliveriesFrom(configMap)
+		if err != nil {
+			return err
+		}
+		data, err := s.withDecision(deliveries, delivery, decision)
+		if err != nil {
+			return err
+		}
+		configMap.Data = map[string]string{dataKey: data}
+
+		if !notFound {
+			_, err = configMaps.Update(configMap)
+			return err
+		}
+		_, err = configMaps.Create(configMap)
+		if k8serrors.IsAlreadyExists(err) {
+			// Created by another trigger's decision since it was read
+			return k8serrors.NewConflict(corev1.Resource("configmaps"), configMap.Name, err)
+		}
+		return err
+	})
+}
+
+// The deliveries, newest first, with the decision recorded, as the ConfigMap's data
+func (s Store) withDecision(deliveries []Delivery, delivery Delivery, decision Decision) (string, error) {
+	found := false
+	for i := range deliveries {
+		if deliveries[i].ID == delivery.ID {
+			deliveries[i].Decisions = append(deliveries[i].Decisions, decision)
+			found = true
+			break
+		}
+	}
+	if !found {
+		delivery.Decisions = []Decision{decision}
+		deliveries = append([]Delivery{delivery}, deliveries...)
+	}
+	if len(deliveries) > s.Size {
+		deliveries = deliveries[:s.Size]
+	}
+	for {
+		data, err := json.Marshal(deliveries)
+		if err != nil {
+			return "", err
+		}
+		if len(data) <= maxHistoryBytes {
+			return string(data), nil
+		}
+		if len(deliveries) == 1 {
+			return "", errors.New("delivery is too large to record")
+		}
+		deliveries = deliveries[:len(deliveries)-1]
+	}
+}
+
+// List returns the webhook's deliveries, newest first
+func (s Store) List(webhook string) ([]Delivery, error) {
+	configMap, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ConfigMapName(webhook), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return []Delivery{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deliveriesFrom(configMap)
+}
+
+// Get returns the webhook's delivery with the ID, or nil if it is not in the history
+func (s Store) Get(webhook, id string) (*Delivery, error) {
+	deliveries, err := s.List(webhook)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		if delivery.ID == id {
+			return &delivery, nil
+		}
+	}
+	return nil, nil
+}
+
+// Delete removes the webhook's history
+func (s Store) Delete(webhook string) error {
+	err := s.Client.CoreV1().ConfigMaps(s.Namespace).Delete(ConfigMapName(webhook), &metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func deliveriesFrom(configMap *corev1.ConfigMap) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	if data := configMap.Data[dataKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &deliveries); err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, nil
+}
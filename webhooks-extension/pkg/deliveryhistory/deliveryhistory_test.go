@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deliveryhistory
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordMergesDecisionsAndKeepsNewest(t *testing.T) {
+	store := Store{Client: fakek8sclientset.NewSimpleClientset(), Namespace: "tekton-pipelines", Size: 2}
+	for _, id := range []string{"first", "second", "third"} {
+		delivery := Delivery{ID: id, Payload: "{}"}
+		if err := store.Record("hook-green", delivery, Decision{Trigger: "hook-green-push-event", Status: 200}); err != nil {
+			t.Fatalf("Error recording delivery %s: %s", id, err)
+		}
+		if err := store.Record("hook-green", delivery, Decision{Trigger: "hook-green-pullrequest-event", Status: 202, Message: "skipped"}); err != nil {
+			t.Fatalf("Error recording delivery %s: %s", id, err)
+		}
+	}
+
+	deliveries, err := store.List("hook-green")
+	if err != nil {
+		t.Fatalf("Error listing deliveries: %s", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].ID != "third" || deliveries[1].ID != "second" {
+		t.Fatalf("Deliveries were %+v, expected the newest two, newest first", deliveries)
+	}
+	if len(deliveries[0].Decisions) != 2 || deliveries[0].Decisions[1].Status != 202 {
+		t.Errorf("Decisions were %+v, expected one for each trigger", deliveries[0].Decisions)
+	}
+
+	configMap, err := store.Client.CoreV1().ConfigMaps("tekton-pipelines").Get(ConfigMapName("hook-green"), metav1.GetOptions{})
+	if err != nil || configMap.Labels[Label] != "true" {
+		t.Errorf("History ConfigMap was %+v with error %v, expected it to be labelled", configMap, err)
+	}
+}
+
+func TestRecordDropsOldestBeyondSizeLimit(t *testing.T) {
+	store := Store{Client: fakek8sclientset.NewSimpleClientset(), Namespace: "tekton-pipelines", Size: 10}
+	large := strings.Repeat("x", maxHistoryBytes/3)
+	for _, id := range []string{"first", "second", "third"} {
+		if err := store.Record("hook-green", Delivery{ID: id, Payload: large}, Decision{Status: 200}); err != nil {
+			t.Fatalf("Error recording delivery %s: %s", id, err)
+		}
+	}
+	deliveries, _ := store.List("hook-green")
+	if len(deliveries) != 2 || deliveries[0].ID != "third" {
+		t.Errorf("Recorded %d deliveries, expected the oldest to be dropped to fit", len(deliveries))
+	}
+
+	if err := store.Record("hook-green", Delivery{ID: "huge", Payload: large + large + large}, Decision{Status: 200}); err == nil {
+		t.Error("Expected an error recording a delivery too large to fit")
+	}
+}
+
+func TestGetAndDelete(t *testing.T) {
+	store := Store{Client: fakek8sclientset.NewSimpleClientset(), Namespace: "tekton-pipelines", Size: 10}
+	if delivery, err := store.Get("hook-green", "missing"); delivery != nil || err != nil {
+		t.Errorf("Get without a history returned %+v with error %v, expected neither", delivery, err)
+	}
+	store.Record("hook-green", Delivery{ID: "an-id", Payload: "{}"}, Decision{Status: 200})
+	if delivery, err := store.Get("hook-green", "an-id"); err != nil || delivery == nil || delivery.Payload != "{}" {
+		t.Errorf("Get returned %+v with error %v, expected the recorded delivery", delivery, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store.Delete("hook-green"); err != nil {
+			t.Errorf("Delete %d returned error %s", i, err)
+		}
+	}
+	if deliveries, err := store.List("hook-green"); err != nil || len(deliveries) != 0 {
+		t.Errorf("List after delete returned %+v with error %v, expected no deliveries", deliveries, err)
+	}
+}
+
+func TestRecordDisabled(t *testing.T) {
+	store := Store{Client: fakek8sclientset.NewSimpleClientset(), Namespace: "tekton-pipelines"}
+	store.Record("hook-green", Delivery{ID: "an-id"}, Decision{Status: 200})
+	if deliveries, _ := store.List("hook-green"); len(deliveries) != 0 {
+		t.Errorf("Recorded %+v with a size of 0", deliveries)
+	}
+}
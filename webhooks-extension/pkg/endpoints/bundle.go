@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/ghodss/yaml"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/credentialstore"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/*--------------------------------------
+Webhooks are exported as a bundle that can be imported into another cluster to
+recreate them. Credentials are only referenced by name, they must be created in
+the new cluster before the webhooks using them are imported.
+---------------------------------------*/
+
+const (
+	bundleVersion = "v1"
+	mimeYAML      = "application/yaml"
+)
+
+type webhookBundle struct {
+	Version     string                `json:"version"`
+	Webhooks    []webhook             `json:"webhooks"`
+	Credentials []credentialReference `json:"credentials,omitempty"`
+}
+
+// The name of a credential webhooks use and, when it is a Kubernetes secret, its type and git server
+type credentialReference struct {
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	GitServer string `json:"gitserver,omitempty"`
+}
+
+// The outcome of importing a bundle, by webhook
+type importReport struct {
+	Created []importEntry `json:"created"`
+	Skipped []importEntry `json:"skipped"`
+	Failed  []importEntry `json:"failed"`
+}
+
+type importEntry struct {
+	Name             string `json:"name"`
+	Namespace        string `json:"namespace"`
+	GitRepositoryURL string `json:"gitrepositoryurl"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+func newImportEntry(hook webhook, reason string) importEntry {
+	return importEntry{Name: hook.Name, Namespace: hook.Namespace, GitRepositoryURL: hook.GitRepositoryURL, Reason: reason}
+}
+
+/*
+	Writes every webhook as a bundle, in YAML if format=yaml and JSON otherwise. With
+	credentials=true references to the credentials they use are included.
+*/
+func (r Resource) exportWebhooks(request *restful.Request, response *restful.Response) {
+	withCredentials := false
+	if credentials := request.QueryParameter("credentials"); credentials != "" {
+		var err error
+		if withCredentials, err = strconv.ParseBool(credentials); err != nil {
+			theError := errors.New("bad request information provided, cannot handle credentials query (should be set to true or not provided)")
+			logging.Log.Error(theError)
+			RespondError(response, theError, http.StatusBadRequest)
+			return
+		}
+	}
+
+	webhooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		logging.Log.Errorf("error trying to get webhooks: %s.", err.Error())
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	bundle := webhookBundle{Version: bundleVersion, Webhooks: webhooks}
+	if withCredentials {
+		bundle.Credentials = r.credentialReferences(webhooks)
+	}
+
+	if request.QueryParameter("format") != "yaml" {
+		response.WriteEntity(bundle)
+		return
+	}
+	body, err := yaml.Marshal(bundle)
+	if err != nil {
+		logging.Log.Errorf("error writing webhooks as YAML: %s", err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	response.AddHeader("Content-Type", mimeYAML)
+	response.WriteHeader(http.StatusOK)
+	response.Write(body)
+}
+
+// References to the credentials the webhooks use, never their values
+func (r Resource) credentialReferences(webhooks []webhook) []credentialReference {
+	references := []credentialReference{}
+	seen := map[string]bool{}
+	for _, hook := range webhooks {
+		if seen[hook.AccessTokenRef] {
+			continue
+		}
+		seen[hook.AccessTokenRef] = true
+		reference := credentialReference{Name: hook.AccessTokenRef}
+		if r.CredentialStore.Backend() == credentialstore.Kubernetes {
+			secret, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(hook.AccessTokenRef, metav1.GetOptions{})
+			if err == nil {
+				cred := secretToCredential(secret, true)
+				reference.Type = cred.Type
+				reference.GitServer = cred.GitServer
+			} else if !k8serrors.IsNotFound(err) {
+				logging.Log.Errorf("error getting credential %s: %s", hook.AccessTokenRef, err)
+			}
+		}
+		references = append(references, reference)
+	}
+	return references
+}
+
+/*
+	Creates the webhooks in a bundle, in JSON or YAML. Webhooks that already exist are
+	skipped, so a bundle can be imported again after a failure.
+*/
+func (r Resource) importWebhooks(request *restful.Request, response *restful.Response) {
+	body, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		logging.Log.Errorf("error reading webhook bundle: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	bundle := webhookBundle{}
+	// JSON is YAML, so either is read
+	if err := yaml.Unmarshal(body, &bundle); err != nil {
+		logging.Log.Errorf("error reading webhook bundle: %s", err)
+		RespondError(response, fmt.Errorf("error reading webhook bundle: %s", err), http.StatusBadRequest)
+		return
+	}
+	if bundle.Version != "" && bundle.Version != bundleVersion {
+		err := fmt.Errorf("unsupported webhook bundle version %s, expected %s", bundle.Version, bundleVersion)
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	report := importReport{Created: []importEntry{}, Skipped: []importEntry{}, Failed: []importEntry{}}
+	for _, hook := range bundle.Webhooks {
+		created, reason := r.importWebhook(hook)
+		switch {
+		case created:
+			report.Created = append(report.Created, newImportEntry(hook, ""))
+		case reason == importSkipped:
+			report.Skipped = append(report.Skipped, newImportEntry(hook, reason))
+		default:
+			report.Failed = append(report.Failed, newImportEntry(hook, reason))
+		}
+	}
+	logging.Log.Infof("Imported webhooks: %d created, %d skipped and %d failed", len(report.Created), len(report.Skipped), len(report.Failed))
+	response.WriteEntity(report)
+}
+
+const importSkipped = "a webhook with this name already targets this namespace for the repository"
+
+// Creates a webhook from a bundle, returning why it was not created if it was not
+func (r Resource) importWebhook(hook webhook) (bool, string) {
+	hooks, validationErrors := r.validateWebhook(&hook)
+	for _, existing := range hooks {
+		if existing.Name == hook.Name && existing.Namespace == hook.Namespace {
+			return false, importSkipped
+		}
+	}
+	if len(validationErrors) > 0 {
+		reasons := []string{}
+		for _, err := range validationErrors {
+			reasons = append(reasons, err.Error())
+		}
+		return false, strings.Join(reasons, ". ")
+	}
+	if _, _, err := r.CredentialStore.GetTokens(hook.AccessTokenRef); err != nil {
+		return false, fmt.Sprintf("credential %s could not be read, create it before importing: %s", hook.AccessTokenRef, err)
+	}
+
+	creation, err := r.newWebhookCreation(hook, len(gitProviderHooks(hooks)) == 0)
+	if err != nil {
+		return false, err.Error()
+	}
+	if _, err := creation.run(); err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+var bundleHooks = []webhook{
+	{
+		Name:             "existing",
+		Namespace:        "pipelines",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "token",
+		Pipeline:         "pipeline",
+		PullTask:         "monitor-task",
+	},
+	{
+		Name:             "imported",
+		Namespace:        "pipelines",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "token",
+		Pipeline:         "pipeline2",
+		PullTask:         "monitor-task",
+	},
+}
+
+// A resource with the first webhook in the eventlistener, so that GitHub is not called for the repository
+func dummyBundleResource(t *testing.T) *Resource {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	if _, err := r.createEventListener(bundleHooks[0], installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	createTriggerResources(bundleHooks[0], r)
+	return r
+}
+
+func exportWebhooks(r *Resource, query string) *httptest.ResponseRecorder {
+	httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/export"+query, nil)
+	httpWriter := httptest.NewRecorder()
+	r.exportWebhooks(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(httpWriter))
+	return httpWriter
+}
+
+func TestExportWebhooks(t *testing.T) {
+	r := dummyBundleResource(t)
+
+	httpWriter := exportWebhooks(r, "?credentials=true")
+	bundle := webhookBundle{}
+	if err := json.NewDecoder(httpWriter.Body).Decode(&bundle); err != nil {
+		t.Fatalf("Error decoding bundle: %s", err)
+	}
+	if len(bundle.Webhooks) != 1 || bundle.Webhooks[0].Name != "existing" {
+		t.Errorf("Bundle had webhooks %+v, expected existing", bundle.Webhooks)
+	}
+	if len(bundle.Credentials) != 1 || bundle.Credentials[0].Name != "token" {
+		t.Errorf("Bundle had credentials %+v, expected a reference to token", bundle.Credentials)
+	}
+	if strings.Contains(httpWriter.Body.String(), "secret") {
+		t.Errorf("Bundle contained a secret value")
+	}
+
+	httpWriter = exportWebhooks(r, "?format=yaml")
+	bundle = webhookBundle{}
+	if err := yaml.Unmarshal(httpWriter.Body.Bytes(), &bundle); err != nil {
+		t.Fatalf("Error decoding YAML bundle: %s", err)
+	}
+	if len(bundle.Webhooks) != 1 || bundle.Credentials != nil {
+		t.Errorf("YAML bundle had webhooks %+v and credentials %+v, expected one webhook and no credentials", bundle.Webhooks, bundle.Credentials)
+	}
+}
+
+func TestImportWebhooks(t *testing.T) {
+	r := dummyBundleResource(t)
+	createTriggerResources(bundleHooks[1], r)
+	missingPipeline := bundleHooks[1]
+	missingPipeline.Name = "missing"
+	missingPipeline.Pipeline = "missing-pipeline"
+	bundle, _ := yaml.Marshal(webhookBundle{Version: bundleVersion, Webhooks: []webhook{bundleHooks[0], bundleHooks[1], missingPipeline}})
+
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/import", bytes.NewBuffer(bundle))
+	httpReq.Header.Set("Content-Type", mimeYAML)
+	httpWriter := httptest.NewRecorder()
+	r.importWebhooks(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(httpWriter))
+
+	report := importReport{}
+	if err := json.NewDecoder(httpWriter.Body).Decode(&report); err != nil {
+		t.Fatalf("Error decoding import report: %s", err)
+	}
+	if len(report.Created) != 1 || report.Created[0].Name != "imported" {
+		t.Errorf("Import created %+v, expected imported", report.Created)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Name != "existing" {
+		t.Errorf("Import skipped %+v, expected existing", report.Skipped)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Name != "missing" || !strings.Contains(report.Failed[0].Reason, "missing-pipeline-template") {
+		t.Errorf("Import failed %+v, expected missing for want of its trigger template", report.Failed)
+	}
+
+	hooks, _ := r.getWebhooksFromEventListener()
+	if len(hooks) != 2 {
+		t.Errorf("Eventlistener had %d webhooks after import, expected 2", len(hooks))
+	}
+}
+
+func TestImportRefusesUnknownBundleVersion(t *testing.T) {
+	r := dummyBundleResource(t)
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/import", strings.NewReader(`{"version": "v2", "webhooks": []}`))
+	httpWriter := httptest.NewRecorder()
+	r.importWebhooks(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != 400 {
+		t.Errorf("Import of an unknown bundle version returned %d, expected 400", httpWriter.Code)
+	}
+}
@@ -0,0 +1,474 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	github "github.com/google/go-github/github"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+)
+
+/*--------------------------------------
+With STATUS_REPORTING set to "checks" the status of a pull request's PipelineRuns is
+reported as a GitHub check run for each PipelineRun, rather than a single commit
+status. Check runs are updated as the PipelineRun's tasks complete, and once it has
+completed show a table of its tasks with their durations and failure reasons. Check
+runs can only be created with a GitHub App's installation token.
+
+Completed check runs have a Re-run action. The GitHub App sends the check_run events
+for it, and for re-run requests made with GitHub's own button, to the extension, which
+runs a copy of the PipelineRun and reports on it as for a new delivery.
+---------------------------------------*/
+
+const (
+	// The check run of each of a delivery's runs, as JSON mapping namespace/name to its ID and what was last reported
+	checkRunsAnnotation = "webhooks.tekton.dev/check-runs"
+
+	checkStatusInProgress = "in_progress"
+	checkStatusCompleted  = "completed"
+
+	rerunActionIdentifier = "rerun"
+)
+
+type checkRun struct {
+	Name        string
+	ExternalID  string
+	DetailsURL  string
+	Status      string
+	Conclusion  string
+	StartedAt   *metav1.Time
+	CompletedAt *metav1.Time
+	Title       string
+	Summary     string
+	Text        string
+	Actions     []checkRunAction
+}
+
+type checkRunAction struct {
+	Label       string
+	Description string
+	Identifier  string
+}
+
+// A check run reported for a PipelineRun
+type reportedCheck struct {
+	ID     int64  `json:"id"`
+	Digest string `json:"digest"`
+	// Whether the check run has changed since it was last reported
+	changed bool
+}
+
+type rerunResult struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+var rerunAction = checkRunAction{Label: "Re-run", Description: "Run the pipeline again", Identifier: rerunActionIdentifier}
+
+func reportingCheckRuns() bool {
+	return os.Getenv("STATUS_REPORTING") == "checks"
+}
+
+// The check run of each of the delivery's runs by namespace/name, including those seen earlier that have since been deleted.
+// Check runs link to the PipelineRuns in the dashboard, found from its service as for new webhooks.
+func newCheckRuns(record *pipelinesv1alpha1.PipelineResource, report deliveryReport, pipelineRuns []pipelinesv1alpha1.PipelineRun, dashboard string, now time.Time) map[string]checkRun {
+	_, number, _ := parsePullRequestURL(recordParam(record, "url"))
+	timedOut := report.Status.State == commitStateError
+	checkRuns := map[string]checkRun{}
+	for _, pipelineRun := range pipelineRuns {
+		checkRuns[pipelineRun.Namespace+"/"+pipelineRun.Name] = newCheckRun(pipelineRun, dashboard, number, timedOut, now)
+	}
+	for _, run := range report.Runs {
+		if run.State != runStateMissing {
+			continue
+		}
+		completed := metav1.NewTime(now)
+		checkRuns[run.Namespace+"/"+run.Name] = checkRun{
+			Name:        run.Pipeline,
+			ExternalID:  fmt.Sprintf("%s/%s/%d", run.Namespace, run.Name, number),
+			DetailsURL:  dashboard + "/#/namespaces/" + run.Namespace + "/pipelineruns/",
+			Status:      checkStatusCompleted,
+			Conclusion:  "cancelled",
+			CompletedAt: &completed,
+			Title:       "Deleted",
+			Summary:     fmt.Sprintf("PipelineRun %s in namespace %s was deleted before it completed.", run.Name, run.Namespace),
+		}
+	}
+	return checkRuns
+}
+
+func newCheckRun(pipelineRun pipelinesv1alpha1.PipelineRun, dashboard string, number int, timedOut bool, now time.Time) checkRun {
+	link := dashboard + "/#/namespaces/" + pipelineRun.Namespace + "/pipelineruns/" + pipelineRun.Name
+	run := checkRun{
+		Name:       pipelineRun.Spec.PipelineRef.Name,
+		ExternalID: fmt.Sprintf("%s/%s/%d", pipelineRun.Namespace, pipelineRun.Name, number),
+		DetailsURL: link,
+		Status:     checkStatusCompleted,
+		StartedAt:  pipelineRun.Status.StartTime,
+		Summary:    fmt.Sprintf("PipelineRun [%s](%s) in namespace %s", pipelineRun.Name, link, pipelineRun.Namespace),
+		Text:       taskTable(pipelineRun),
+		Actions:    []checkRunAction{rerunAction},
+	}
+
+	tasks, completedTasks, failedTasks := 0, 0, []string{}
+	for _, taskRun := range pipelineRun.Status.TaskRuns {
+		tasks++
+		if taskRun.Status == nil || taskRun.Status.GetCondition(apis.ConditionSucceeded).IsUnknown() {
+			continue
+		}
+		completedTasks++
+		if taskRun.Status.GetCondition(apis.ConditionSucceeded).IsFalse() {
+			failedTasks = append(failedTasks, taskRun.PipelineTaskName)
+		}
+	}
+	sort.Strings(failedTasks)
+
+	switch newWebhookRun(pipelineRun).State {
+	case runStateRunning:
+		if !timedOut {
+			run.Status, run.Actions = checkStatusInProgress, nil
+			run.Title = fmt.Sprintf("%d tasks complete, %d running", completedTasks, tasks-completedTasks)
+			return run
+		}
+		completed := metav1.NewTime(now)
+		run.Conclusion, run.CompletedAt, run.Title = "timed_out", &completed, "Timed out"
+		return run
+	case runStateSucceeded:
+		run.Conclusion, run.Title = "success", fmt.Sprintf("%d tasks succeeded", tasks)
+	case runStateCancelled:
+		run.Conclusion, run.Title = "cancelled", "Cancelled"
+	default:
+		run.Conclusion, run.Title = "failure", "Failed"
+		if len(failedTasks) > 0 {
+			run.Title = "Failed: " + strings.Join(failedTasks, ", ")
+		}
+	}
+	run.CompletedAt = pipelineRun.Status.CompletionTime
+	if duration := taskDuration(pipelineRun.Status.StartTime, pipelineRun.Status.CompletionTime); duration != "" {
+		run.Summary += " ran for " + duration
+	}
+	if condition := pipelineRun.Status.GetCondition(apis.ConditionSucceeded); condition != nil && condition.IsFalse() && condition.Message != "" {
+		run.Summary += "\n\n" + condition.Message
+	}
+	return run
+}
+
+// A markdown table of the PipelineRun's tasks in the order they started, with their status, duration and failure reason
+func taskTable(pipelineRun pipelinesv1alpha1.PipelineRun) string {
+	taskRuns := []*pipelinesv1alpha1.PipelineRunTaskRunStatus{}
+	for _, taskRun := range pipelineRun.Status.TaskRuns {
+		if taskRun.Status != nil {
+			taskRuns = append(taskRuns, taskRun)
+		}
+	}
+	if len(taskRuns) == 0 {
+		return ""
+	}
+	sort.Slice(taskRuns, func(i, j int) bool {
+		iStart, jStart := taskRuns[i].Status.StartTime, taskRuns[j].Status.StartTime
+		if iStart != nil && jStart != nil && !iStart.Equal(jStart) {
+			return iStart.Before(jStart)
+		}
+		return taskRuns[i].PipelineTaskName < taskRuns[j].PipelineTaskName
+	})
+
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+	lines := []string{
+		"Task | Status | Duration | Reason",
+		":--- | :----- | :------- | :-----",
+	}
+	for _, taskRun := range taskRuns {
+		status, duration, reason := "Running", "", ""
+		condition := taskRun.Status.GetCondition(apis.ConditionSucceeded)
+		switch {
+		case condition == nil || condition.IsUnknown():
+		case condition.IsTrue():
+			status = "Succeeded"
+			duration = taskDuration(taskRun.Status.StartTime, taskRun.Status.CompletionTime)
+		default:
+			status = "Failed"
+			duration = taskDuration(taskRun.Status.StartTime, taskRun.Status.CompletionTime)
+			reason = condition.Message
+			if reason == "" {
+				reason = condition.Reason
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s | %s | %s | %s", taskRun.PipelineTaskName, status, duration, escape.Replace(reason)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func taskDuration(start, completion *metav1.Time) string {
+	if start == nil || completion == nil {
+		return ""
+	}
+	return completion.Sub(start.Time).Round(time.Second).String()
+}
+
+// The check runs recorded for the delivery, marking those that have changed since they were reported
+func changedCheckRuns(record *pipelinesv1alpha1.PipelineResource, checkRuns map[string]checkRun) (map[string]reportedCheck, bool) {
+	checks := map[string]reportedCheck{}
+	if annotation := record.Annotations[checkRunsAnnotation]; annotation != "" {
+		if err := json.Unmarshal([]byte(annotation), &checks); err != nil {
+			logging.Log.Errorf("error reading the check runs reported for %s: %s", record.Name, err)
+		}
+	}
+	changed := false
+	for key, run := range checkRuns {
+		check := checks[key]
+		digest := checkRunDigest(run)
+		if check.Digest != digest {
+			check.Digest, check.changed, changed = digest, true, true
+		}
+		checks[key] = check
+	}
+	return checks, changed
+}
+
+// Identifies what a check run shows, other than the times
+func checkRunDigest(run checkRun) string {
+	content := strings.Join([]string{run.Name, run.Status, run.Conclusion, run.Title, run.Summary, run.Text}, "\n")
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))[:16]
+}
+
+func formatReportedChecks(checks map[string]reportedCheck) string {
+	annotation, err := json.Marshal(checks)
+	if err != nil {
+		return ""
+	}
+	return string(annotation)
+}
+
+/*
+	Creates or updates the check runs that have changed. The IDs of the check runs
+	created are recorded, so they are updated from then on, and the check runs that
+	could not be reported are marked to be tried again.
+*/
+func (r Resource) reportCheckRuns(gitProvider GitProvider, record *pipelinesv1alpha1.PipelineResource, checkRuns map[string]checkRun, checks map[string]reportedCheck) error {
+	keys := []string{}
+	for key := range checkRuns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var reportErr error
+	rerecord := false
+	for _, key := range keys {
+		check := checks[key]
+		if !check.changed {
+			continue
+		}
+		var err error
+		if check.ID == 0 {
+			check.ID, err = gitProvider.CreateCheckRun(record.Annotations[headCommitAnnotation], checkRuns[key])
+			rerecord = rerecord || err == nil
+		} else {
+			err = gitProvider.UpdateCheckRun(check.ID, checkRuns[key])
+		}
+		if err != nil {
+			check.Digest, rerecord = "", true
+			if reportErr == nil {
+				reportErr = fmt.Errorf("error reporting the check run of %s: %s", key, err)
+			}
+		}
+		checks[key] = check
+	}
+	if !rerecord {
+		return reportErr
+	}
+
+	records := r.TektonClient.TektonV1alpha1().PipelineResources(r.Defaults.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := records.Get(record.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = map[string]string{}
+		}
+		latest.Annotations[checkRunsAnnotation] = formatReportedChecks(checks)
+		_, err = records.Update(latest)
+		return err
+	})
+	if reportErr == nil {
+		reportErr = ignoreNotFound(err)
+	}
+	return reportErr
+}
+
+/*
+	Handles the check_run events of the GitHub App that reports check runs. A PipelineRun
+	is run again for the Re-run action of its check run, or when GitHub's own re-run
+	is requested, and other events are ignored.
+*/
+func (r Resource) handleCheckRunEvent(request *restful.Request, response *restful.Response) {
+	secret := os.Getenv("CHECK_RUN_WEBHOOK_SECRET")
+	if secret == "" {
+		theError := errors.New("check run events are not accepted as CHECK_RUN_WEBHOOK_SECRET is not set")
+		logging.Log.Error(theError)
+		RespondError(response, theError, http.StatusNotFound)
+		return
+	}
+	payload, err := github.ValidatePayload(request.Request, []byte(secret))
+	if err != nil {
+		logging.Log.Errorf("error validating check run event: %s", err)
+		RespondError(response, err, http.StatusForbidden)
+		return
+	}
+	if github.WebHookType(request.Request) != "check_run" {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	event := github.CheckRunEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		logging.Log.Errorf("error reading check run event: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	rerun := event.GetAction() == "rerequested" ||
+		(event.GetAction() == "requested_action" && event.RequestedAction != nil && event.RequestedAction.Identifier == rerunActionIdentifier)
+	if !rerun {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	parts := strings.SplitN(event.GetCheckRun().GetExternalID(), "/", 3)
+	number := 0
+	if len(parts) == 3 {
+		number, err = strconv.Atoi(parts[2])
+	}
+	if len(parts) != 3 || err != nil {
+		theError := fmt.Errorf("check run %d was not reported for a PipelineRun", event.GetCheckRun().GetID())
+		logging.Log.Error(theError)
+		RespondError(response, theError, http.StatusBadRequest)
+		return
+	}
+	result, status, err := r.rerunPipelineRun(parts[0], parts[1], event.GetRepo().GetHTMLURL(), number, event.GetCheckRun().GetHeadSHA())
+	if err != nil {
+		logging.Log.Errorf("error re-running PipelineRun %s in namespace %s: %s", parts[1], parts[0], err)
+		RespondError(response, err, status)
+		return
+	}
+	logging.Log.Infof("Re-ran PipelineRun %s in namespace %s as %s", parts[1], parts[0], result.Name)
+	response.WriteHeaderAndEntity(http.StatusCreated, result)
+}
+
+/*
+	Runs a copy of a webhook's PipelineRun, under an event ID of its own, and records
+	the pull request to report the status of the copy on. The PipelineRun must belong
+	to a webhook on the repository the check run was for.
+*/
+func (r Resource) rerunPipelineRun(namespace, name, repoURL string, number int, headSHA string) (rerunResult, int, error) {
+	original, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return rerunResult{}, http.StatusNotFound, err
+	}
+	_, org, repo, err := getGitValues(repoURL)
+	if err != nil {
+		return rerunResult{}, http.StatusBadRequest, err
+	}
+	hooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		return rerunResult{}, http.StatusInternalServerError, err
+	}
+	var hook *webhook
+	for i := range hooks {
+		_, hookOrg, hookRepo, err := getGitValues(hooks[i].GitRepositoryURL)
+		if err == nil && hookOrg == org && hookRepo == repo && hooks[i].Namespace == namespace &&
+			!isGeneric(hooks[i]) && pipelineRunForWebhook(*original, hooks[i].GitRepositoryURL, hooks[i].Pipeline) {
+			hook = &hooks[i]
+			break
+		}
+	}
+	if hook == nil {
+		return rerunResult{}, http.StatusNotFound, fmt.Errorf("no webhook on %s runs PipelineRun %s in namespace %s", repoURL, name, namespace)
+	}
+
+	eventID := newRerunEventID()
+	record := newStatusRecord(*hook, eventID, fmt.Sprintf("%s/pull/%d", strings.TrimSuffix(repoURL, "/"), number), headSHA, r.getDashboardURL(r.Defaults.Namespace))
+	if _, err := r.TektonClient.TektonV1alpha1().PipelineResources(r.Defaults.Namespace).Create(record); err != nil {
+		return rerunResult{}, http.StatusInternalServerError, err
+	}
+
+	generateName := original.GenerateName
+	if generateName == "" {
+		generateName = original.Name + "-"
+	}
+	labels := map[string]string{}
+	for key, value := range original.Labels {
+		labels[key] = value
+	}
+	labels[triggersEventIDLabel] = eventID
+	copied := &pipelinesv1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: generateName, Namespace: namespace, Labels: labels},
+		Spec:       *original.Spec.DeepCopy(),
+	}
+	copied.Spec.Status = ""
+	created, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).Create(copied)
+	if err != nil {
+		return rerunResult{}, http.StatusInternalServerError, err
+	}
+	return rerunResult{Name: created.Name, Namespace: namespace}, http.StatusCreated, nil
+}
+
+// The record of a pull request to report a delivery's runs on, as the monitor trigger template creates it
+func newStatusRecord(hook webhook, eventID, pullRequestURL, headSHA, dashboardURL string) *pipelinesv1alpha1.PipelineResource {
+	annotations := map[string]string{
+		headCommitAnnotation: headSHA,
+		dashboardAnnotation:  dashboardURL,
+	}
+	for name, comment := range map[string]string{"success": hook.OnSuccessComment, "failure": hook.OnFailureComment, "timeout": hook.OnTimeoutComment, "missing": hook.OnMissingComment} {
+		if comment != "" {
+			annotations[commentAnnotationBase+name] = comment
+		}
+	}
+	return &pipelinesv1alpha1.PipelineResource{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "pull-request-",
+			Labels:       map[string]string{triggersEventIDLabel: eventID, statusReportLabel: "true"},
+			Annotations:  annotations,
+		},
+		Spec: pipelinesv1alpha1.PipelineResourceSpec{
+			Type:         pipelinesv1alpha1.PipelineResourceTypePullRequest,
+			Params:       []pipelinesv1alpha1.ResourceParam{{Name: "url", Value: pullRequestURL}},
+			SecretParams: []pipelinesv1alpha1.SecretParam{{FieldName: "githubToken", SecretName: hook.AccessTokenRef, SecretKey: "accessToken"}},
+		},
+	}
+}
+
+// A random ID for the event of a re-run, in place of the one Tekton Triggers gives a delivery
+func newRerunEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
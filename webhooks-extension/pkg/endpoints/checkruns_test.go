@@ -0,0 +1,236 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+func taskRunStatus(task string, start time.Time, duration time.Duration, status corev1.ConditionStatus, message string) *pipelinesv1alpha1.PipelineRunTaskRunStatus {
+	taskRun := &pipelinesv1alpha1.PipelineRunTaskRunStatus{PipelineTaskName: task, Status: &pipelinesv1alpha1.TaskRunStatus{}}
+	taskRun.Status.StartTime = &metav1.Time{Time: start}
+	if status != corev1.ConditionUnknown {
+		taskRun.Status.CompletionTime = &metav1.Time{Time: start.Add(duration)}
+	}
+	taskRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: status, Message: message})
+	return taskRun
+}
+
+func TestNewCheckRun(t *testing.T) {
+	now := time.Now()
+	pipelineRun := webhookPipelineRun("run", "pipeline", "feature", now, corev1.ConditionUnknown, "Running")
+	pipelineRun.Status.TaskRuns = map[string]*pipelinesv1alpha1.PipelineRunTaskRunStatus{
+		"run-build": taskRunStatus("build", now, 62*time.Second, corev1.ConditionTrue, ""),
+		"run-test":  taskRunStatus("test", now.Add(time.Minute), 0, corev1.ConditionUnknown, ""),
+	}
+
+	run := newCheckRun(*pipelineRun, "http://localhost:9097", 7, false, now)
+	if run.Status != checkStatusInProgress || run.Title != "1 tasks complete, 1 running" || len(run.Actions) != 0 {
+		t.Errorf("Check run of a running PipelineRun was %+v", run)
+	}
+	if run.Name != "pipeline" || run.ExternalID != "pipelines/run/7" || run.DetailsURL != "http://localhost:9097/#/namespaces/pipelines/pipelineruns/run" {
+		t.Errorf("Check run was named %s with external ID %s and details URL %s", run.Name, run.ExternalID, run.DetailsURL)
+	}
+
+	pipelineRun.Status.TaskRuns["run-test"] = taskRunStatus("test", now.Add(time.Minute), 10*time.Second, corev1.ConditionFalse, "step test exited with code 1 | see logs")
+	pipelineRun.Status.StartTime = &metav1.Time{Time: now}
+	pipelineRun.Status.CompletionTime = &metav1.Time{Time: now.Add(70 * time.Second)}
+	pipelineRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Reason: "Failed", Message: "Tasks Completed: 2, Failed: 1"})
+	run = newCheckRun(*pipelineRun, "http://localhost:9097", 7, false, now)
+	if run.Status != checkStatusCompleted || run.Conclusion != "failure" || run.Title != "Failed: test" || !reflect.DeepEqual(run.Actions, []checkRunAction{rerunAction}) {
+		t.Errorf("Check run of a failed PipelineRun was %+v", run)
+	}
+	expectedSummary := "PipelineRun [run](http://localhost:9097/#/namespaces/pipelines/pipelineruns/run) in namespace pipelines ran for 1m10s\n\nTasks Completed: 2, Failed: 1"
+	if run.Summary != expectedSummary {
+		t.Errorf("Summary of the check run was %q, expected %q", run.Summary, expectedSummary)
+	}
+	expectedText := "Task | Status | Duration | Reason\n" +
+		":--- | :----- | :------- | :-----\n" +
+		"build | Succeeded | 1m2s | \n" +
+		"test | Failed | 10s | step test exited with code 1 \\| see logs"
+	if run.Text != expectedText {
+		t.Errorf("Task table of the check run was %q, expected %q", run.Text, expectedText)
+	}
+}
+
+func TestReportCheckRuns(t *testing.T) {
+	provider := &reportingGitProvider{}
+	defer func(providerFor func(Resource, webhook, string, string) (GitProvider, error)) {
+		statusProviderFor = providerFor
+	}(statusProviderFor)
+	statusProviderFor = func(r Resource, hook webhook, org, repo string) (GitProvider, error) {
+		return provider, nil
+	}
+	os.Setenv("STATUS_REPORTING", "checks")
+	defer os.Unsetenv("STATUS_REPORTING")
+
+	r := dummyResource()
+	r.TektonClient = fakeclientset.NewSimpleClientset()
+	now := time.Now()
+	r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Create(statusReportRecord("event", now))
+	pipelineRun := webhookPipelineRun("run", "pipeline", "feature", now, corev1.ConditionUnknown, "Running")
+	pipelineRun.Labels[triggersEventIDLabel] = "event"
+	pipelineRun.Status.TaskRuns = map[string]*pipelinesv1alpha1.PipelineRunTaskRunStatus{
+		"run-build": taskRunStatus("build", now, 0, corev1.ConditionUnknown, ""),
+	}
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(pipelineRun)
+
+	r.reportDeliveries("event", time.Hour, now)
+	r.reportDeliveries("event", time.Hour, now)
+	pipelineRun.Status.TaskRuns["run-build"] = taskRunStatus("build", now, time.Second, corev1.ConditionTrue, "")
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Update(pipelineRun)
+	r.reportDeliveries("event", time.Hour, now)
+	pipelineRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionTrue})
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Update(pipelineRun)
+	r.reportDeliveries("event", time.Hour, now)
+
+	expectedChecks := []string{
+		"create 1234567890abcdef pipeline in_progress  0 tasks complete, 1 running",
+		"update 1 pipeline in_progress  1 tasks complete, 0 running",
+		"update 1 pipeline completed success 1 tasks succeeded",
+	}
+	if !reflect.DeepEqual(provider.checks, expectedChecks) {
+		t.Errorf("Check runs reported were %v, expected %v", provider.checks, expectedChecks)
+	}
+	if len(provider.statuses) != 0 || len(provider.comments) != 1 {
+		t.Errorf("Reported statuses %v and comments %v, expected only the summary comment", provider.statuses, provider.comments)
+	}
+}
+
+func TestReportCheckRunsRetriesAFailedFinalReport(t *testing.T) {
+	provider := &reportingGitProvider{fail: map[string]int{"CreateCheckRun": 1}}
+	defer func(providerFor func(Resource, webhook, string, string) (GitProvider, error)) {
+		statusProviderFor = providerFor
+	}(statusProviderFor)
+	statusProviderFor = func(r Resource, hook webhook, org, repo string) (GitProvider, error) {
+		return provider, nil
+	}
+	os.Setenv("STATUS_REPORTING", "checks")
+	defer os.Unsetenv("STATUS_REPORTING")
+
+	r := dummyResource()
+	r.TektonClient = fakeclientset.NewSimpleClientset()
+	now := time.Now()
+	r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Create(statusReportRecord("event", now))
+	pipelineRun := webhookPipelineRun("run", "pipeline", "feature", now, corev1.ConditionTrue, "Succeeded")
+	pipelineRun.Labels[triggersEventIDLabel] = "event"
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(pipelineRun)
+
+	r.reportDeliveries("event", time.Hour, now)
+	if len(provider.comments) != 0 {
+		t.Fatalf("Summary comment was added although the check run failed to be created: %v", provider.comments)
+	}
+	r.reportDeliveries("event", time.Hour, now)
+
+	expectedChecks := []string{"create 1234567890abcdef pipeline completed success 0 tasks succeeded"}
+	if !reflect.DeepEqual(provider.checks, expectedChecks) || len(provider.comments) != 1 {
+		t.Errorf("Check runs reported were %v with comments %v, expected %v and the summary comment", provider.checks, provider.comments, expectedChecks)
+	}
+	records, _ := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).List(metav1.ListOptions{})
+	if len(records.Items) != 0 {
+		t.Errorf("Records left once the final report was retried were %+v", records.Items)
+	}
+}
+
+func signedCheckRunEvent(body, secret string) *http.Request {
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/checkruns", bytes.NewBufferString(body))
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Github-Event", "check_run")
+	httpReq.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	return httpReq
+}
+
+func TestHandleCheckRunEvent(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	os.Setenv("CHECK_RUN_WEBHOOK_SECRET", "app-secret")
+	defer os.Unsetenv("CHECK_RUN_WEBHOOK_SECRET")
+	r := dummyResource()
+	r.TektonClient = fakeclientset.NewSimpleClientset()
+	if _, err := r.createEventListener(runsHook, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	original := webhookPipelineRun("run", "pipeline", "feature", time.Now(), corev1.ConditionFalse, "Failed")
+	original.GenerateName = "pipeline-run-"
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(original)
+
+	rerun := `{"action":"requested_action","requested_action":{"identifier":"rerun"},` +
+		`"check_run":{"id":1,"head_sha":"1234567890abcdef","external_id":"pipelines/run/7"},` +
+		`"repository":{"html_url":"https://github.com/owner/repo"}}`
+	httpWriter := httptest.NewRecorder()
+	r.handleCheckRunEvent(dummyRestfulRequest(signedCheckRunEvent(rerun, "wrong-secret"), ""), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != http.StatusForbidden {
+		t.Errorf("Check run event with the wrong signature returned %d, expected %d", httpWriter.Code, http.StatusForbidden)
+	}
+
+	httpWriter = httptest.NewRecorder()
+	r.handleCheckRunEvent(dummyRestfulRequest(signedCheckRunEvent(rerun, "app-secret"), ""), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != http.StatusCreated {
+		t.Fatalf("Re-run returned %d: %s", httpWriter.Code, httpWriter.Body.String())
+	}
+	pipelineRuns, _ := r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").List(metav1.ListOptions{})
+	var copied *pipelinesv1alpha1.PipelineRun
+	for i := range pipelineRuns.Items {
+		if pipelineRuns.Items[i].GenerateName == "pipeline-run-" && pipelineRuns.Items[i].Name == "" {
+			copied = &pipelineRuns.Items[i]
+		}
+	}
+	if copied == nil {
+		t.Fatalf("No copy of the PipelineRun was created, PipelineRuns were %+v", pipelineRuns.Items)
+	}
+	eventID := copied.Labels[triggersEventIDLabel]
+	if eventID == "" || eventID == original.Labels[triggersEventIDLabel] || copied.Labels["webhooks.tekton.dev/gitRepo"] != "repo" {
+		t.Errorf("Copy of the PipelineRun had labels %v", copied.Labels)
+	}
+	if copied.Status.GetCondition(apis.ConditionSucceeded) != nil || !reflect.DeepEqual(copied.Spec.Params, original.Spec.Params) {
+		t.Errorf("Copy of the PipelineRun was %+v", copied)
+	}
+	records, _ := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).List(metav1.ListOptions{})
+	if len(records.Items) != 1 || records.Items[0].Labels[triggersEventIDLabel] != eventID ||
+		recordParam(&records.Items[0], "url") != "https://github.com/owner/repo/pull/7" ||
+		records.Items[0].Annotations[headCommitAnnotation] != "1234567890abcdef" ||
+		records.Items[0].Spec.SecretParams[0].SecretName != "token" {
+		t.Errorf("Records of the re-run were %+v", records.Items)
+	}
+
+	completed := `{"action":"completed","check_run":{"id":1,"external_id":"pipelines/run/7"}}`
+	httpWriter = httptest.NewRecorder()
+	r.handleCheckRunEvent(dummyRestfulRequest(signedCheckRunEvent(completed, "app-secret"), ""), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != http.StatusNoContent {
+		t.Errorf("Completed check run event returned %d, expected %d", httpWriter.Code, http.StatusNoContent)
+	}
+
+	otherRepo := `{"action":"rerequested","check_run":{"id":1,"external_id":"pipelines/run/7"},"repository":{"html_url":"https://github.com/owner/other"}}`
+	httpWriter = httptest.NewRecorder()
+	r.handleCheckRunEvent(dummyRestfulRequest(signedCheckRunEvent(otherRepo, "app-secret"), ""), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != http.StatusNotFound {
+		t.Errorf("Re-run for another repository returned %d, expected %d", httpWriter.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,515 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+/*--------------------------------------
+Webhook creation is a series of steps, each with a compensating action that undoes
+it. When a step fails the steps done so far are undone in reverse order. Progress is
+recorded in a ConfigMap in the install namespace before and after each step, so that
+a creation interrupted by a restart is rolled back, or resumed if so configured, by
+RecoverWebhookCreations. Steps and their undos can therefore be run again after a
+crash and must tolerate their work being done already.
+---------------------------------------*/
+
+// The steps of a webhook creation
+const (
+	stepSyncCredential       = "synccredential"
+	stepEventListener        = "eventlistener"
+	stepExpose               = "expose"
+	stepWaitForEventListener = "waitforeventlistener"
+	stepGitWebhook           = "gitwebhook"
+)
+
+const (
+	creationStateCreating    = "creating"
+	creationStateRollingBack = "rollingback"
+	// Set on the ConfigMaps recording creations in progress
+	webhookCreationLabel = "webhooks.tekton.dev/creation"
+)
+
+var (
+	// How long the eventlistener is given to become ready before the git provider is asked to ping it
+	eventListenerReadyAttempts = 30
+	eventListenerReadyInterval = 1 * time.Second
+	// A creation whose record has not been written for this long was interrupted. Records
+	// are written before every step, so this must be longer than the slowest step.
+	interruptedCreationAge = 5 * time.Minute
+)
+
+type creationStep struct {
+	name string
+	do   func() error
+	// Undoes do, nil if there is nothing to undo
+	undo func() error
+	// The status the request fails with if do fails
+	failureStatus int
+}
+
+type webhookCreation struct {
+	r       Resource
+	webhook webhook
+	// No other webhook on the repository has a hook on the git provider, so it is created.
+	// Always false for generic webhooks, which have no hook on the git provider.
+	firstOnRepo bool
+	// The eventlistener was created rather than updated, so it needs exposing
+	createdEventListener bool
+	state                string
+	completed            []string
+	inProgress           string
+	// The progress record exists
+	recorded bool
+	updated  time.Time
+
+	gitOwner           string
+	gitRepo            string
+	monitorTriggerName string
+}
+
+// The record of a creation in progress, kept in the ConfigMap's data
+type creationRecord struct {
+	Webhook              webhook   `json:"webhook"`
+	FirstOnRepo          bool      `json:"firstOnRepo"`
+	CreatedEventListener bool      `json:"createdEventListener"`
+	State                string    `json:"state"`
+	Completed            string    `json:"completed"`
+	InProgress           string    `json:"inProgress"`
+	Updated              time.Time `json:"updated"`
+}
+
+func (r Resource) newWebhookCreation(hook webhook, firstOnRepo bool) (*webhookCreation, error) {
+	gitServer, gitOwner, gitRepo, err := getGitValues(hook.GitRepositoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing git repository URL %s: %s", hook.GitRepositoryURL, err)
+	}
+	// Single monitor trigger for all triggers on a repo - thus name to use for monitor is
+	monitorTriggerName := strings.TrimPrefix(gitServer+"/"+gitOwner+"/"+gitRepo, "http://")
+	monitorTriggerName = strings.TrimPrefix(monitorTriggerName, "https://")
+
+	return &webhookCreation{
+		r:                  r,
+		webhook:            hook,
+		firstOnRepo:        firstOnRepo && !isGeneric(hook),
+		state:              creationStateCreating,
+		completed:          []string{},
+		gitOwner:           gitOwner,
+		gitRepo:            gitRepo,
+		monitorTriggerName: monitorTriggerName,
+	}, nil
+}
+
+func (c *webhookCreation) steps() []creationStep {
+	steps := []creationStep{}
+	if c.webhook.SyncCredential {
+		steps = append(steps, creationStep{
+			name:          stepSyncCredential,
+			do:            func() error { return c.r.syncCredential(c.webhook) },
+			undo:          func() error { return c.r.removeSyncedCredential(c.webhook) },
+			failureStatus: http.StatusBadRequest,
+		})
+	}
+	steps = append(steps,
+		creationStep{name: stepEventListener, do: c.addToEventListener, undo: c.removeFromEventListener, failureStatus: http.StatusInternalServerError},
+		creationStep{name: stepExpose, do: c.expose, undo: c.unexpose, failureStatus: http.StatusInternalServerError},
+	)
+	if c.firstOnRepo {
+		steps = append(steps,
+			creationStep{name: stepWaitForEventListener, do: c.waitForEventListener, failureStatus: http.StatusInternalServerError},
+			creationStep{
+				name:          stepGitWebhook,
+				do:            func() error { return c.r.AddWebhook(c.webhook, c.gitOwner, c.gitRepo) },
+				undo:          func() error { return c.r.RemoveWebhook(c.webhook, c.gitOwner, c.gitRepo) },
+				failureStatus: http.StatusInternalServerError,
+			},
+		)
+	} else {
+		logging.Log.Debugf("webhook already exists for repository %s - not creating new hook in GitHub", c.webhook.GitRepositoryURL)
+	}
+	return steps
+}
+
+/*
+	Runs the steps not yet completed. If one fails the creation is rolled back and the
+	status to respond with is returned along with the error.
+*/
+func (c *webhookCreation) run() (int, error) {
+	for _, step := range c.steps() {
+		if c.isCompleted(step.name) {
+			continue
+		}
+		c.inProgress = step.name
+		if err := c.save(); err != nil {
+			// The step was not started
+			c.inProgress = ""
+			if k8serrors.IsAlreadyExists(err) {
+				return http.StatusConflict, fmt.Errorf("webhook %s is already being created, or its interrupted creation has not been recovered yet", c.webhook.Name)
+			}
+			err = fmt.Errorf("error recording the progress of creating webhook %s: %s", c.webhook.Name, err)
+			return http.StatusInternalServerError, c.rollbackAfter(err)
+		}
+		logging.Log.Debugf("Creating webhook %s: %s", c.webhook.Name, step.name)
+		if err := step.do(); err != nil {
+			// The failed step is undone along with the others, as it may have done part of its
+			// work, such as copying a credential it then failed to attach. The hook on the git
+			// provider is the exception: its undo could remove a hook that was there before and
+			// made AddWebhook fail.
+			if step.name == stepGitWebhook {
+				c.inProgress = ""
+			}
+			err = fmt.Errorf("error creating webhook %s at step %s: %s", c.webhook.Name, step.name, err)
+			return step.failureStatus, c.rollbackAfter(err)
+		}
+		c.completed = append(c.completed, step.name)
+		c.inProgress = ""
+	}
+	if err := c.finish(); err != nil {
+		// Recovery finishes creations with every step completed rather than rolling them back
+		logging.Log.Errorf("error removing the progress record of webhook %s: %s", c.webhook.Name, err)
+	}
+	return http.StatusCreated, nil
+}
+
+func (c *webhookCreation) rollbackAfter(err error) error {
+	logging.Log.Errorf("%s, rolling back", err)
+	if rollbackErr := c.rollback(); rollbackErr != nil {
+		return fmt.Errorf("%s. Also failed to roll back: %s", err, rollbackErr)
+	}
+	return err
+}
+
+/*
+	Undoes the completed steps in reverse order, along with the step in progress when
+	it failed or a restart interrupted it. If undos fail the others are still made, and the record
+	is kept with the steps that failed to undo, to be retried on restart.
+*/
+func (c *webhookCreation) rollback() error {
+	c.state = creationStateRollingBack
+	toUndo := append([]string{}, c.completed...)
+	if c.inProgress != "" {
+		toUndo = append(toUndo, c.inProgress)
+	}
+	steps := map[string]creationStep{}
+	for _, step := range c.steps() {
+		steps[step.name] = step
+	}
+
+	failed := []string{}
+	errs := []string{}
+	for i := len(toUndo) - 1; i >= 0; i-- {
+		step, found := steps[toUndo[i]]
+		if !found || step.undo == nil {
+			continue
+		}
+		if err := step.undo(); err != nil {
+			logging.Log.Errorf("error rolling back creating webhook %s at step %s: %s", c.webhook.Name, step.name, err)
+			failed = append([]string{step.name}, failed...)
+			errs = append(errs, fmt.Sprintf("error undoing step %s: %s", step.name, err))
+			continue
+		}
+		logging.Log.Debugf("Rolled back creating webhook %s: %s", c.webhook.Name, step.name)
+	}
+	c.completed = failed
+	c.inProgress = ""
+	if len(failed) > 0 {
+		if saveErr := c.save(); saveErr != nil {
+			logging.Log.Errorf("error recording the progress of rolling back webhook %s: %s", c.webhook.Name, saveErr)
+		}
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return c.finish()
+}
+
+func (c *webhookCreation) isCompleted(name string) bool {
+	for _, completed := range c.completed {
+		if completed == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *webhookCreation) allCompleted() bool {
+	for _, step := range c.steps() {
+		if !c.isCompleted(step.name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds the webhook's triggers to the eventlistener, creating it if there is none
+func (c *webhookCreation) addToEventListener() error {
+	installNS := c.r.Defaults.Namespace
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		eventListener, err := c.r.getEventListener(installNS)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil && eventListenerBeingDeleted(eventListener) {
+			logging.Log.Info("Existing eventlistener is being deleted, replacing it...")
+			// A conflict means another replica replaced it first, so the new one is read on retry
+			if err := c.r.deleteEventListener(eventListener); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+			eventListener = nil
+		}
+		if eventListener == nil || eventListener.GetName() == "" {
+			logging.Log.Info("No existing eventlistener found, creating a new one...")
+			_, err := c.r.createEventListener(c.webhook, installNS, c.monitorTriggerName)
+			if k8serrors.IsAlreadyExists(err) {
+				// Created by another replica meanwhile, so add to that one
+				return k8serrors.NewConflict(eventListenerResource.GroupResource(), eventListenerName, err)
+			}
+			if err != nil {
+				return err
+			}
+			c.createdEventListener = true
+			return nil
+		}
+
+		pushTriggerName := c.webhook.Name + "-" + c.webhook.Namespace + "-push-event"
+		for _, trigger := range eventListener.Spec.Triggers {
+			if trigger.Name == pushTriggerName {
+				// Added before a restart. If the eventlistener holds only the webhook's push, pull
+				// request and monitor triggers it was created for this webhook too.
+				c.createdEventListener = c.createdEventListener || len(eventListener.Spec.Triggers) <= 3
+				return nil
+			}
+		}
+		_, err = c.r.updateEventListener(eventListener, c.webhook, c.monitorTriggerName)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error adding to eventlistener: %s", err)
+	}
+	return nil
+}
+
+func (c *webhookCreation) removeFromEventListener() error {
+	_, err := c.r.removeFromEventListener(c.webhook.Name+"-"+c.webhook.Namespace, c.r.Defaults.Namespace, c.monitorTriggerName, c.webhook.GitRepositoryURL)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Exposes the eventlistener if this creation created it
+func (c *webhookCreation) expose() error {
+	if !c.createdEventListener {
+		return nil
+	}
+	exposer, err := c.r.eventListenerExposer()
+	if err != nil {
+		return err
+	}
+	if err := exposer.expose(c.r.Defaults.Namespace); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating %s: %s", exposer.kind(), err)
+	}
+	logging.Log.Debugf("%s creation succeeded", exposer.kind())
+	return nil
+}
+
+func (c *webhookCreation) unexpose() error {
+	if !c.createdEventListener {
+		return nil
+	}
+	exposer, err := c.r.eventListenerExposer()
+	if err != nil {
+		return err
+	}
+	if err := exposer.unexpose(c.r.Defaults.Namespace); err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting %s: %s", exposer.kind(), err)
+	}
+	return nil
+}
+
+// Gives the eventlistener a chance to be up and running or the webhook ping will get a
+// 503 and might confuse people (although resend will work)
+func (c *webhookCreation) waitForEventListener() error {
+	for i := 0; i < eventListenerReadyAttempts; i++ {
+		deployment, err := c.r.K8sClient.AppsV1().Deployments(c.r.Defaults.Namespace).Get(routeName, metav1.GetOptions{})
+		if err == nil && deployment.Status.ReadyReplicas > 0 {
+			return nil
+		}
+		time.Sleep(eventListenerReadyInterval)
+	}
+	logging.Log.Infof("eventlistener deployment %s is not ready yet, creating the webhook anyway", routeName)
+	return nil
+}
+
+func (c *webhookCreation) recordName() string {
+	return "webhook-creation-" + c.webhook.Name + "-" + c.webhook.Namespace
+}
+
+func (c *webhookCreation) recordData() (map[string]string, error) {
+	c.updated = time.Now()
+	record, err := json.Marshal(creationRecord{
+		Webhook:              c.webhook,
+		FirstOnRepo:          c.firstOnRepo,
+		CreatedEventListener: c.createdEventListener,
+		State:                c.state,
+		Completed:            strings.Join(c.completed, ","),
+		InProgress:           c.inProgress,
+		Updated:              c.updated,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"creation": string(record)}, nil
+}
+
+// Writes the progress record, creating it on the first save
+func (c *webhookCreation) save() error {
+	data, err := c.recordData()
+	if err != nil {
+		return err
+	}
+	configMaps := c.r.K8sClient.CoreV1().ConfigMaps(c.r.Defaults.Namespace)
+	if !c.recorded {
+		// Fails if the webhook is already being created, by this replica or another
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.recordName(),
+				Namespace: c.r.Defaults.Namespace,
+				Labels:    map[string]string{webhookCreationLabel: "true"},
+			},
+			Data: data,
+		}
+		if _, err := configMaps.Create(configMap); err != nil {
+			return err
+		}
+		c.recorded = true
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(c.recordName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		configMap.Data = data
+		_, err = configMaps.Update(configMap)
+		return err
+	})
+}
+
+// Removes the progress record
+func (c *webhookCreation) finish() error {
+	err := c.r.K8sClient.CoreV1().ConfigMaps(c.r.Defaults.Namespace).Delete(c.recordName(), &metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (r Resource) webhookCreationFromRecord(configMap corev1.ConfigMap) (*webhookCreation, error) {
+	record := creationRecord{}
+	if err := json.Unmarshal([]byte(configMap.Data["creation"]), &record); err != nil {
+		return nil, fmt.Errorf("error reading webhook creation record %s: %s", configMap.Name, err)
+	}
+	c, err := r.newWebhookCreation(record.Webhook, record.FirstOnRepo)
+	if err != nil {
+		return nil, err
+	}
+	c.createdEventListener = record.CreatedEventListener
+	c.state = record.State
+	if record.Completed != "" {
+		c.completed = strings.Split(record.Completed, ",")
+	}
+	c.inProgress = record.InProgress
+	c.recorded = true
+	c.updated = record.Updated
+	return c, nil
+}
+
+/*
+	Claims an interrupted creation by writing its record as read. The write fails
+	with a conflict if another replica wrote the record first.
+*/
+func (c *webhookCreation) claim(configMap corev1.ConfigMap) error {
+	data, err := c.recordData()
+	if err != nil {
+		return err
+	}
+	configMap.Data = data
+	_, err = c.r.K8sClient.CoreV1().ConfigMaps(c.r.Defaults.Namespace).Update(&configMap)
+	return err
+}
+
+// RecoverWebhookCreations finishes interrupted webhook creations every interval
+func (r Resource) RecoverWebhookCreations(resume bool, interval time.Duration) {
+	for {
+		r.recoverWebhookCreations(resume)
+		time.Sleep(interval)
+	}
+}
+
+/*
+	Finishes the webhook creations interrupted by a restart: creations that had
+	completed every step are kept, others are rolled back or, if resume is true and
+	they were not already rolling back, resumed. Creations written recently may still
+	be running in this or another replica and are left alone.
+*/
+func (r Resource) recoverWebhookCreations(resume bool) {
+	records, err := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace).List(metav1.ListOptions{LabelSelector: webhookCreationLabel})
+	if err != nil {
+		logging.Log.Errorf("error listing interrupted webhook creations: %s", err)
+		return
+	}
+	for _, record := range records.Items {
+		c, err := r.webhookCreationFromRecord(record)
+		if err != nil {
+			logging.Log.Errorf("%s", err)
+			continue
+		}
+		if time.Since(c.updated) < interruptedCreationAge {
+			continue
+		}
+		if err := c.claim(record); err != nil {
+			if k8serrors.IsConflict(err) {
+				logging.Log.Debugf("Creation of webhook %s is being recovered by another replica", c.webhook.Name)
+			} else {
+				logging.Log.Errorf("error claiming the interrupted creation of webhook %s: %s", c.webhook.Name, err)
+			}
+			continue
+		}
+		switch {
+		case c.state == creationStateCreating && c.allCompleted():
+			logging.Log.Infof("Creation of webhook %s had completed", c.webhook.Name)
+			err = c.finish()
+		case c.state == creationStateCreating && resume:
+			logging.Log.Infof("Resuming the interrupted creation of webhook %s", c.webhook.Name)
+			_, err = c.run()
+		default:
+			logging.Log.Infof("Rolling back the interrupted creation of webhook %s", c.webhook.Name)
+			err = c.rollback()
+		}
+		if err != nil {
+			logging.Log.Errorf("error recovering the creation of webhook %s: %s", c.webhook.Name, err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	faketriggerclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned/fake"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var creationHook = webhook{
+	Name:             "creation",
+	Namespace:        "pipelines",
+	GitRepositoryURL: "https://github.com/owner/repo",
+	AccessTokenRef:   "token",
+	Pipeline:         "pipeline",
+}
+
+// A creation of a webhook on a repository that already has one, so that GitHub is not called
+func dummyCreation(t *testing.T) (*Resource, *webhookCreation) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	c, err := r.newWebhookCreation(creationHook, false)
+	if err != nil {
+		t.Fatalf("Error starting webhook creation: %s", err)
+	}
+	return r, c
+}
+
+func creationRecorded(r *Resource, c *webhookCreation) bool {
+	_, err := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace).Get(c.recordName(), metav1.GetOptions{})
+	return err == nil
+}
+
+func TestWebhookCreation(t *testing.T) {
+	r, c := dummyCreation(t)
+	if status, err := c.run(); err != nil || status != http.StatusCreated {
+		t.Fatalf("Creation returned %d and error %s, expected %d", status, err, http.StatusCreated)
+	}
+	if _, err := r.getEventListener(r.Defaults.Namespace); err != nil {
+		t.Errorf("Eventlistener was not created: %s", err)
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(r.Defaults.Namespace).Get(ingressName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Ingress was not created: %s", err)
+	}
+	if creationRecorded(r, c) {
+		t.Error("Progress record was kept after the creation completed")
+	}
+}
+
+func TestWebhookCreationRollsBack(t *testing.T) {
+	r, c := dummyCreation(t)
+	k8sClient := fakek8sclientset.NewSimpleClientset()
+	k8sClient.PrependReactor("create", "ingresses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("ingress refused")
+	})
+	r.K8sClient = k8sClient
+	c.r = *r
+
+	status, err := c.run()
+	if err == nil || status != http.StatusInternalServerError {
+		t.Fatalf("Creation returned %d and error %v, expected %d and an error", status, err, http.StatusInternalServerError)
+	}
+	if _, err := r.getEventListener(r.Defaults.Namespace); !k8serrors.IsNotFound(err) {
+		t.Errorf("Eventlistener was not deleted on rollback, error was %v", err)
+	}
+	if creationRecorded(r, c) {
+		t.Error("Progress record was kept after the creation was rolled back")
+	}
+	// Undoing the failed step finds no ingress to delete, which is not a rollback failure
+	if strings.Contains(err.Error(), "failed to roll back") {
+		t.Errorf("Rolling back the failed step failed: %s", err)
+	}
+}
+
+func TestFailedCredentialSyncIsUndone(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummySyncResource(t)
+	hook := creationHook
+	hook.AccessTokenRef = "git-credential"
+	hook.SyncCredential = true
+	hook.ServiceAccount = "missing-sa"
+	c, err := r.newWebhookCreation(hook, false)
+	if err != nil {
+		t.Fatalf("Error starting webhook creation: %s", err)
+	}
+
+	if status, err := c.run(); err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Creation returned %d and error %v, expected %d and an error", status, err, http.StatusBadRequest)
+	}
+	// The credential was copied before attaching it to the missing service account failed
+	if _, err := r.K8sClient.CoreV1().Secrets(hook.Namespace).Get("git-credential", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Copy of the credential was left in namespace %s, error was %v", hook.Namespace, err)
+	}
+	if _, err := r.K8sClient.RbacV1().RoleBindings(hook.Namespace).Get(credentialSyncRole, metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Credential sync was left granted in namespace %s, error was %v", hook.Namespace, err)
+	}
+	if creationRecorded(r, c) {
+		t.Error("Progress record was kept after the creation was rolled back")
+	}
+}
+
+func TestRollbackContinuesAfterAFailedUndo(t *testing.T) {
+	r, c := dummyCreation(t)
+	if err := c.addToEventListener(); err != nil {
+		t.Fatalf("Error adding to eventlistener: %s", err)
+	}
+	k8sClient := fakek8sclientset.NewSimpleClientset()
+	k8sClient.PrependReactor("delete", "ingresses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("ingress deletion refused")
+	})
+	r.K8sClient = k8sClient
+	c.r = *r
+	c.completed = []string{stepEventListener, stepExpose}
+
+	if err := c.rollback(); err == nil {
+		t.Fatal("Expected an error rolling back with an undo that fails")
+	}
+	if _, err := r.getEventListener(r.Defaults.Namespace); !k8serrors.IsNotFound(err) {
+		t.Errorf("Eventlistener was not deleted after the ingress failed to be deleted, error was %v", err)
+	}
+	if !creationRecorded(r, c) || len(c.completed) != 1 || c.completed[0] != stepExpose {
+		t.Errorf("Steps left to undo were %v, expected only %s to be recorded", c.completed, stepExpose)
+	}
+}
+
+func TestAddToEventListenerIsIdempotent(t *testing.T) {
+	r, c := dummyCreation(t)
+	for i := 0; i < 2; i++ {
+		if err := c.addToEventListener(); err != nil {
+			t.Fatalf("Error adding to eventlistener: %s", err)
+		}
+	}
+	el, _ := r.getEventListener(r.Defaults.Namespace)
+	if len(el.Spec.Triggers) != 3 {
+		t.Errorf("Eventlistener had %d triggers, expected 3", len(el.Spec.Triggers))
+	}
+
+	// A restart forgets that the eventlistener was created for this webhook
+	c.createdEventListener = false
+	if err := c.addToEventListener(); err != nil {
+		t.Fatalf("Error adding to eventlistener: %s", err)
+	}
+	if !c.createdEventListener {
+		t.Error("Eventlistener holding only the webhook's triggers was not recognised as created for it")
+	}
+}
+
+func TestRecoverWebhookCreations(t *testing.T) {
+	defer func(age time.Duration) { interruptedCreationAge = age }(interruptedCreationAge)
+	for _, resume := range []bool{false, true} {
+		r, c := dummyCreation(t)
+		// Interrupted after adding to the eventlistener and before exposing it
+		if err := c.addToEventListener(); err != nil {
+			t.Fatalf("Error adding to eventlistener: %s", err)
+		}
+		c.completed = []string{stepEventListener}
+		c.inProgress = stepExpose
+		if err := c.save(); err != nil {
+			t.Fatalf("Error recording progress: %s", err)
+		}
+
+		// Left alone while it might still be running
+		r.recoverWebhookCreations(resume)
+		if !creationRecorded(r, c) {
+			t.Fatal("Recent creation was recovered")
+		}
+		interruptedCreationAge = 0
+		r.recoverWebhookCreations(resume)
+
+		_, elErr := r.getEventListener(r.Defaults.Namespace)
+		_, ingressErr := r.K8sClient.ExtensionsV1beta1().Ingresses(r.Defaults.Namespace).Get(ingressName, metav1.GetOptions{})
+		if resume && (elErr != nil || ingressErr != nil) {
+			t.Errorf("Resumed creation left eventlistener error %v and ingress error %v", elErr, ingressErr)
+		}
+		if !resume && (!k8serrors.IsNotFound(elErr) || !k8serrors.IsNotFound(ingressErr)) {
+			t.Errorf("Rolled back creation left eventlistener error %v and ingress error %v", elErr, ingressErr)
+		}
+		if creationRecorded(r, c) {
+			t.Errorf("Progress record was kept after recovery with resume %t", resume)
+		}
+		interruptedCreationAge = 5 * time.Minute
+	}
+}
+
+func TestAddToEventListenerRetriesConflicts(t *testing.T) {
+	r, c := dummyCreation(t)
+	other := creationHook
+	other.Name = "other"
+	if _, err := r.createEventListener(other, r.Defaults.Namespace, c.monitorTriggerName); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	triggersClient := r.TriggersClient.(*faketriggerclientset.Clientset)
+	conflicts := 0
+	triggersClient.PrependReactor("update", "eventlisteners", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		return true, nil, k8serrors.NewConflict(eventListenerResource.GroupResource(), eventListenerName, errors.New("changed by another replica"))
+	})
+
+	if err := c.addToEventListener(); err != nil {
+		t.Fatalf("Error adding to eventlistener: %s", err)
+	}
+	el, _ := r.getEventListener(r.Defaults.Namespace)
+	if conflicts != 1 || len(el.Spec.Triggers) != 5 {
+		t.Errorf("Eventlistener had %d triggers after %d conflicts, expected 5 after 1", len(el.Spec.Triggers), conflicts)
+	}
+}
+
+func TestAddToEventListenerReplacesOneBeingDeleted(t *testing.T) {
+	r, c := dummyCreation(t)
+	other := creationHook
+	other.Name = "other"
+	el, err := r.createEventListener(other, r.Defaults.Namespace, c.monitorTriggerName)
+	if err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	el.Annotations = map[string]string{eventListenerDeletingAnnotation: "true"}
+	if _, err := r.updateEventListenerResource(el); err != nil {
+		t.Fatalf("Error marking eventlistener as being deleted: %s", err)
+	}
+
+	if err := c.addToEventListener(); err != nil {
+		t.Fatalf("Error adding to eventlistener: %s", err)
+	}
+	el, _ = r.getEventListener(r.Defaults.Namespace)
+	if eventListenerBeingDeleted(el) || len(el.Spec.Triggers) != 3 || !c.createdEventListener {
+		t.Errorf("Eventlistener being deleted was not replaced, it has %d triggers and annotations %v", len(el.Spec.Triggers), el.Annotations)
+	}
+}
+
+func TestEventListenerReplacedWhileBeingDeletedIsKept(t *testing.T) {
+	r, c := dummyCreation(t)
+	triggersClient := r.TriggersClient.(*faketriggerclientset.Clientset)
+	tracker := triggersClient.Tracker()
+	// A second replica, with its own client onto the same eventlisteners
+	otherClient := faketriggerclientset.NewSimpleClientset()
+	otherClient.PrependReactor("*", "*", k8stesting.ObjectReaction(tracker))
+	otherReplica := *r
+	otherReplica.TriggersClient = otherClient
+	other := creationHook
+	other.Name = "other"
+	otherCreation, _ := otherReplica.newWebhookCreation(other, false)
+
+	// The fake clients assign no UIDs, which the API server would on create
+	uids := 0
+	assignUID := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		uids++
+		action.(k8stesting.CreateAction).GetObject().(*v1alpha1.EventListener).UID = types.UID(strconv.Itoa(uids))
+		return false, nil, nil
+	}
+	triggersClient.PrependReactor("create", "eventlisteners", assignUID)
+	otherClient.PrependReactor("create", "eventlisteners", assignUID)
+	if err := c.addToEventListener(); err != nil {
+		t.Fatalf("Error adding to eventlistener: %s", err)
+	}
+
+	// The other replica replaces the eventlistener between this one marking it and deleting it.
+	// The fake clients drop delete options, so the UID precondition is checked here.
+	triggersClient.PrependReactor("delete", "eventlisteners", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if uids == 1 {
+			if err := otherCreation.addToEventListener(); err != nil {
+				t.Errorf("Error adding to eventlistener from the other replica: %s", err)
+			}
+		}
+		current, err := tracker.Get(eventListenerResource, action.GetNamespace(), eventListenerName)
+		if err == nil && current.(*v1alpha1.EventListener).UID != "1" {
+			return true, nil, k8serrors.NewConflict(eventListenerResource.GroupResource(), eventListenerName, errors.New("precondition failed"))
+		}
+		return false, nil, nil
+	})
+
+	deleted, err := r.removeFromEventListener(creationHook.Name+"-"+creationHook.Namespace, r.Defaults.Namespace, c.monitorTriggerName, creationHook.GitRepositoryURL)
+	if err != nil || deleted {
+		t.Fatalf("Removing the webhook returned deleted %t and error %v, expected the replaced eventlistener to be kept", deleted, err)
+	}
+	el, err := r.getEventListener(r.Defaults.Namespace)
+	if err != nil {
+		t.Fatalf("Eventlistener created by the other replica was deleted: %s", err)
+	}
+	found := false
+	for _, trigger := range el.Spec.Triggers {
+		found = found || trigger.Name == "other-pipelines-push-event"
+	}
+	if eventListenerBeingDeleted(el) || !found {
+		t.Errorf("Eventlistener lost the other replica's webhook, it has triggers %v and annotations %v", el.Spec.Triggers, el.Annotations)
+	}
+}
+
+func TestConcurrentCreationOfAWebhookConflicts(t *testing.T) {
+	r, c := dummyCreation(t)
+	if err := c.save(); err != nil {
+		t.Fatalf("Error recording progress: %s", err)
+	}
+	duplicate, _ := r.newWebhookCreation(creationHook, false)
+	if status, err := duplicate.run(); err == nil || status != http.StatusConflict {
+		t.Errorf("Second creation returned %d and error %v, expected %d", status, err, http.StatusConflict)
+	}
+	if !creationRecorded(r, c) {
+		t.Error("Second creation removed the first creation's progress record")
+	}
+}
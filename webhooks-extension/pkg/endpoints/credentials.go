@@ -14,34 +14,74 @@ limitations under the License.
 package endpoints
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"math"
 	"net/http"
-	"time"
+	"strings"
 
 	restful "github.com/emicklei/go-restful"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/credentialstore"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	"github.com/tektoncd/experimental/webhooks-extension/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 )
 
-// 'credentials' from the webhooks-extension's point of view, are access tokens. That's the only sort we handle right now.
+// 'credentials' from the webhooks-extension's point of view, are access tokens used to register webhooks.
+// A credential can also hold an SSH key or a username and password, stored as a typed secret with a
+// tekton.dev/git-0 annotation so that pipelines can clone with the same credential.
 type credential struct {
 	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
 	AccessToken string `json:"accesstoken"`
 	SecretToken string `json:"secrettoken,omitempty"`
+	// The git server the credential is for, used in the tekton.dev/git-0 annotation
+	GitServer     string `json:"gitserver,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	SSHPrivateKey string `json:"sshprivatekey,omitempty"`
+	KnownHosts    string `json:"knownhosts,omitempty"`
+}
+
+// The types of credential, an access token credential has no type
+const (
+	credentialTypeSSH       = "ssh"
+	credentialTypeBasicAuth = "basicauth"
+
+	gitCredentialAnnotation = "tekton.dev/git-0"
+	defaultGitServer        = "github.com"
+	maskedValue             = "********"
+
+	// The git server of an access token credential, only used by the extension
+	gitServerAnnotation = "webhooks.tekton.dev/gitServer"
+	// Set on the secrets created for credentials, only these are listed or deleted
+	credentialLabel = credentialstore.CredentialLabel
+)
+
+// A credential along with the webhooks that use it
+type credentialDetails struct {
+	credential
+	Webhooks []webhook `json:"webhooks"`
 }
 
 /*--------------------------------------
-This file implements three endpoints from webhooks.go:
+This file implements four endpoints from webhooks.go, verification.go implements a fifth:
 	ws.Route(ws.POST("/credentials").To(r.createCredential))
 	ws.Route(ws.GET("/credentials").To(r.getAllCredentials))
+	ws.Route(ws.GET("/credentials/{name}").To(r.getCredential))
 	ws.Route(ws.DELETE("/credentials/{name}").To(r.deleteCredential))
 ---------------------------------------*/
 
 func (r Resource) createCredential(request *restful.Request, response *restful.Response) {
 	logging.Log.Debug("In createCredential")
+	if !r.verifyCredentialsInSecrets(response) {
+		return
+	}
 	cred := credential{}
 
 	if err := getQueryEntity(&cred, request, response); err != nil {
@@ -54,6 +94,27 @@ func (r Resource) createCredential(request *restful.Request, response *restful.R
 		return
 	}
 
+	var verification credentialVerification
+	if r.Defaults.VerifyCredentials {
+		var status int
+		var err error
+		verification, status, err = verifyAccessToken(cred)
+		if err != nil {
+			utils.RespondMessageAndLogError(response, err, err.Error(), status)
+			return
+		}
+	}
+
+	if cred.SecretToken == "" {
+		secretToken, err := generateSecretToken(r.Defaults.SecretTokenLength, r.Defaults.SecretTokenEncoding)
+		if err != nil {
+			errorMessage := fmt.Sprintf("error generating secret token: %s", err.Error())
+			utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+			return
+		}
+		cred.SecretToken = string(secretToken)
+	}
+
 	secret := r.credentialToSecret(cred, response)
 
 	logging.Log.Debugf("Creating credential %s in namespace %s", cred.Name, r.Defaults.Namespace)
@@ -63,16 +124,46 @@ func (r Resource) createCredential(request *restful.Request, response *restful.R
 		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusBadRequest)
 		return
 	}
+	// A credential deleted and created again with new tokens replaces any copies synchronized into pipeline namespaces
+	if err := r.resyncCredentials(cred.Name); err != nil {
+		logging.Log.Errorf("error synchronizing copies of credential %s: %s", cred.Name, err)
+	}
+	if r.Defaults.VerifyCredentials {
+		// Let the caller see who the token belongs to and what it can do
+		response.AddHeader("Content-Location", request.Request.URL.Path+"/"+cred.Name)
+		response.WriteHeaderAndEntity(http.StatusCreated, verification)
+		return
+	}
 	writeResponseLocation(request, response, cred.Name)
 }
 
 func (r Resource) deleteCredential(request *restful.Request, response *restful.Response) {
 	credName := request.PathParameter("name")
-	if !r.verifySecretExists(credName, response) {
+	if !r.verifyCredentialsInSecrets(response) {
+		return
+	}
+	if _, found := r.getCredentialSecret(credName, response); !found {
+		return
+	}
+
+	webhooks, err := r.getWebhooksUsingCredential(credName)
+	if err != nil {
+		errorMessage := fmt.Sprintf("error getting the webhooks using credential %s: %s.", credName, err.Error())
+		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
 		return
 	}
+	if len(webhooks) > 0 && request.QueryParameter("force") != "true" {
+		names := []string{}
+		for _, hook := range webhooks {
+			names = append(names, hook.Name)
+		}
+		errorMessage := fmt.Sprintf("error: credential %s is used by the webhooks %s, add ?force=true to delete it anyway", credName, strings.Join(names, ", "))
+		utils.RespondErrorMessage(response, errorMessage, http.StatusConflict)
+		return
+	}
+
 	logging.Log.Debugf("Deleting credential %s", credName)
-	err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Delete(credName, &metav1.DeleteOptions{})
+	err = r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Delete(credName, &metav1.DeleteOptions{})
 	if err != nil {
 		errorMessage := fmt.Sprintf("error deleting secret from K8sClient: %s.", err.Error())
 		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
@@ -82,8 +173,20 @@ func (r Resource) deleteCredential(request *restful.Request, response *restful.R
 }
 
 func (r Resource) getAllCredentials(request *restful.Request, response *restful.Response) {
+	if r.CredentialStore.Backend() != credentialstore.Kubernetes {
+		creds, err := r.getStoredCredentials()
+		if err != nil {
+			errorMessage := fmt.Sprintf("error listing credentials in the %s credential store: %s.", r.CredentialStore.Backend(), err.Error())
+			utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+			return
+		}
+		response.AddHeader("Content-Type", "application/json")
+		response.WriteEntity(creds)
+		return
+	}
+
 	// Get secrets from the resource K8sClient
-	secrets, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).List(metav1.ListOptions{})
+	secrets, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).List(metav1.ListOptions{LabelSelector: credentialLabel + "=true"})
 
 	if err != nil {
 		errorMessage := fmt.Sprintf("error getting secrets from K8sClient: %s.", err.Error())
@@ -110,17 +213,159 @@ func (r Resource) getAllCredentials(request *restful.Request, response *restful.
 	response.WriteEntity(creds)
 }
 
-// Sends error message 404 if the secret does not exist in the resource K8sClient
-func (r Resource) verifySecretExists(secretName string, response *restful.Response) bool {
-	_, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(secretName, metav1.GetOptions{})
+func (r Resource) getCredential(request *restful.Request, response *restful.Response) {
+	credName := request.PathParameter("name")
+	cred, found := r.getCredentialByName(credName, true, response)
+	if !found {
+		return
+	}
+
+	webhooks, err := r.getWebhooksUsingCredential(credName)
+	if err != nil {
+		errorMessage := fmt.Sprintf("error getting the webhooks using credential %s: %s.", credName, err.Error())
+		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+		return
+	}
+
+	response.AddHeader("Content-Type", "application/json")
+	response.WriteEntity(credentialDetails{credential: cred, Webhooks: webhooks})
+}
+
+// Returns the named credential from the configured credential store, with its tokens masked if mask is
+// true. Sends error message 404 if there is no such credential.
+func (r Resource) getCredentialByName(credName string, mask bool, response *restful.Response) (credential, bool) {
+	if r.CredentialStore.Backend() != credentialstore.Kubernetes {
+		cred, err := r.getStoredCredential(credName, mask)
+		if err != nil {
+			errorMessage := fmt.Sprintf("error getting credential %s from the %s credential store.", credName, r.CredentialStore.Backend())
+			utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusNotFound)
+			return credential{}, false
+		}
+		return cred, true
+	}
+	secret, found := r.getCredentialSecret(credName, response)
+	if !found {
+		return credential{}, false
+	}
+	return secretToCredential(secret, mask), true
+}
+
+// Returns a credential from a store other than Kubernetes Secrets, which only holds its tokens
+func (r Resource) getStoredCredential(credName string, mask bool) (credential, error) {
+	accessToken, secretToken, err := r.CredentialStore.GetTokens(credName)
+	if err != nil {
+		return credential{}, err
+	}
+	cred := credential{Name: credName, AccessToken: accessToken, SecretToken: secretToken}
+	if mask {
+		cred.AccessToken = maskIfSet(cred.AccessToken)
+		cred.SecretToken = maskedValue
+	}
+	return cred, nil
+}
+
+// Returns the credentials in a store other than Kubernetes Secrets, with their tokens masked
+func (r Resource) getStoredCredentials() ([]credential, error) {
+	names, err := r.CredentialStore.List()
+	if err != nil {
+		return nil, err
+	}
+	creds := []credential{}
+	for _, name := range names {
+		cred, err := r.getStoredCredential(name, true)
+		if err != nil {
+			logging.Log.Errorf("error reading credential %s from the %s credential store: %s", name, r.CredentialStore.Backend(), err)
+			continue
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+// Returns the secret for a credential created by the extension. Sends error message 404
+// if the secret does not exist in the resource K8sClient, or was not created for a credential.
+func (r Resource) getCredentialSecret(secretName string, response *restful.Response) (*corev1.Secret, bool) {
+	secret, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(secretName, metav1.GetOptions{})
+	if err == nil && secret.GetLabels()[credentialLabel] != "true" {
+		err = fmt.Errorf("secret %s is not labelled %s=true", secretName, credentialLabel)
+	}
 	if err != nil {
 		errorMessage := fmt.Sprintf("error getting secret from K8sClient: '%s'.", secretName)
 		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusNotFound)
+		return nil, false
+	}
+	return secret, true
+}
+
+// LabelExistingCredentials labels the secrets of credentials created before credentials were
+// labelled, so that they are listed and can be deleted. They are found as the secrets with an
+// access token that webhooks use.
+func (r Resource) LabelExistingCredentials() {
+	if r.CredentialStore.Backend() != credentialstore.Kubernetes {
+		return
+	}
+	webhooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		logging.Log.Errorf("error getting the webhooks to label their credentials: %s", err)
+		return
+	}
+	secrets := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace)
+	seen := map[string]bool{}
+	for _, hook := range webhooks {
+		if hook.AccessTokenRef == "" || seen[hook.AccessTokenRef] {
+			continue
+		}
+		seen[hook.AccessTokenRef] = true
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			secret, err := secrets.Get(hook.AccessTokenRef, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if secret.GetLabels()[credentialLabel] == "true" || secret.Data["accessToken"] == nil {
+				return nil
+			}
+			if secret.Labels == nil {
+				secret.Labels = map[string]string{}
+			}
+			secret.Labels[credentialLabel] = "true"
+			if _, err := secrets.Update(secret); err != nil {
+				return err
+			}
+			logging.Log.Infof("Labelled secret %s used by webhook %s as a credential", secret.Name, hook.Name)
+			return nil
+		})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logging.Log.Errorf("error labelling credential %s: %s", hook.AccessTokenRef, err)
+		}
+	}
+}
+
+// Sends error message 400 if credentials are read from a store other than Kubernetes Secrets,
+// in which case they are created and deleted in that store rather than through the extension
+func (r Resource) verifyCredentialsInSecrets(response *restful.Response) bool {
+	if backend := r.CredentialStore.Backend(); backend != credentialstore.Kubernetes {
+		errorMessage := fmt.Sprintf("error: credentials are read from the %s credential store and must be managed there", backend)
+		utils.RespondErrorMessage(response, errorMessage, http.StatusBadRequest)
 		return false
 	}
 	return true
 }
 
+// Returns the webhooks that register with, or validate deliveries using, the credential
+func (r Resource) getWebhooksUsingCredential(credName string) ([]webhook, error) {
+	allHooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		return nil, err
+	}
+	hooks := []webhook{}
+	for _, hook := range allHooks {
+		if hook.AccessTokenRef == credName {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
 // Convert credential struct into K8s secret struct
 func (r Resource) credentialToSecret(cred credential, response *restful.Response) *corev1.Secret {
 	// Create new secret struct
@@ -128,57 +373,169 @@ func (r Resource) credentialToSecret(cred credential, response *restful.Response
 	secret.Type = corev1.SecretTypeOpaque
 	secret.SetNamespace(r.Defaults.Namespace)
 	secret.SetName(cred.Name)
+	secret.SetLabels(map[string]string{credentialLabel: "true"})
 	secret.Data = make(map[string][]byte)
-	secret.Data["accessToken"] = []byte(cred.AccessToken)
-	if cred.SecretToken != "" {
-		secret.Data["secretToken"] = []byte(cred.SecretToken)
-	} else {
-		secret.Data["secretToken"] = getRandomSecretToken()
+	if cred.AccessToken != "" {
+		secret.Data["accessToken"] = []byte(cred.AccessToken)
+	}
+	secret.Data["secretToken"] = []byte(cred.SecretToken)
+
+	switch cred.Type {
+	case "":
+		if cred.GitServer != "" {
+			secret.SetAnnotations(map[string]string{gitServerAnnotation: cred.GitServer})
+		}
+	case credentialTypeSSH:
+		secret.Type = corev1.SecretTypeSSHAuth
+		secret.Data[corev1.SSHAuthPrivateKey] = []byte(cred.SSHPrivateKey)
+		if cred.KnownHosts != "" {
+			secret.Data["known_hosts"] = []byte(cred.KnownHosts)
+		}
+		// Tekton expects the host for SSH credentials
+		secret.SetAnnotations(map[string]string{gitCredentialAnnotation: gitServerHost(cred.GitServer)})
+	case credentialTypeBasicAuth:
+		secret.Type = corev1.SecretTypeBasicAuth
+		secret.Data[corev1.BasicAuthUsernameKey] = []byte(cred.Username)
+		secret.Data[corev1.BasicAuthPasswordKey] = []byte(cred.Password)
+		// Tekton expects the URL for basic-auth credentials
+		secret.SetAnnotations(map[string]string{gitCredentialAnnotation: "https://" + gitServerHost(cred.GitServer)})
 	}
 	return &secret
 }
 
-var (
-	src = rand.NewSource(time.Now().UnixNano())
-)
+// Returns the host of the git server, github.com if none is given
+func gitServerHost(gitServer string) string {
+	host := strings.TrimPrefix(gitServer, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host = strings.TrimSuffix(host, "/")
+	if host == "" {
+		return defaultGitServer
+	}
+	return host
+}
 
 const (
-	tokenBytes   = "123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	tokenIdxBits = 6                   // 6 bits = 2^6 = 64 characters in tokenBytes
-	tokenIdxMask = 1<<tokenIdxBits - 1 // All 1-bits, as many as tokenIdxBits
+	secretTokenEncodingHex    = "hex"
+	secretTokenEncodingBase64 = "base64"
+
+	defaultSecretTokenLength     = 32 // bytes of randomness in a generated secret token
+	defaultMinSecretTokenEntropy = 64 // bits of estimated entropy required of a supplied secret token
 )
 
-// Generate a random 20-character string, returned as []byte.
-// With thanks to https://medium.com/@kpbird/golang-generate-fixed-size-random-string-dd6dbd5e63c0
-func getRandomSecretToken() []byte {
-	b := make([]byte, 20)
-	for i := 0; i < 20; {
-		idx := int(src.Int63() & tokenIdxMask)
-		if idx < len(tokenBytes) {
-			b[i] = tokenBytes[idx]
-			i++
+// Generate a secret token from length cryptographically random bytes, encoded as hex or URL-safe base64
+func generateSecretToken(length int, encoding string) ([]byte, error) {
+	if length <= 0 {
+		length = defaultSecretTokenLength
+	}
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	switch encoding {
+	case secretTokenEncodingBase64:
+		return []byte(base64.RawURLEncoding.EncodeToString(b)), nil
+	case secretTokenEncodingHex, "":
+		return []byte(hex.EncodeToString(b)), nil
+	default:
+		return nil, fmt.Errorf("unknown secret token encoding %s, expected %s or %s", encoding, secretTokenEncodingHex, secretTokenEncodingBase64)
+	}
+}
+
+// Estimates the entropy of a token in bits. This is the lower of two estimates: the length
+// times the bits per character of the character classes used, and the length times the
+// Shannon entropy of the characters, which catches repeated characters.
+func estimateEntropy(token string) float64 {
+	if token == "" {
+		return 0
+	}
+	var lower, upper, digit, other bool
+	counts := make(map[rune]int)
+	length := 0
+	for _, c := range token {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		default:
+			other = true
+		}
+		counts[c]++
+		length++
+	}
+
+	poolSize := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if class.used {
+			poolSize += class.size
 		}
 	}
-	return b
+	poolEntropy := float64(length) * math.Log2(float64(poolSize))
+
+	shannon := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(length)
+		shannon -= p * math.Log2(p)
+	}
+	return math.Min(poolEntropy, float64(length)*shannon)
 }
 
 // Convert K8s secret struct into credential struct
 func secretToCredential(secret *corev1.Secret, mask bool) credential {
 	var cred credential
-	if secret.Data["accessToken"] != nil {
+	gitServer, annotated := secret.GetAnnotations()[gitCredentialAnnotation]
+	switch {
+	case secret.Type == corev1.SecretTypeSSHAuth && annotated && secret.Data["secretToken"] != nil:
+		cred = credential{
+			Name:          secret.GetName(),
+			Type:          credentialTypeSSH,
+			AccessToken:   string(secret.Data["accessToken"]),
+			SecretToken:   string(secret.Data["secretToken"]),
+			GitServer:     gitServer,
+			SSHPrivateKey: string(secret.Data[corev1.SSHAuthPrivateKey]),
+			KnownHosts:    string(secret.Data["known_hosts"]),
+		}
+	case secret.Type == corev1.SecretTypeBasicAuth && annotated && secret.Data["secretToken"] != nil:
 		cred = credential{
 			Name:        secret.GetName(),
+			Type:        credentialTypeBasicAuth,
 			AccessToken: string(secret.Data["accessToken"]),
 			SecretToken: string(secret.Data["secretToken"]),
+			GitServer:   gitServer,
+			Username:    string(secret.Data[corev1.BasicAuthUsernameKey]),
+			Password:    string(secret.Data[corev1.BasicAuthPasswordKey]),
 		}
-		if mask {
-			cred.AccessToken = "********"
-			cred.SecretToken = "********"
+	case secret.Data["accessToken"] != nil:
+		cred = credential{
+			Name:        secret.GetName(),
+			AccessToken: string(secret.Data["accessToken"]),
+			SecretToken: string(secret.Data["secretToken"]),
+			GitServer:   secret.GetAnnotations()[gitServerAnnotation],
 		}
+	default:
+		return cred
+	}
+	if mask {
+		cred.AccessToken = maskIfSet(cred.AccessToken)
+		cred.SecretToken = maskedValue
+		cred.Password = maskIfSet(cred.Password)
+		cred.SSHPrivateKey = maskIfSet(cred.SSHPrivateKey)
 	}
 	return cred
 }
 
+func maskIfSet(value string) string {
+	if value == "" {
+		return ""
+	}
+	return maskedValue
+}
+
 // Checks the Accept header and reads the content into the entityPointer.
 func getQueryEntity(entityPointer interface{}, request *restful.Request, response *restful.Response) (err error) {
 	if err := request.ReadEntity(entityPointer); err != nil {
@@ -193,8 +550,23 @@ func (r Resource) verifyCredentialParameters(cred credential, response *restful.
 	errorMessage := ""
 	if cred.Name == "" {
 		errorMessage = fmt.Sprintf("error: Name must be specified")
-	} else if cred.AccessToken == "" {
+	} else if cred.Type != "" && cred.Type != credentialTypeSSH && cred.Type != credentialTypeBasicAuth {
+		errorMessage = fmt.Sprintf("error: Type must be one of %s or %s", credentialTypeSSH, credentialTypeBasicAuth)
+	} else if cred.Type == credentialTypeSSH && cred.SSHPrivateKey == "" {
+		errorMessage = fmt.Sprintf("error: SSHPrivateKey must be specified")
+	} else if cred.Type == credentialTypeBasicAuth && (cred.Username == "" || cred.Password == "") {
+		errorMessage = fmt.Sprintf("error: Username and Password must be specified")
+	} else if cred.AccessToken == "" && cred.Type != credentialTypeBasicAuth {
+		// The password of a basic-auth credential is used as the access token if none is given
 		errorMessage = fmt.Sprintf("error: AccessToken must be specified")
+	} else if cred.SecretToken != "" {
+		minEntropy := r.Defaults.MinSecretTokenEntropy
+		if minEntropy <= 0 {
+			minEntropy = defaultMinSecretTokenEntropy
+		}
+		if entropy := estimateEntropy(cred.SecretToken); entropy < minEntropy {
+			errorMessage = fmt.Sprintf("error: SecretToken is too weak, it has an estimated %.0f bits of entropy but %.0f are required. Leave it empty to have one generated", entropy, minEntropy)
+		}
 	}
 	if errorMessage != "" {
 		utils.RespondErrorMessage(response, errorMessage, http.StatusBadRequest)
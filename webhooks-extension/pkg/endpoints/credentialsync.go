@@ -0,0 +1,352 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/credentialstore"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/*--------------------------------------
+A webhook created with synccredential set has its credential copied into the
+namespace its pipelines run in and added to the secrets of its service account,
+so the pipelines can clone with it. Copies have the credential's name and are
+labelled with it. They are kept in step with the credential and are removed once
+no webhook synchronizing the credential into their namespace remains.
+
+The extension may only write secrets and service accounts in the namespaces
+credentials are synchronized into. It binds the credential sync ClusterRole to its
+service account with a RoleBinding in each of them, removed with the last copy.
+---------------------------------------*/
+
+// Set on copies of credentials, the value is the name of the credential copied
+const syncedCredentialLabel = "webhooks.tekton.dev/synced-credential"
+
+// The ClusterRole granting the writes credential sync needs, and the RoleBindings binding it
+const credentialSyncRole = "tekton-webhooks-extension-credential-sync"
+
+// Copies the webhook's credential into its namespace and attaches it to its service account
+func (r Resource) syncCredential(hook webhook) error {
+	if r.CredentialStore.Backend() != credentialstore.Kubernetes {
+		return fmt.Errorf("credentials can only be synchronized from the %s credential store, not the %s credential store", credentialstore.Kubernetes, r.CredentialStore.Backend())
+	}
+	source, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(hook.AccessTokenRef, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting credential %s to synchronize: %s", hook.AccessTokenRef, err)
+	}
+	if err := r.grantCredentialSync(hook.Namespace); err != nil {
+		return err
+	}
+
+	if hook.Namespace != r.Defaults.Namespace {
+		existing, err := r.K8sClient.CoreV1().Secrets(hook.Namespace).Get(source.Name, metav1.GetOptions{})
+		if err == nil {
+			if existing.Labels[syncedCredentialLabel] != source.Name {
+				return fmt.Errorf("a secret named %s that is not a copy of the credential already exists in namespace %s", source.Name, hook.Namespace)
+			}
+			if _, err := r.updateSyncedCredential(existing, source); err != nil {
+				return err
+			}
+		} else if k8serrors.IsNotFound(err) {
+			if _, err := r.K8sClient.CoreV1().Secrets(hook.Namespace).Create(syncedCredentialCopy(source, hook.Namespace)); err != nil {
+				return fmt.Errorf("error copying credential %s into namespace %s: %s", source.Name, hook.Namespace, err)
+			}
+			logging.Log.Debugf("Copied credential %s into namespace %s", source.Name, hook.Namespace)
+		} else {
+			return err
+		}
+	}
+
+	return r.attachSecretToServiceAccount(hook.Namespace, webhookServiceAccount(hook), source.Name)
+}
+
+// Removes the webhook's copy of its credential, unless another webhook synchronizing it into the same namespace remains
+func (r Resource) removeSyncedCredential(hook webhook) error {
+	remaining, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		return err
+	}
+	keepCopy, keepAttached, keepGrant := false, false, false
+	for _, other := range remaining {
+		if !other.SyncCredential || other.Namespace != hook.Namespace {
+			continue
+		}
+		keepGrant = true
+		if other.AccessTokenRef != hook.AccessTokenRef {
+			continue
+		}
+		keepCopy = true
+		if webhookServiceAccount(other) == webhookServiceAccount(hook) {
+			keepAttached = true
+		}
+	}
+	if !keepAttached {
+		if err := r.detachSecretFromServiceAccount(hook.Namespace, webhookServiceAccount(hook), hook.AccessTokenRef); err != nil {
+			return err
+		}
+	}
+	if keepCopy {
+		logging.Log.Debugf("Credential %s is still synchronized into namespace %s by other webhooks", hook.AccessTokenRef, hook.Namespace)
+		return nil
+	}
+	// The credential itself is not a copy
+	if hook.Namespace != r.Defaults.Namespace {
+		if err := r.deleteSyncedCredential(hook.Namespace, hook.AccessTokenRef); err != nil {
+			return err
+		}
+	}
+	if keepGrant {
+		return nil
+	}
+	return r.revokeCredentialSync(hook.Namespace)
+}
+
+func (r Resource) deleteSyncedCredential(namespace, name string) error {
+	synced, err := r.K8sClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if synced.Labels[syncedCredentialLabel] != name {
+		return nil
+	}
+	if err := r.K8sClient.CoreV1().Secrets(namespace).Delete(synced.Name, &metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	logging.Log.Debugf("Removed the copy of credential %s from namespace %s", name, namespace)
+	return nil
+}
+
+// Binds the credential sync ClusterRole to the extension's service account in namespace
+func (r Resource) grantCredentialSync(namespace string) error {
+	_, err := r.K8sClient.RbacV1().RoleBindings(namespace).Get(credentialSyncRole, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error getting rolebinding %s in namespace %s: %s", credentialSyncRole, namespace, err)
+	}
+	serviceAccount := os.Getenv("SERVICE_ACCOUNT")
+	if serviceAccount == "" {
+		serviceAccount = "tekton-webhooks-extension"
+	}
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credentialSyncRole,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": "tekton-webhooks-extension"},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: credentialSyncRole},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount, Namespace: r.Defaults.Namespace},
+		},
+	}
+	if _, err := r.K8sClient.RbacV1().RoleBindings(namespace).Create(roleBinding); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating rolebinding %s in namespace %s: %s", credentialSyncRole, namespace, err)
+	}
+	logging.Log.Debugf("Bound %s to service account %s in namespace %s", credentialSyncRole, serviceAccount, namespace)
+	return nil
+}
+
+func (r Resource) revokeCredentialSync(namespace string) error {
+	err := r.K8sClient.RbacV1().RoleBindings(namespace).Delete(credentialSyncRole, &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting rolebinding %s in namespace %s: %s", credentialSyncRole, namespace, err)
+	}
+	return nil
+}
+
+/*
+	Brings every copy of the named credential, or of all credentials when name is
+	empty, in step with the credential. Copies are looked for in the namespaces
+	webhooks synchronize credentials into. Copies of credentials that no longer exist
+	are left alone so pipelines keep working until their webhooks are deleted.
+*/
+func (r Resource) resyncCredentials(name string) error {
+	selector := syncedCredentialLabel
+	if name != "" {
+		selector = syncedCredentialLabel + "=" + name
+	}
+	hooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		return err
+	}
+	namespaces := map[string]bool{}
+	sources := map[string]*corev1.Secret{}
+	for _, hook := range hooks {
+		if !hook.SyncCredential || hook.Namespace == r.Defaults.Namespace || namespaces[hook.Namespace] {
+			continue
+		}
+		namespaces[hook.Namespace] = true
+		// Namespaces synchronized into before the RoleBindings were created get one here
+		if err := r.grantCredentialSync(hook.Namespace); err != nil {
+			logging.Log.Errorf("%s", err)
+			continue
+		}
+		copies, err := r.K8sClient.CoreV1().Secrets(hook.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			logging.Log.Errorf("error listing the copies of credentials in namespace %s: %s", hook.Namespace, err)
+			continue
+		}
+		for i := range copies.Items {
+			synced := &copies.Items[i]
+			sourceName := synced.Labels[syncedCredentialLabel]
+			source, found := sources[sourceName]
+			if !found {
+				source, err = r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(sourceName, metav1.GetOptions{})
+				if err != nil {
+					logging.Log.Errorf("error getting credential %s to synchronize its copy in namespace %s: %s", sourceName, synced.Namespace, err)
+					continue
+				}
+				sources[sourceName] = source
+			}
+			updated, err := r.updateSyncedCredential(synced, source)
+			if err != nil {
+				logging.Log.Errorf("%s", err)
+				continue
+			}
+			if updated {
+				logging.Log.Infof("Synchronized the copy of credential %s in namespace %s", sourceName, synced.Namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// SyncCredentials resynchronizes the copies of credentials every interval, picking up credentials rotated outside the extension
+func (r Resource) SyncCredentials(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := r.resyncCredentials(""); err != nil {
+			logging.Log.Errorf("error synchronizing credentials: %s", err)
+		}
+	}
+}
+
+func (r Resource) updateSyncedCredential(synced, source *corev1.Secret) (bool, error) {
+	desired := syncedCredentialCopy(source, synced.Namespace)
+	if synced.Type == desired.Type && reflect.DeepEqual(synced.Data, desired.Data) && reflect.DeepEqual(synced.Annotations, desired.Annotations) {
+		return false, nil
+	}
+	if synced.Type != desired.Type {
+		// The type of a secret cannot be changed, so replace it
+		if err := r.K8sClient.CoreV1().Secrets(synced.Namespace).Delete(synced.Name, &metav1.DeleteOptions{}); err != nil {
+			return false, fmt.Errorf("error replacing the copy of credential %s in namespace %s: %s", source.Name, synced.Namespace, err)
+		}
+		if _, err := r.K8sClient.CoreV1().Secrets(synced.Namespace).Create(desired); err != nil {
+			return false, fmt.Errorf("error replacing the copy of credential %s in namespace %s: %s", source.Name, synced.Namespace, err)
+		}
+		return true, nil
+	}
+	synced.Data = desired.Data
+	synced.Annotations = desired.Annotations
+	if _, err := r.K8sClient.CoreV1().Secrets(synced.Namespace).Update(synced); err != nil {
+		return false, fmt.Errorf("error updating the copy of credential %s in namespace %s: %s", source.Name, synced.Namespace, err)
+	}
+	return true, nil
+}
+
+/*
+	The copy of a credential put in a pipeline namespace. It keeps the tekton.dev/git-*
+	annotations used to authenticate clones but not the secret token, which only the
+	interceptor needs.
+*/
+func syncedCredentialCopy(source *corev1.Secret, namespace string) *corev1.Secret {
+	data := map[string][]byte{}
+	for key, value := range source.Data {
+		if key != "secretToken" {
+			data[key] = value
+		}
+	}
+	var annotations map[string]string
+	for key, value := range source.Annotations {
+		if strings.HasPrefix(key, "tekton.dev/git-") {
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[key] = value
+		}
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        source.Name,
+			Namespace:   namespace,
+			Labels:      map[string]string{syncedCredentialLabel: source.Name},
+			Annotations: annotations,
+		},
+		Type: source.Type,
+		Data: data,
+	}
+}
+
+func (r Resource) attachSecretToServiceAccount(namespace, serviceAccountName, secretName string) error {
+	serviceAccount, err := r.K8sClient.CoreV1().ServiceAccounts(namespace).Get(serviceAccountName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting service account %s in namespace %s: %s", serviceAccountName, namespace, err)
+	}
+	for _, secret := range serviceAccount.Secrets {
+		if secret.Name == secretName {
+			return nil
+		}
+	}
+	serviceAccount.Secrets = append(serviceAccount.Secrets, corev1.ObjectReference{Name: secretName})
+	if _, err := r.K8sClient.CoreV1().ServiceAccounts(namespace).Update(serviceAccount); err != nil {
+		return fmt.Errorf("error adding secret %s to service account %s in namespace %s: %s", secretName, serviceAccountName, namespace, err)
+	}
+	logging.Log.Debugf("Added secret %s to service account %s in namespace %s", secretName, serviceAccountName, namespace)
+	return nil
+}
+
+func (r Resource) detachSecretFromServiceAccount(namespace, serviceAccountName, secretName string) error {
+	serviceAccount, err := r.K8sClient.CoreV1().ServiceAccounts(namespace).Get(serviceAccountName, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	secrets := []corev1.ObjectReference{}
+	for _, secret := range serviceAccount.Secrets {
+		if secret.Name != secretName {
+			secrets = append(secrets, secret)
+		}
+	}
+	if len(secrets) == len(serviceAccount.Secrets) {
+		return nil
+	}
+	serviceAccount.Secrets = secrets
+	if _, err := r.K8sClient.CoreV1().ServiceAccounts(namespace).Update(serviceAccount); err != nil {
+		return fmt.Errorf("error removing secret %s from service account %s in namespace %s: %s", secretName, serviceAccountName, namespace, err)
+	}
+	return nil
+}
+
+func webhookServiceAccount(hook webhook) string {
+	if hook.ServiceAccount == "" {
+		return "default"
+	}
+	return hook.ServiceAccount
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func dummySyncResource(t *testing.T) *Resource {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "git-credential",
+			Namespace:   r.Defaults.Namespace,
+			Labels:      map[string]string{credentialLabel: "true"},
+			Annotations: map[string]string{gitCredentialAnnotation: "https://github.com", gitServerAnnotation: "github.com"},
+		},
+		Type: corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{"username": []byte("user"), "password": []byte("password"), "secretToken": []byte("secret")},
+	}
+	if _, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Create(source); err != nil {
+		t.Fatalf("Error creating credential: %s", err)
+	}
+	for _, name := range []string{"default", "pipeline-sa"} {
+		serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "pipelines"}}
+		if _, err := r.K8sClient.CoreV1().ServiceAccounts("pipelines").Create(serviceAccount); err != nil {
+			t.Fatalf("Error creating service account: %s", err)
+		}
+	}
+	return r
+}
+
+func serviceAccountHasSecret(r *Resource, name, secretName string, t *testing.T) bool {
+	serviceAccount, err := r.K8sClient.CoreV1().ServiceAccounts("pipelines").Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting service account %s: %s", name, err)
+	}
+	for _, secret := range serviceAccount.Secrets {
+		if secret.Name == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncCredential(t *testing.T) {
+	r := dummySyncResource(t)
+	hook := webhook{Name: "hook", Namespace: "pipelines", ServiceAccount: "pipeline-sa", AccessTokenRef: "git-credential", SyncCredential: true}
+
+	if err := r.syncCredential(hook); err != nil {
+		t.Fatalf("Unexpected error synchronizing credential: %s", err)
+	}
+	// Synchronizing again, as a second webhook would, must not add the secret twice
+	if err := r.syncCredential(hook); err != nil {
+		t.Fatalf("Unexpected error synchronizing credential again: %s", err)
+	}
+
+	synced, err := r.K8sClient.CoreV1().Secrets("pipelines").Get("git-credential", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Credential was not copied: %s", err)
+	}
+	if synced.Type != corev1.SecretTypeBasicAuth || synced.Labels[syncedCredentialLabel] != "git-credential" {
+		t.Errorf("Unexpected copy of credential: %+v", synced)
+	}
+	if _, found := synced.Data["secretToken"]; found {
+		t.Error("The secret token should not be copied")
+	}
+	expectedAnnotations := map[string]string{gitCredentialAnnotation: "https://github.com"}
+	if !reflect.DeepEqual(synced.Annotations, expectedAnnotations) {
+		t.Errorf("Copy annotations were %v, expected %v", synced.Annotations, expectedAnnotations)
+	}
+
+	serviceAccount, _ := r.K8sClient.CoreV1().ServiceAccounts("pipelines").Get("pipeline-sa", metav1.GetOptions{})
+	if len(serviceAccount.Secrets) != 1 || serviceAccount.Secrets[0].Name != "git-credential" {
+		t.Errorf("Service account secrets were %v, expected only git-credential", serviceAccount.Secrets)
+	}
+	if serviceAccountHasSecret(r, "default", "git-credential", t) {
+		t.Error("The credential should only be added to the webhook's service account")
+	}
+
+	roleBinding, err := r.K8sClient.RbacV1().RoleBindings("pipelines").Get(credentialSyncRole, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("The extension was not granted credential sync in the namespace: %s", err)
+	}
+	if roleBinding.RoleRef.Name != credentialSyncRole || len(roleBinding.Subjects) != 1 || roleBinding.Subjects[0].Name != "tekton-test-service-account" || roleBinding.Subjects[0].Namespace != r.Defaults.Namespace {
+		t.Errorf("Unexpected credential sync rolebinding: %+v", roleBinding)
+	}
+}
+
+func TestSyncCredentialRefusesToReplaceSecrets(t *testing.T) {
+	r := dummySyncResource(t)
+	existing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "git-credential", Namespace: "pipelines"}}
+	r.K8sClient.CoreV1().Secrets("pipelines").Create(existing)
+
+	hook := webhook{Name: "hook", Namespace: "pipelines", AccessTokenRef: "git-credential", SyncCredential: true}
+	if err := r.syncCredential(hook); err == nil {
+		t.Error("Expected an error synchronizing over a secret that is not a copy")
+	}
+	if serviceAccountHasSecret(r, "default", "git-credential", t) {
+		t.Error("The secret should not be added to the service account")
+	}
+}
+
+func TestResyncCredentials(t *testing.T) {
+	r := dummySyncResource(t)
+	hook := webhook{
+		Name:             "hook",
+		Namespace:        "pipelines",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "git-credential",
+		Pipeline:         "pipeline",
+		PullTask:         "monitor-task",
+		SyncCredential:   true,
+	}
+	if _, err := r.createEventListener(hook, r.Defaults.Namespace, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	if err := r.syncCredential(hook); err != nil {
+		t.Fatalf("Unexpected error synchronizing credential: %s", err)
+	}
+
+	// Rotate the password
+	source, _ := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get("git-credential", metav1.GetOptions{})
+	source.Data["password"] = []byte("rotated")
+	r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Update(source)
+
+	if err := r.resyncCredentials(""); err != nil {
+		t.Fatalf("Unexpected error resynchronizing credentials: %s", err)
+	}
+	synced, _ := r.K8sClient.CoreV1().Secrets("pipelines").Get("git-credential", metav1.GetOptions{})
+	if string(synced.Data["password"]) != "rotated" {
+		t.Errorf("Copy password was %s after resynchronizing, expected rotated", synced.Data["password"])
+	}
+}
+
+func TestRemoveSyncedCredential(t *testing.T) {
+	r := dummySyncResource(t)
+	hook := webhook{Name: "hook", Namespace: "pipelines", AccessTokenRef: "git-credential", SyncCredential: true}
+	if err := r.syncCredential(hook); err != nil {
+		t.Fatalf("Unexpected error synchronizing credential: %s", err)
+	}
+
+	// No eventlistener, so no other webhook uses the copy
+	if err := r.removeSyncedCredential(hook); err != nil {
+		t.Fatalf("Unexpected error removing synchronized credential: %s", err)
+	}
+	if _, err := r.K8sClient.CoreV1().Secrets("pipelines").Get("git-credential", metav1.GetOptions{}); err == nil {
+		t.Error("The copy of the credential should have been deleted")
+	}
+	if serviceAccountHasSecret(r, "default", "git-credential", t) {
+		t.Error("The copy of the credential should have been removed from the service account")
+	}
+	if _, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get("git-credential", metav1.GetOptions{}); err != nil {
+		t.Errorf("The credential itself should not be deleted: %s", err)
+	}
+	if _, err := r.K8sClient.RbacV1().RoleBindings("pipelines").Get(credentialSyncRole, metav1.GetOptions{}); err == nil {
+		t.Error("Credential sync should no longer be granted in a namespace without copies")
+	}
+}
+
+func TestRemoveSyncedCredentialStillInUse(t *testing.T) {
+	r := dummySyncResource(t)
+	remaining := webhook{
+		Name:             "remaining",
+		Namespace:        "pipelines",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "git-credential",
+		Pipeline:         "pipeline",
+		PullTask:         "monitor-task",
+		SyncCredential:   true,
+	}
+	if _, err := r.createEventListener(remaining, r.Defaults.Namespace, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	hook := webhook{Name: "hook", Namespace: "pipelines", ServiceAccount: "pipeline-sa", AccessTokenRef: "git-credential", SyncCredential: true}
+	for _, h := range []webhook{remaining, hook} {
+		if err := r.syncCredential(h); err != nil {
+			t.Fatalf("Unexpected error synchronizing credential: %s", err)
+		}
+	}
+
+	if err := r.removeSyncedCredential(hook); err != nil {
+		t.Fatalf("Unexpected error removing synchronized credential: %s", err)
+	}
+	if _, err := r.K8sClient.CoreV1().Secrets("pipelines").Get("git-credential", metav1.GetOptions{}); err != nil {
+		t.Errorf("The copy of the credential is still used and should not be deleted: %s", err)
+	}
+	if !serviceAccountHasSecret(r, "default", "git-credential", t) {
+		t.Error("The copy of the credential should still be attached to the remaining webhook's service account")
+	}
+	if serviceAccountHasSecret(r, "pipeline-sa", "git-credential", t) {
+		t.Error("The copy of the credential should have been removed from the deleted webhook's service account")
+	}
+	if _, err := r.K8sClient.RbacV1().RoleBindings("pipelines").Get(credentialSyncRole, metav1.GetOptions{}); err != nil {
+		t.Errorf("Credential sync should still be granted for the remaining webhook: %s", err)
+	}
+}
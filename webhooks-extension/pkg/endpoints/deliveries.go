@@ -0,0 +1,174 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/deliveryhistory"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+)
+
+/*--------------------------------------
+The interceptor records the most recent deliveries to each webhook, with its decision
+for each of the webhook's triggers, see pkg/deliveryhistory. They can be listed, and
+replayed through the eventlistener for the webhook's triggers alone.
+---------------------------------------*/
+
+// Sent with replayed deliveries, holding the ID of the delivery replayed
+const replayOfHeader = "X-Webhooks-Tekton-Replay-Of"
+
+// Headers of a recorded delivery that are not replayed, as they are set for the new request
+var unreplayedHeaders = []string{"Content-Length", "Host", "X-Github-Delivery"}
+
+type replayResult struct {
+	DeliveryID          string `json:"deliveryID"`
+	EventListenerStatus int    `json:"eventListenerStatus"`
+}
+
+func (r Resource) deliveryHistory() deliveryhistory.Store {
+	return deliveryhistory.Store{Client: r.K8sClient, Namespace: r.Defaults.Namespace}
+}
+
+// Finds the webhook named in the path in the namespace given as a query parameter, responding with an error if it cannot
+func (r Resource) webhookForRequest(request *restful.Request, response *restful.Response) (*webhook, bool) {
+	name := request.PathParameter("name")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		theError := errors.New("bad request information provided, a namespace must be specified as a query parameter")
+		logging.Log.Error(theError)
+		RespondError(response, theError, http.StatusBadRequest)
+		return nil, false
+	}
+	hooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		logging.Log.Errorf("error trying to get webhooks: %s.", err.Error())
+		RespondError(response, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	for _, hook := range hooks {
+		if hook.Name == name && hook.Namespace == namespace {
+			return &hook, true
+		}
+	}
+	err = fmt.Errorf("no webhook found with name %s associated with namespace %s", name, namespace)
+	logging.Log.Error(err)
+	RespondError(response, err, http.StatusNotFound)
+	return nil, false
+}
+
+// Lists the recorded deliveries to a webhook, newest first
+func (r Resource) getDeliveries(request *restful.Request, response *restful.Response) {
+	hook, found := r.webhookForRequest(request, response)
+	if !found {
+		return
+	}
+	deliveries, err := r.deliveryHistory().List(hook.Name + "-" + hook.Namespace)
+	if err != nil {
+		logging.Log.Errorf("error getting the deliveries to webhook %s: %s", hook.Name, err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	response.WriteEntity(deliveries)
+}
+
+/*
+	Sends a recorded delivery to the eventlistener again, for the webhook's triggers
+	alone. The payload and its signature are unchanged, the delivery is given a new ID
+	so that it is recorded as a delivery of its own.
+*/
+func (r Resource) replayDelivery(request *restful.Request, response *restful.Response) {
+	hook, found := r.webhookForRequest(request, response)
+	if !found {
+		return
+	}
+	id := request.PathParameter("id")
+	delivery, err := r.deliveryHistory().Get(hook.Name+"-"+hook.Namespace, id)
+	if err != nil {
+		logging.Log.Errorf("error getting the deliveries to webhook %s: %s", hook.Name, err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		err := fmt.Errorf("no delivery %s found for webhook %s", id, hook.Name)
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	replayID := fmt.Sprintf("%s-replay-%d", id, time.Now().Unix())
+	status, err := r.sendReplay(*hook, *delivery, replayID)
+	if err != nil {
+		logging.Log.Errorf("error replaying delivery %s to webhook %s: %s", id, hook.Name, err)
+		RespondError(response, err, http.StatusBadGateway)
+		return
+	}
+	logging.Log.Infof("Replayed delivery %s to webhook %s as %s, the eventlistener returned %d", id, hook.Name, replayID, status)
+	response.WriteHeaderAndEntity(http.StatusAccepted, replayResult{DeliveryID: replayID, EventListenerStatus: status})
+}
+
+// Sends the delivery to the eventlistener, returning the eventlistener's status
+func (r Resource) sendReplay(hook webhook, delivery deliveryhistory.Delivery, replayID string) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, eventListenerURL(r.Defaults.Namespace), strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range delivery.Header {
+		if containsHeader(unreplayedHeaders, name) {
+			continue
+		}
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	request.Header.Set("X-Github-Delivery", replayID)
+	request.Header.Set(replayOfHeader, delivery.ID)
+	request.Header.Set(targetTriggersHeader, strings.Join(r.replayTriggers(hook), ","))
+
+	eventListenerResponse, err := eventListenerClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer eventListenerResponse.Body.Close()
+	return eventListenerResponse.StatusCode, nil
+}
+
+// The triggers a replay is for: the webhook's own and its repository's monitor trigger
+func (r Resource) replayTriggers(hook webhook) []string {
+	prefix := hook.Name + "-" + hook.Namespace
+	if isGeneric(hook) {
+		return []string{prefix + genericTriggerSuffix}
+	}
+	triggers := []string{prefix + "-push-event", prefix + "-pullrequest-event"}
+	gitServer, gitOwner, gitRepo, err := getGitValues(hook.GitRepositoryURL)
+	if err == nil {
+		monitorTriggerName := strings.TrimPrefix(gitServer+"/"+gitOwner+"/"+gitRepo, "http://")
+		triggers = append(triggers, strings.TrimPrefix(monitorTriggerName, "https://"))
+	}
+	return triggers
+}
+
+func containsHeader(headers []string, name string) bool {
+	for _, header := range headers {
+		if http.CanonicalHeaderKey(name) == header {
+			return true
+		}
+	}
+	return false
+}
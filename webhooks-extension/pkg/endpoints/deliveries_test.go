@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/deliveryhistory"
+)
+
+var recordedHook = webhook{
+	Name:             "recorded",
+	Namespace:        "pipelines",
+	GitRepositoryURL: "https://github.com/owner/repo",
+	AccessTokenRef:   "token",
+	Pipeline:         "pipeline",
+	PullTask:         "monitor-task",
+}
+
+var recordedDelivery = deliveryhistory.Delivery{
+	ID:      "a-delivery-id",
+	Event:   "push",
+	Header:  map[string][]string{"X-Github-Event": {"push"}, "X-Github-Delivery": {"a-delivery-id"}, "X-Hub-Signature": {"sha1=abc"}, "Content-Length": {"2"}},
+	Payload: `{"ref":"refs/heads/master"}`,
+}
+
+func recordingResource(t *testing.T) *Resource {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	if _, err := r.createEventListener(recordedHook, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	store := r.deliveryHistory()
+	store.Size = 10
+	if err := store.Record("recorded-pipelines", recordedDelivery, deliveryhistory.Decision{Trigger: "recorded-pipelines-push-event", Status: 200}); err != nil {
+		t.Fatalf("Error recording delivery: %s", err)
+	}
+	return r
+}
+
+func TestGetDeliveries(t *testing.T) {
+	r := recordingResource(t)
+
+	httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/recorded/deliveries?namespace=pipelines", nil)
+	httpWriter := httptest.NewRecorder()
+	r.getDeliveries(dummyRestfulRequest(httpReq, "recorded"), dummyRestfulResponse(httpWriter))
+	deliveries := []deliveryhistory.Delivery{}
+	if err := json.NewDecoder(httpWriter.Body).Decode(&deliveries); err != nil {
+		t.Fatalf("Error decoding deliveries: %s", err)
+	}
+	if httpWriter.Code != http.StatusOK || len(deliveries) != 1 || deliveries[0].ID != "a-delivery-id" || len(deliveries[0].Decisions) != 1 {
+		t.Errorf("Got status %d and deliveries %+v, expected the recorded delivery", httpWriter.Code, deliveries)
+	}
+
+	for url, status := range map[string]int{
+		"http://wwww.dummy.com:8080/webhooks/recorded/deliveries":                 http.StatusBadRequest,
+		"http://wwww.dummy.com:8080/webhooks/recorded/deliveries?namespace=other": http.StatusNotFound,
+	} {
+		httpWriter := httptest.NewRecorder()
+		r.getDeliveries(dummyRestfulRequest(dummyHTTPRequest("GET", url, nil), "recorded"), dummyRestfulResponse(httpWriter))
+		if httpWriter.Code != status {
+			t.Errorf("Getting %s returned status %d, expected %d", url, httpWriter.Code, status)
+		}
+	}
+}
+
+func TestReplayDelivery(t *testing.T) {
+	r := recordingResource(t)
+
+	var received *http.Request
+	var body []byte
+	eventListener := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received = request
+		body, _ = ioutil.ReadAll(request.Body)
+		writer.WriteHeader(http.StatusCreated)
+	}))
+	defer eventListener.Close()
+	defer func(url func(string) string) { eventListenerURL = url }(eventListenerURL)
+	eventListenerURL = func(string) string { return eventListener.URL }
+
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/recorded/deliveries/a-delivery-id/replay?namespace=pipelines", nil)
+	req := dummyRestfulRequest(httpReq, "recorded")
+	req.PathParameters()["id"] = "a-delivery-id"
+	httpWriter := httptest.NewRecorder()
+	r.replayDelivery(req, dummyRestfulResponse(httpWriter))
+
+	result := replayResult{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+	if httpWriter.Code != http.StatusAccepted || result.EventListenerStatus != http.StatusCreated || result.DeliveryID == "a-delivery-id" {
+		t.Fatalf("Replay returned status %d and %+v, expected it to be accepted with a new delivery ID", httpWriter.Code, result)
+	}
+	if string(body) != recordedDelivery.Payload || received.Header.Get("X-Hub-Signature") != "sha1=abc" {
+		t.Errorf("Replayed %s with headers %v, expected the recorded payload and signature", body, received.Header)
+	}
+	if received.Header.Get("X-Github-Delivery") != result.DeliveryID || received.Header.Get(replayOfHeader) != "a-delivery-id" {
+		t.Errorf("Replayed delivery had headers %v, expected the new delivery ID and the original", received.Header)
+	}
+	expectedTriggers := "recorded-pipelines-push-event,recorded-pipelines-pullrequest-event,github.com/owner/repo"
+	if received.Header.Get(targetTriggersHeader) != expectedTriggers {
+		t.Errorf("Replayed delivery was for triggers %s, expected %s", received.Header.Get(targetTriggersHeader), expectedTriggers)
+	}
+
+	req.PathParameters()["id"] = "missing"
+	httpWriter = httptest.NewRecorder()
+	r.replayDelivery(req, dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != http.StatusNotFound {
+		t.Errorf("Replaying a missing delivery returned status %d, expected %d", httpWriter.Code, http.StatusNotFound)
+	}
+}
+
+func TestReplayTriggersOfGenericWebhook(t *testing.T) {
+	r := dummyResource()
+	hook := genericHook
+	if triggers := r.replayTriggers(hook); len(triggers) != 1 || triggers[0] != hook.Name+"-"+hook.Namespace+genericTriggerSuffix {
+		t.Errorf("Generic webhook was replayed for triggers %v, expected its generic trigger", triggers)
+	}
+}
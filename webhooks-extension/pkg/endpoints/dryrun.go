@@ -0,0 +1,196 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+/*--------------------------------------
+A dry run of creating or deleting a webhook reports the changes that would be made
+without making them: the eventlistener triggers added or removed, the object
+exposing the eventlistener and the hook on the git provider. Only reads are made.
+---------------------------------------*/
+
+const (
+	dryRunCreate = "create"
+	dryRunUpdate = "update"
+	dryRunDelete = "delete"
+	dryRunNone   = "none"
+)
+
+type dryRunResult struct {
+	Valid            bool              `json:"valid"`
+	ValidationErrors []string          `json:"validationErrors,omitempty"`
+	EventListener    eventListenerDiff `json:"eventListener"`
+	Exposure         *dryRunChange     `json:"exposure,omitempty"`
+	GitWebhook       *dryRunChange     `json:"gitWebhook,omitempty"`
+}
+
+type eventListenerDiff struct {
+	Action          string                          `json:"action"`
+	AddedTriggers   []v1alpha1.EventListenerTrigger `json:"addedTriggers,omitempty"`
+	RemovedTriggers []v1alpha1.EventListenerTrigger `json:"removedTriggers,omitempty"`
+}
+
+// An object that would be created or deleted
+type dryRunChange struct {
+	Action string      `json:"action"`
+	Kind   string      `json:"kind"`
+	Object interface{} `json:"object,omitempty"`
+}
+
+// Reads the dryRun query parameter
+func dryRunRequested(request *restful.Request) (bool, error) {
+	dryRun := request.QueryParameter("dryRun")
+	if dryRun == "" {
+		return false, nil
+	}
+	requested, err := strconv.ParseBool(dryRun)
+	if err != nil {
+		return false, fmt.Errorf("bad request information provided, cannot handle dryRun query (should be set to true or not provided)")
+	}
+	return requested, nil
+}
+
+func (result *dryRunResult) invalid(err error) {
+	result.ValidationErrors = append(result.ValidationErrors, err.Error())
+}
+
+// Responds with the result, with a 400 if the request would fail validation
+func (r Resource) respondDryRun(response *restful.Response, result dryRunResult) {
+	result.Valid = len(result.ValidationErrors) == 0
+	status := http.StatusOK
+	if !result.Valid {
+		status = http.StatusBadRequest
+	}
+	response.WriteHeaderAndEntity(status, result)
+}
+
+// The changes creating the validated webhook would make
+func (r Resource) dryRunCreation(hook webhook, hooks []webhook, validationErrors []error) dryRunResult {
+	result := dryRunResult{EventListener: eventListenerDiff{Action: dryRunNone}}
+	for _, err := range validationErrors {
+		result.invalid(err)
+	}
+	creation, err := r.newWebhookCreation(hook, len(gitProviderHooks(hooks)) == 0)
+	if err != nil {
+		result.invalid(err)
+		return result
+	}
+
+	eventListener, err := r.getEventListener(r.Defaults.Namespace)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		result.invalid(fmt.Errorf("error getting eventlistener: %s", err))
+		return result
+	}
+	if eventListener == nil || eventListener.GetName() == "" {
+		result.EventListener = eventListenerDiff{
+			Action:        dryRunCreate,
+			AddedTriggers: r.webhookTriggers(hook, creation.monitorTriggerName),
+		}
+		result.Exposure = r.dryRunExposure(dryRunCreate, &result)
+	} else {
+		result.EventListener = eventListenerDiff{
+			Action:        dryRunUpdate,
+			AddedTriggers: r.addedTriggers(eventListener.Spec.Triggers, hook, creation.monitorTriggerName),
+		}
+	}
+
+	if creation.firstOnRepo {
+		result.GitWebhook = r.dryRunGitWebhook(dryRunCreate, hook, &result)
+	}
+	return result
+}
+
+// The changes deleting the webhook would make. lastOnRepo is true when no other webhook
+// is on the repository, so the git provider's hook is deleted.
+func (r Resource) dryRunDeletion(hook webhook, lastOnRepo bool, monitorTriggerName string) dryRunResult {
+	result := dryRunResult{EventListener: eventListenerDiff{Action: dryRunNone}}
+	eventListener, err := r.getEventListener(r.Defaults.Namespace)
+	if err != nil {
+		result.invalid(fmt.Errorf("error getting eventlistener: %s", err))
+		return result
+	}
+	remaining := remainingTriggers(eventListener.Spec.Triggers, hook.Name+"-"+hook.Namespace, monitorTriggerName, hook.GitRepositoryURL)
+	removed := []v1alpha1.EventListenerTrigger{}
+	for _, trigger := range eventListener.Spec.Triggers {
+		if !containsTrigger(remaining, trigger.Name) {
+			removed = append(removed, trigger)
+		}
+	}
+	result.EventListener = eventListenerDiff{Action: dryRunUpdate, RemovedTriggers: removed}
+	if len(remaining) == 0 {
+		result.EventListener.Action = dryRunDelete
+		result.Exposure = r.dryRunExposure(dryRunDelete, &result)
+	}
+
+	if lastOnRepo {
+		result.GitWebhook = r.dryRunGitWebhook(dryRunDelete, hook, &result)
+	}
+	return result
+}
+
+func (r Resource) dryRunExposure(action string, result *dryRunResult) *dryRunChange {
+	exposer, err := r.eventListenerExposer()
+	if err != nil {
+		result.invalid(err)
+		return nil
+	}
+	object, err := exposer.object(r.Defaults.Namespace)
+	if err != nil {
+		result.invalid(err)
+		return nil
+	}
+	if object == nil {
+		return nil
+	}
+	return &dryRunChange{Action: action, Kind: exposer.kind(), Object: object}
+}
+
+/*
+	The hook on the git provider. No request is made to the provider, so a hook
+	found to exist already when creating is reported as created, and the hook
+	deleted is the one calling WEBHOOK_CALLBACK_URL.
+*/
+func (r Resource) dryRunGitWebhook(action string, hook webhook, result *dryRunResult) *dryRunChange {
+	_, gitOwner, gitRepo, err := getGitValues(hook.GitRepositoryURL)
+	if err != nil {
+		result.invalid(err)
+		return nil
+	}
+	gitProvider, err := r.createGitProviderForWebhook(hook, gitOwner, gitRepo)
+	if err != nil {
+		logging.Log.Errorf("error configuring the git provider for %s: %s", hook.GitRepositoryURL, err)
+		result.invalid(err)
+		return nil
+	}
+	return &dryRunChange{Action: action, Kind: "webhook", Object: gitProvider.WebhookPayload(hook)}
+}
+
+func containsTrigger(triggers []v1alpha1.EventListenerTrigger, name string) bool {
+	for _, trigger := range triggers {
+		if trigger.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var dryRunHook = webhook{
+	Name:             "dryrun",
+	Namespace:        "pipelines",
+	GitRepositoryURL: "https://github.com/owner/repo",
+	AccessTokenRef:   "token",
+	Pipeline:         "pipeline",
+}
+
+func dryRunCreateWebhook(hook webhook, r *Resource, t *testing.T) (int, dryRunResult) {
+	b, _ := json.Marshal(hook)
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/?dryRun=true", bytes.NewBuffer(b))
+	httpWriter := httptest.NewRecorder()
+	r.createWebhook(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(httpWriter))
+	return httpWriter.Code, decodeDryRunResult(httpWriter, t)
+}
+
+func decodeDryRunResult(httpWriter *httptest.ResponseRecorder, t *testing.T) dryRunResult {
+	result := dryRunResult{}
+	if err := json.NewDecoder(httpWriter.Body).Decode(&result); err != nil {
+		t.Fatalf("Error decoding dry run result: %s", err)
+	}
+	return result
+}
+
+func assertNothingCreated(r *Resource, t *testing.T) {
+	if _, err := r.getEventListener(installNs); !k8serrors.IsNotFound(err) {
+		t.Errorf("Dry run created an eventlistener, error was %v", err)
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get(ingressName, metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("Dry run created an ingress, error was %v", err)
+	}
+}
+
+func TestDryRunCreationReportsValidationErrors(t *testing.T) {
+	r := dummyResource()
+	hook := dryRunHook
+	hook.MaxConcurrentRuns = -1
+
+	status, result := dryRunCreateWebhook(hook, r, t)
+	if status != 400 || result.Valid {
+		t.Errorf("Dry run of an invalid webhook returned %d and valid %t, expected 400 and invalid", status, result.Valid)
+	}
+	// The negative concurrency and the missing trigger template and bindings
+	if len(result.ValidationErrors) != 2 {
+		t.Errorf("Dry run returned validation errors %v, expected 2", result.ValidationErrors)
+	}
+	assertNothingCreated(r, t)
+}
+
+func TestDryRunCreation(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	os.Setenv("WEBHOOK_CALLBACK_URL", "https://hooks.example.com")
+	defer os.Unsetenv("WEBHOOK_CALLBACK_URL")
+	r := dummyResource()
+	r.Defaults.CallbackURL = "https://hooks.example.com"
+	createTriggerResources(dryRunHook, r)
+
+	status, result := dryRunCreateWebhook(dryRunHook, r, t)
+	if status != 200 || !result.Valid {
+		t.Fatalf("Dry run returned %d with validation errors %v, expected 200", status, result.ValidationErrors)
+	}
+	if result.EventListener.Action != dryRunCreate || len(result.EventListener.AddedTriggers) != 3 {
+		t.Errorf("Dry run would %s the eventlistener adding %d triggers, expected to create it with 3", result.EventListener.Action, len(result.EventListener.AddedTriggers))
+	}
+	if result.Exposure == nil || result.Exposure.Kind != "ingress" || result.Exposure.Object == nil {
+		t.Errorf("Dry run did not report the ingress, got %+v", result.Exposure)
+	}
+	if result.GitWebhook == nil || result.GitWebhook.Action != dryRunCreate {
+		t.Fatalf("Dry run did not report the GitHub webhook, got %+v", result.GitWebhook)
+	}
+	payload, _ := json.Marshal(result.GitWebhook.Object)
+	if bytes.Contains(payload, []byte(`"secret":"secret"`)) {
+		t.Errorf("Dry run reported the secret token in %s", payload)
+	}
+	assertNothingCreated(r, t)
+}
+
+func TestDryRunDeletion(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	other := dryRunHook
+	other.Name = "other"
+	other.Pipeline = "other-pipeline"
+	el, err := r.createEventListener(dryRunHook, installNs, "github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	if _, err := r.updateEventListener(el, other, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error updating eventlistener: %s", err)
+	}
+
+	httpReq := dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8080/webhooks/dryrun?namespace=pipelines&repository=https://github.com/owner/repo&dryRun=true", nil)
+	httpWriter := httptest.NewRecorder()
+	r.deleteWebhook(dummyRestfulRequest(httpReq, "dryrun"), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != 200 {
+		t.Fatalf("Dry run of deletion returned %d, expected 200", httpWriter.Code)
+	}
+	result := decodeDryRunResult(httpWriter, t)
+
+	// The monitor trigger stays for the other webhook on the repository, as does GitHub's webhook
+	if result.EventListener.Action != dryRunUpdate || len(result.EventListener.RemovedTriggers) != 2 {
+		t.Errorf("Dry run would %s the eventlistener removing %d triggers, expected to update it removing 2", result.EventListener.Action, len(result.EventListener.RemovedTriggers))
+	}
+	if result.Exposure != nil || result.GitWebhook != nil {
+		t.Errorf("Dry run reported deleting %+v and %+v, expected neither", result.Exposure, result.GitWebhook)
+	}
+	el, err = r.getEventListener(installNs)
+	if err != nil || len(el.Spec.Triggers) != 5 {
+		t.Errorf("Dry run changed the eventlistener")
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Triggers call the interceptor service with the Wext-* headers (v1alpha1 webhook interceptor)
+	interceptorProtocolHeader = "header"
+	// Triggers reference the interceptor as a ClusterInterceptor, passing the Wext-* values as params
+	interceptorProtocolRequest = "interceptorrequest"
+
+	interceptorServiceName = "tekton-webhooks-extension-validator"
+
+	// Set on an eventlistener that is about to be deleted
+	eventListenerDeletingAnnotation = "webhooks.tekton.dev/deleting"
+)
+
+var eventListenerResource = schema.GroupVersionResource{Group: "triggers.tekton.dev", Version: "v1alpha1", Resource: "eventlisteners"}
+
+var clusterInterceptorResource = schema.GroupVersionResource{Group: "triggers.tekton.dev", Version: "v1alpha1", Resource: "clusterinterceptors"}
+
+// Logs an error if triggers are written with the interceptorrequest protocol but the
+// ClusterInterceptor they reference, shipped in overlays/interceptorrequest, is missing
+func (r Resource) CheckClusterInterceptor() {
+	if r.Defaults.InterceptorProtocol != interceptorProtocolRequest {
+		return
+	}
+	_, err := r.DynamicClient.Resource(clusterInterceptorResource).Get(interceptorServiceName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logging.Log.Errorf("INTERCEPTOR_PROTOCOL is %s but the ClusterInterceptor %s does not exist, so no trigger will run until it is created, see docs/InterceptorProtocols.md", interceptorProtocolRequest, interceptorServiceName)
+	} else if err != nil {
+		logging.Log.Errorf("error checking for the ClusterInterceptor %s: %s", interceptorServiceName, err)
+	}
+}
+
+/*
+	The eventlistener is shared by every replica of the extension, so it is changed
+	with read-modify-write cycles: an update is refused with a conflict if the
+	eventlistener changed since it was read, and the cycle is retried with the new
+	version. Deleting it takes two steps. It is first annotated as being deleted
+	with an update, so that a replica adding a webhook meanwhile gets a conflict,
+	and then deleted. A replica finding an annotated eventlistener finishes
+	deleting it and creates a new one. Deletes are made on condition that the
+	eventlistener is still the one that was read, so that a replica finishing a
+	delete late cannot remove the eventlistener that replaced it. Only the UID can
+	be a precondition in this version of the API, which suffices as an annotated
+	eventlistener is only ever replaced, never written back into use.
+*/
+func eventListenerBeingDeleted(el *v1alpha1.EventListener) bool {
+	return el.GetAnnotations()[eventListenerDeletingAnnotation] == "true"
+}
+
+// All reads and writes of the eventlistener go through the functions below. With
+// the header protocol the typed triggers client is used. With the interceptorrequest
+// protocol the eventlistener is written in the newer Triggers format using the
+// dynamic client, and converted back on read so the rest of the code is unaware.
+func (r Resource) getEventListener(namespace string) (*v1alpha1.EventListener, error) {
+	if r.Defaults.InterceptorProtocol != interceptorProtocolRequest {
+		return r.TriggersClient.TektonV1alpha1().EventListeners(namespace).Get(eventListenerName, metav1.GetOptions{})
+	}
+	u, err := r.DynamicClient.Resource(eventListenerResource).Namespace(namespace).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return r.fromInterceptorRequestEventListener(u)
+}
+
+func (r Resource) createEventListenerResource(el *v1alpha1.EventListener) (*v1alpha1.EventListener, error) {
+	if r.Defaults.InterceptorProtocol != interceptorProtocolRequest {
+		return r.TriggersClient.TektonV1alpha1().EventListeners(el.GetNamespace()).Create(el)
+	}
+	u, err := r.DynamicClient.Resource(eventListenerResource).Namespace(el.GetNamespace()).Create(toInterceptorRequestEventListener(el), metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return r.fromInterceptorRequestEventListener(u)
+}
+
+func (r Resource) updateEventListenerResource(el *v1alpha1.EventListener) (*v1alpha1.EventListener, error) {
+	if r.Defaults.InterceptorProtocol != interceptorProtocolRequest {
+		return r.TriggersClient.TektonV1alpha1().EventListeners(el.GetNamespace()).Update(el)
+	}
+	u, err := r.DynamicClient.Resource(eventListenerResource).Namespace(el.GetNamespace()).Update(toInterceptorRequestEventListener(el), metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return r.fromInterceptorRequestEventListener(u)
+}
+
+// Deletes the eventlistener if it is still el, failing with a conflict if it was replaced
+func (r Resource) deleteEventListener(el *v1alpha1.EventListener) error {
+	options := &metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(el.GetUID()))}
+	if r.Defaults.InterceptorProtocol != interceptorProtocolRequest {
+		return r.TriggersClient.TektonV1alpha1().EventListeners(el.GetNamespace()).Delete(eventListenerName, options)
+	}
+	return r.DynamicClient.Resource(eventListenerResource).Namespace(el.GetNamespace()).Delete(eventListenerName, options)
+}
+
+// Converts an eventlistener into the format used with ClusterInterceptors: the
+// binding and trigger params become bindings, the template is referenced and the
+// interceptor headers become params on a ClusterInterceptor reference.
+func toInterceptorRequestEventListener(el *v1alpha1.EventListener) *unstructured.Unstructured {
+	triggers := []interface{}{}
+	for _, t := range el.Spec.Triggers {
+		bindings := []interface{}{map[string]interface{}{"ref": t.Binding.Name}}
+		for _, p := range t.Params {
+			bindings = append(bindings, map[string]interface{}{"name": p.Name, "value": p.Value.StringVal})
+		}
+		interceptorParams := []interface{}{}
+		if t.Interceptor != nil {
+			for _, h := range t.Interceptor.Header {
+				interceptorParams = append(interceptorParams, map[string]interface{}{"name": h.Name, "value": h.Value.StringVal})
+			}
+		}
+		triggers = append(triggers, map[string]interface{}{
+			"name":     t.Name,
+			"bindings": bindings,
+			"template": map[string]interface{}{"ref": t.Template.Name},
+			"interceptors": []interface{}{
+				map[string]interface{}{
+					"ref":    map[string]interface{}{"name": interceptorServiceName, "kind": "ClusterInterceptor"},
+					"params": interceptorParams,
+				},
+			},
+		})
+	}
+
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"serviceAccountName": el.Spec.ServiceAccountName,
+			"triggers":           triggers,
+		},
+	}}
+	u.SetAPIVersion(eventListenerResource.GroupVersion().String())
+	u.SetKind("EventListener")
+	u.SetName(el.GetName())
+	u.SetNamespace(el.GetNamespace())
+	u.SetResourceVersion(el.GetResourceVersion())
+	if len(el.GetAnnotations()) > 0 {
+		u.SetAnnotations(el.GetAnnotations())
+	}
+	return u
+}
+
+// Converts an eventlistener in the ClusterInterceptor format back into the typed eventlistener
+func (r Resource) fromInterceptorRequestEventListener(u *unstructured.Unstructured) (*v1alpha1.EventListener, error) {
+	serviceAccountName, _, err := unstructured.NestedString(u.Object, "spec", "serviceAccountName")
+	if err != nil {
+		return nil, err
+	}
+	unstructuredTriggers, _, err := unstructured.NestedSlice(u.Object, "spec", "triggers")
+	if err != nil {
+		return nil, err
+	}
+
+	triggers := []v1alpha1.EventListenerTrigger{}
+	for _, ut := range unstructuredTriggers {
+		t, ok := ut.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected trigger format in eventlistener %s", u.GetName())
+		}
+		trigger, err := r.fromInterceptorRequestTrigger(t)
+		if err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, trigger)
+	}
+
+	return &v1alpha1.EventListener{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            u.GetName(),
+			Namespace:       u.GetNamespace(),
+			UID:             u.GetUID(),
+			ResourceVersion: u.GetResourceVersion(),
+			Annotations:     u.GetAnnotations(),
+		},
+		Spec: v1alpha1.EventListenerSpec{
+			ServiceAccountName: serviceAccountName,
+			Triggers:           triggers,
+		},
+	}, nil
+}
+
+func (r Resource) fromInterceptorRequestTrigger(t map[string]interface{}) (v1alpha1.EventListenerTrigger, error) {
+	name, _, _ := unstructured.NestedString(t, "name")
+	templateName, _, _ := unstructured.NestedString(t, "template", "ref")
+	trigger := r.newTrigger(name, "", templateName, "", "", "", []pipelinesv1alpha1.Param{})
+	trigger.Interceptor.Header = []pipelinesv1alpha1.Param{}
+
+	bindings, _, err := unstructured.NestedSlice(t, "bindings")
+	if err != nil {
+		return trigger, err
+	}
+	for _, b := range bindings {
+		binding, ok := b.(map[string]interface{})
+		if !ok {
+			return trigger, errors.New("unexpected binding format in trigger " + name)
+		}
+		if ref, ok := binding["ref"].(string); ok {
+			trigger.Binding.Name = ref
+		} else {
+			trigger.Params = append(trigger.Params, stringParam(binding))
+		}
+	}
+
+	interceptors, _, err := unstructured.NestedSlice(t, "interceptors")
+	if err != nil {
+		return trigger, err
+	}
+	for _, i := range interceptors {
+		interceptor, ok := i.(map[string]interface{})
+		if !ok {
+			return trigger, errors.New("unexpected interceptor format in trigger " + name)
+		}
+		params, _, _ := unstructured.NestedSlice(interceptor, "params")
+		for _, p := range params {
+			if param, ok := p.(map[string]interface{}); ok {
+				trigger.Interceptor.Header = append(trigger.Interceptor.Header, stringParam(param))
+			}
+		}
+	}
+	return trigger, nil
+}
+
+func stringParam(param map[string]interface{}) pipelinesv1alpha1.Param {
+	name, _ := param["name"].(string)
+	value, _ := param["value"].(string)
+	return pipelinesv1alpha1.Param{Name: name, Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: value}}
+}
+
+// The reference to the interceptor service used by the header protocol
+func (r Resource) interceptorServiceRef() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       interceptorServiceName,
+		Namespace:  r.Defaults.Namespace,
+	}
+}
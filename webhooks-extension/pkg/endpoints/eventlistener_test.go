@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"reflect"
+	"testing"
+
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestInterceptorRequestEventListenerRoundTrip(t *testing.T) {
+	r := dummyResource()
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+		DockerRegistry:   "registry1",
+		PullTask:         "pulltask1",
+	}
+
+	el := &v1alpha1.EventListener{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            eventListenerName,
+			Namespace:       installNs,
+			ResourceVersion: "42",
+		},
+		Spec: v1alpha1.EventListenerSpec{
+			ServiceAccountName: "tekton-webhooks-extension-eventlistener",
+			Triggers:           getExpectedTriggers(hook, "github.com/owner/repo", r),
+		},
+	}
+
+	u := toInterceptorRequestEventListener(el)
+	if u.GetAPIVersion() != "triggers.tekton.dev/v1alpha1" {
+		t.Errorf("Eventlistener apiVersion was %s, expected triggers.tekton.dev/v1alpha1", u.GetAPIVersion())
+	}
+	triggers, _, _ := unstructured.NestedSlice(u.Object, "spec", "triggers")
+	interceptors, _, _ := unstructured.NestedSlice(triggers[0].(map[string]interface{}), "interceptors")
+	kind, _, _ := unstructured.NestedString(interceptors[0].(map[string]interface{}), "ref", "kind")
+	if kind != "ClusterInterceptor" {
+		t.Errorf("Interceptor reference kind was %s, expected ClusterInterceptor", kind)
+	}
+
+	converted, err := r.fromInterceptorRequestEventListener(u)
+	if err != nil {
+		t.Fatalf("Error converting eventlistener: %s", err)
+	}
+	if !reflect.DeepEqual(converted, el) {
+		t.Errorf("Eventlistener did not survive conversion")
+		t.Errorf("got: %+v", converted)
+		t.Errorf("expected: %+v", el)
+	}
+}
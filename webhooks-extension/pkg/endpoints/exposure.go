@@ -0,0 +1,248 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"fmt"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// How the eventlistener is exposed to the git provider, chosen with EVENTLISTENER_EXPOSURE
+const (
+	exposureIngress   = "ingress"
+	exposureRoute     = "route"
+	exposureHTTPRoute = "httproute"
+	// The operator exposes the eventlistener service themselves
+	exposureNone = "none"
+)
+
+// An eventListenerExposer makes the eventlistener's service reachable from outside the
+// cluster. expose is called when the eventlistener is created for the first webhook and
+// unexpose when it is deleted with the last.
+type eventListenerExposer interface {
+	expose(installNS string) error
+	unexpose(installNS string) error
+	// The object expose creates, for dry runs
+	object(installNS string) (interface{}, error)
+	// What is created, for messages
+	kind() string
+}
+
+// Returns the exposer for the configured exposure
+func (r Resource) eventListenerExposer() (eventListenerExposer, error) {
+	switch r.Defaults.Exposure {
+	case exposureIngress, "":
+		return ingressExposer{r}, nil
+	case exposureRoute:
+		return routeExposer{r}, nil
+	case exposureHTTPRoute:
+		return httpRouteExposer{r}, nil
+	case exposureNone:
+		return noExposer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown eventlistener exposure %s, expected %s, %s, %s or %s", r.Defaults.Exposure, exposureIngress, exposureRoute, exposureHTTPRoute, exposureNone)
+	}
+}
+
+// Chooses the exposure when EVENTLISTENER_EXPOSURE is not set: a Route on OpenShift,
+// recognised by PLATFORM being set, otherwise an Ingress
+func defaultExposure(exposure string, platformSet bool) string {
+	if exposure != "" {
+		return exposure
+	}
+	if platformSet {
+		return exposureRoute
+	}
+	return exposureIngress
+}
+
+type ingressExposer struct {
+	r Resource
+}
+
+func (e ingressExposer) expose(installNS string) error {
+	return e.r.createDeleteIngress("create", installNS)
+}
+
+func (e ingressExposer) unexpose(installNS string) error {
+	return e.r.createDeleteIngress("delete", installNS)
+}
+
+func (e ingressExposer) object(installNS string) (interface{}, error) {
+	host, path, err := e.r.ingressHostAndPath()
+	if err != nil {
+		return nil, err
+	}
+	if e.r.networkingV1IngressSupported() {
+		return e.r.newIngress(installNS, host, path), nil
+	}
+	return e.r.newLegacyIngress(installNS, host, path), nil
+}
+
+func (e ingressExposer) kind() string {
+	return "ingress"
+}
+
+type routeExposer struct {
+	r Resource
+}
+
+func (e routeExposer) expose(installNS string) error {
+	return e.r.createOpenshiftRoute(routeName)
+}
+
+func (e routeExposer) unexpose(installNS string) error {
+	return e.r.deleteOpenshiftRoute(routeName)
+}
+
+func (e routeExposer) object(installNS string) (interface{}, error) {
+	return newOpenshiftRoute(routeName), nil
+}
+
+func (e routeExposer) kind() string {
+	return "route"
+}
+
+type noExposer struct{}
+
+func (e noExposer) expose(installNS string) error {
+	logging.Log.Debugf("Not exposing the eventlistener, the exposure is %s", exposureNone)
+	return nil
+}
+
+func (e noExposer) unexpose(installNS string) error {
+	return nil
+}
+
+func (e noExposer) object(installNS string) (interface{}, error) {
+	return nil, nil
+}
+
+func (e noExposer) kind() string {
+	return "nothing"
+}
+
+/*
+	Gateway API HTTPRoute attached to the configured Gateway. The Gateway must allow
+	routes from the install namespace. The typed clients know nothing of the Gateway
+	API so the dynamic client is used, with the newest version the cluster serves.
+*/
+type httpRouteExposer struct {
+	r Resource
+}
+
+var httpRouteResources = []schema.GroupVersionResource{
+	{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"},
+	{Group: "gateway.networking.k8s.io", Version: "v1beta1", Resource: "httproutes"},
+}
+
+func (e httpRouteExposer) expose(installNS string) error {
+	route, err := e.object(installNS)
+	if err != nil {
+		return err
+	}
+	if _, err := e.r.DynamicClient.Resource(e.r.httpRouteResource()).Namespace(installNS).Create(route.(*unstructured.Unstructured), metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	logging.Log.Debug("HTTPRoute has been created")
+	return nil
+}
+
+func (e httpRouteExposer) unexpose(installNS string) error {
+	if err := e.r.DynamicClient.Resource(e.r.httpRouteResource()).Namespace(installNS).Delete(routeName, &metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	logging.Log.Debug("HTTPRoute has been deleted")
+	return nil
+}
+
+func (e httpRouteExposer) object(installNS string) (interface{}, error) {
+	if e.r.Defaults.GatewayName == "" {
+		return nil, fmt.Errorf("GATEWAY_NAME must be set to expose the eventlistener with an HTTPRoute")
+	}
+	host, path, err := e.r.ingressHostAndPath()
+	if err != nil {
+		return nil, err
+	}
+	return e.r.newHTTPRoute(e.r.httpRouteResource(), installNS, host, path), nil
+}
+
+func (e httpRouteExposer) kind() string {
+	return "httproute"
+}
+
+func (r Resource) httpRouteResource() schema.GroupVersionResource {
+	for _, resource := range httpRouteResources {
+		if r.servesResource(resource) {
+			return resource
+		}
+	}
+	return httpRouteResources[0]
+}
+
+// The HTTPRoute sending deliveries for the callback host and path to the eventlistener service
+func (r Resource) newHTTPRoute(resource schema.GroupVersionResource, installNS, host, path string) *unstructured.Unstructured {
+	parentRef := map[string]interface{}{"name": r.Defaults.GatewayName}
+	if r.Defaults.GatewayNamespace != "" {
+		parentRef["namespace"] = r.Defaults.GatewayNamespace
+	}
+	if r.Defaults.GatewaySectionName != "" {
+		parentRef["sectionName"] = r.Defaults.GatewaySectionName
+	}
+
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{parentRef},
+			"hostnames":  []interface{}{host},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"matches": []interface{}{
+						map[string]interface{}{
+							"path": map[string]interface{}{"type": "PathPrefix", "value": path},
+						},
+					},
+					"backendRefs": []interface{}{
+						map[string]interface{}{"name": routeName, "port": int64(ingressServicePort)},
+					},
+				},
+			},
+		},
+	}}
+	u.SetAPIVersion(resource.GroupVersion().String())
+	u.SetKind("HTTPRoute")
+	u.SetName(routeName)
+	u.SetNamespace(installNS)
+	return u
+}
+
+// Asks the API server whether it serves the resource
+func (r Resource) servesResource(resource schema.GroupVersionResource) bool {
+	if r.DynamicClient == nil {
+		return false
+	}
+	resources, err := r.K8sClient.Discovery().ServerResourcesForGroupVersion(resource.GroupVersion().String())
+	if err != nil || resources == nil {
+		return false
+	}
+	for _, served := range resources.APIResources {
+		if served.Name == resource.Resource {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDefaultExposure(t *testing.T) {
+	tests := []struct {
+		exposure    string
+		platformSet bool
+		expected    string
+	}{
+		{"", false, exposureIngress},
+		{"", true, exposureRoute},
+		{exposureHTTPRoute, true, exposureHTTPRoute},
+		{exposureNone, false, exposureNone},
+	}
+	for _, tt := range tests {
+		if exposure := defaultExposure(tt.exposure, tt.platformSet); exposure != tt.expected {
+			t.Errorf("Exposure for %q with PLATFORM set %t was %s, expected %s", tt.exposure, tt.platformSet, exposure, tt.expected)
+		}
+	}
+}
+
+func TestEventListenerExposer(t *testing.T) {
+	r := dummyResource()
+	tests := map[string]string{
+		"":                "ingress",
+		exposureIngress:   "ingress",
+		exposureRoute:     "route",
+		exposureHTTPRoute: "httproute",
+		exposureNone:      "nothing",
+	}
+	for exposure, expectedKind := range tests {
+		r.Defaults.Exposure = exposure
+		exposer, err := r.eventListenerExposer()
+		if err != nil {
+			t.Errorf("Unexpected error for exposure %s: %s", exposure, err)
+			continue
+		}
+		if exposer.kind() != expectedKind {
+			t.Errorf("Exposer for %s creates %s, expected %s", exposure, exposer.kind(), expectedKind)
+		}
+	}
+
+	r.Defaults.Exposure = "loadbalancer"
+	if _, err := r.eventListenerExposer(); err == nil {
+		t.Error("Expected an error for an unknown exposure")
+	}
+}
+
+func TestRouteExposer(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.Exposure = exposureRoute
+	exposer, _ := r.eventListenerExposer()
+	if err := exposer.expose(r.Defaults.Namespace); err != nil {
+		t.Fatalf("Unexpected error exposing eventlistener: %s", err)
+	}
+	if _, err := r.RoutesClient.RouteV1().Routes(r.Defaults.Namespace).Get(routeName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Route was not created: %s", err)
+	}
+	if err := exposer.unexpose(r.Defaults.Namespace); err != nil {
+		t.Errorf("Unexpected error removing route: %s", err)
+	}
+}
+
+func TestHTTPRouteExposer(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.Exposure = exposureHTTPRoute
+	r.Defaults.CallbackURL = "https://hooks.example.com"
+	k8sClient := fakek8sclientset.NewSimpleClientset()
+	k8sClient.Fake.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "gateway.networking.k8s.io/v1beta1", APIResources: []metav1.APIResource{{Name: "httproutes", Namespaced: true, Kind: "HTTPRoute"}}},
+	}
+	r.K8sClient = k8sClient
+	r.DynamicClient = fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	exposer, _ := r.eventListenerExposer()
+
+	if err := exposer.expose(installNs); err == nil {
+		t.Error("Expected an error exposing with an HTTPRoute when no gateway is configured")
+	}
+
+	r.Defaults.GatewayName = "external"
+	r.Defaults.GatewayNamespace = "gateways"
+	exposer, _ = r.eventListenerExposer()
+	if err := exposer.expose(installNs); err != nil {
+		t.Fatalf("Unexpected error exposing eventlistener: %s", err)
+	}
+	route, err := r.DynamicClient.Resource(httpRouteResources[1]).Namespace(installNs).Get(routeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("gateway.networking.k8s.io/v1beta1 HTTPRoute was not created: %s", err)
+	}
+	parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	parentRef := parentRefs[0].(map[string]interface{})
+	if parentRef["name"] != "external" || parentRef["namespace"] != "gateways" {
+		t.Errorf("Unexpected HTTPRoute parentRef %v", parentRef)
+	}
+	hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	if len(hostnames) != 1 || hostnames[0] != "hooks.example.com" {
+		t.Errorf("HTTPRoute hostnames were %v, expected hooks.example.com", hostnames)
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	backendRefs, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{}), "backendRefs")
+	if backendRefs[0].(map[string]interface{})["name"] != "el-"+eventListenerName {
+		t.Errorf("Unexpected HTTPRoute backendRefs %v", backendRefs)
+	}
+
+	if err := exposer.unexpose(installNs); err != nil {
+		t.Errorf("Unexpected error removing HTTPRoute: %s", err)
+	}
+}
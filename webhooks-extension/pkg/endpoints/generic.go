@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+)
+
+/*--------------------------------------
+Generic webhooks are for event sources other than a Git provider, such as container
+registries and artifact repositories. They have a single trigger, validated by the
+interceptor from an HMAC of the payload and a value in it identifying the source,
+rather than GitHub's signature and clone URL. No hook is created on the source, it
+is configured by the user with the eventlistener's URL and the credential's secret
+token, and there is no monitor trigger as there is no pull request to report on.
+---------------------------------------*/
+
+const (
+	webhookTypeGeneric   = "generic"
+	genericTriggerSuffix = "-generic-event"
+)
+
+var genericSignatureAlgorithms = []string{"sha1", "sha256", "sha512"}
+
+func isGeneric(hook webhook) bool {
+	return hook.Type == webhookTypeGeneric
+}
+
+// The webhooks that have a hook on the git provider
+func gitProviderHooks(hooks []webhook) []webhook {
+	gitHooks := []webhook{}
+	for _, hook := range hooks {
+		if !isGeneric(hook) {
+			gitHooks = append(gitHooks, hook)
+		}
+	}
+	return gitHooks
+}
+
+// The trigger for a generic webhook, binding with <pipeline>-generic-binding
+func (r Resource) newGenericTrigger(hook webhook) v1alpha1.EventListenerTrigger {
+	hookParams, _ := r.getParams(hook)
+	trigger := r.newTrigger(hook.Name+"-"+hook.Namespace+genericTriggerSuffix,
+		hook.Pipeline+"-generic-binding",
+		hook.Pipeline+"-template",
+		hook.GitRepositoryURL,
+		"",
+		hook.AccessTokenRef,
+		hookParams)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, getGenericHeaders(hook)...)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, r.getConcurrencyHeaders(hook)...)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, getSourceRangeHeaders(hook)...)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, getCredentialSyncHeaders(hook)...)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, getRetentionHeaders(hook)...)
+	return trigger
+}
+
+// Headers telling the interceptor how to validate deliveries to a generic webhook
+func getGenericHeaders(hook webhook) []pipelinesv1alpha1.Param {
+	return []pipelinesv1alpha1.Param{
+		{Name: "Wext-Webhook-Type", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: webhookTypeGeneric}},
+		{Name: "Wext-Signature-Header", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: hook.SignatureHeader}},
+		{Name: "Wext-Signature-Algorithm", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: hook.SignatureAlgorithm}},
+		{Name: "Wext-Identity-Path", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: hook.IdentityPath}},
+		{Name: "Wext-Identity-Value", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: hook.IdentityValue}},
+	}
+}
+
+// Sanitizes the validation settings of a generic webhook, returning every problem found
+func validateGenericSettings(hook *webhook) []error {
+	validationErrors := []error{}
+	hook.SignatureHeader = strings.TrimSpace(hook.SignatureHeader)
+	if hook.SignatureHeader == "" {
+		validationErrors = append(validationErrors, errors.New("a generic webhook requires the header carrying the payload signature"))
+	}
+	hook.SignatureAlgorithm = strings.ToLower(strings.TrimSpace(hook.SignatureAlgorithm))
+	if hook.SignatureAlgorithm == "" {
+		hook.SignatureAlgorithm = "sha256"
+	}
+	supported := false
+	for _, algorithm := range genericSignatureAlgorithms {
+		supported = supported || algorithm == hook.SignatureAlgorithm
+	}
+	if !supported {
+		validationErrors = append(validationErrors, fmt.Errorf("signature algorithm %s is not supported, use one of %s", hook.SignatureAlgorithm, strings.Join(genericSignatureAlgorithms, ", ")))
+	}
+	if hook.IdentityPath == "" || hook.IdentityValue == "" {
+		validationErrors = append(validationErrors, errors.New("a generic webhook requires an identity path into the payload and the value expected there"))
+	}
+	return validationErrors
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var genericHook = webhook{
+	Name:             "registry",
+	Namespace:        "pipelines",
+	GitRepositoryURL: "https://registry.example.com/project/app",
+	AccessTokenRef:   "token",
+	Pipeline:         "deploy",
+	Type:             webhookTypeGeneric,
+	SignatureHeader:  "X-Registry-Signature",
+	IdentityPath:     "$.event_data.repository.repo_full_name",
+	IdentityValue:    "project/app",
+}
+
+func createGenericBinding(hook webhook, r *Resource) {
+	createTriggerResources(hook, r)
+	binding := v1alpha1.TriggerBinding{ObjectMeta: metav1.ObjectMeta{Name: hook.Pipeline + "-generic-binding", Namespace: installNs}}
+	r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Create(&binding)
+}
+
+func TestCreateGenericWebhook(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	createGenericBinding(genericHook, r)
+
+	b, _ := json.Marshal(genericHook)
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/", bytes.NewBuffer(b))
+	httpWriter := httptest.NewRecorder()
+	r.createWebhook(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(httpWriter))
+	if httpWriter.Code != 201 {
+		t.Fatalf("Creation of a generic webhook returned %d: %s", httpWriter.Code, httpWriter.Body.String())
+	}
+
+	el, err := r.getEventListener(installNs)
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	if len(el.Spec.Triggers) != 1 || el.Spec.Triggers[0].Name != "registry-pipelines-generic-event" || el.Spec.Triggers[0].Binding.Name != "deploy-generic-binding" {
+		t.Errorf("Eventlistener had triggers %+v, expected only the generic trigger", el.Spec.Triggers)
+	}
+
+	hooks, _ := r.getWebhooksFromEventListener()
+	expected := genericHook
+	expected.SignatureAlgorithm = "sha256"
+	expected.ReleaseName = "app"
+	if len(hooks) != 1 || hooks[0] != expected {
+		t.Errorf("Webhooks read from the eventlistener were %+v, expected %+v", hooks, expected)
+	}
+}
+
+func TestValidateGenericWebhook(t *testing.T) {
+	r := dummyResource()
+	createGenericBinding(genericHook, r)
+
+	hook := genericHook
+	hook.SignatureHeader = ""
+	hook.SignatureAlgorithm = "md5"
+	hook.IdentityValue = ""
+	if _, validationErrors := r.validateWebhook(&hook); len(validationErrors) != 3 {
+		t.Errorf("Validation returned %v, expected errors for the signature header, algorithm and identity", validationErrors)
+	}
+
+	hook = genericHook
+	hook.Type = "registry"
+	if _, validationErrors := r.validateWebhook(&hook); len(validationErrors) != 1 {
+		t.Errorf("Validation returned %v, expected an error for the unsupported type", validationErrors)
+	}
+}
+
+func TestRemainingTriggersWithGenericWebhookOnRepository(t *testing.T) {
+	r := dummyResource()
+	gitHook := genericHook
+	gitHook.Name = "git"
+	gitHook.Type = ""
+	gitHook.PullTask = "monitor-task"
+	monitorTriggerName := "registry.example.com/project/app"
+	triggers := append(r.webhookTriggers(gitHook, monitorTriggerName), r.newGenericTrigger(genericHook))
+
+	remaining := remainingTriggers(triggers, "git-pipelines", monitorTriggerName, gitHook.GitRepositoryURL)
+	if len(remaining) != 1 || remaining[0].Name != "registry-pipelines-generic-event" {
+		t.Errorf("Removing the git webhook left %+v, expected only the generic trigger", remaining)
+	}
+
+	remaining = remainingTriggers(triggers, "registry-pipelines", monitorTriggerName, genericHook.GitRepositoryURL)
+	if len(remaining) != 3 || !containsTrigger(remaining, monitorTriggerName) {
+		t.Errorf("Removing the generic webhook left %+v, expected the git webhook's triggers", remaining)
+	}
+}
@@ -31,6 +31,17 @@ type GitProvider interface {
 	AddWebhook(hook webhook) error
 	DeleteWebhook(hook GitWebhook) error
 	GetAllWebhooks() ([]GitWebhook, error)
+	// The webhook AddWebhook would create, without secrets
+	WebhookPayload(hook webhook) interface{}
+	// The branch, the default branch if none is given, and the SHA of its head commit
+	HeadCommit(branch string) (string, string, error)
+	// Sets a status on a commit, shown on the pull requests it is the head of
+	SetCommitStatus(sha string, status commitStatus) error
+	// Adds a comment to a pull request
+	AddPullRequestComment(number int, body string) error
+	// Creates a check run on a commit, returning its ID
+	CreateCheckRun(sha string, run checkRun) (int64, error)
+	UpdateCheckRun(id int64, run checkRun) error
 }
 
 // AddWebhook : attempts to add a webhook
@@ -18,8 +18,10 @@ import (
 	github "github.com/google/go-github/github"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	utils "github.com/tektoncd/experimental/webhooks-extension/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net/url"
 	"os"
+	"time"
 )
 
 type GitHub struct {
@@ -38,7 +40,7 @@ type GitHubWebhook struct {
 // GitHub GitProvider ----------------------------------------------------------------------------------------------------
 func (r Resource) initGitHub(sslVerify bool, apiURL, secret, org, repo string) (*GitHub, error) {
 	// Access token is stored as 'accessToken' and secret as 'secretToken'
-	accessToken, _, err := utils.GetWebhookSecretTokens(r.K8sClient, r.Defaults.Namespace, secret)
+	accessToken, _, err := r.CredentialStore.GetTokens(secret)
 	if err != nil {
 		return nil, err
 	}
@@ -59,10 +61,21 @@ func (r Resource) initGitHub(sslVerify bool, apiURL, secret, org, repo string) (
 }
 
 func (gh GitHub) AddWebhook(hook webhook) error {
-	_, secretToken, err := utils.GetWebhookSecretTokens(gh.Resource.K8sClient, gh.Resource.Defaults.Namespace, hook.AccessTokenRef)
+	_, secretToken, err := gh.Resource.CredentialStore.GetTokens(hook.AccessTokenRef)
 	if err != nil {
 		return err
 	}
+	// Create webhook
+	_, _, err = gh.Client.Repositories.CreateHook(gh.Context, gh.Org, gh.Repo, gh.newHook(secretToken))
+	return err
+}
+
+// The hook AddWebhook creates, with the secret token left out
+func (gh GitHub) WebhookPayload(hook webhook) interface{} {
+	return gh.newHook("<secretToken of " + hook.AccessTokenRef + ">")
+}
+
+func (gh GitHub) newHook(secretToken string) *github.Hook {
 	ssl := 0
 	if !gh.SSLVerify {
 		ssl = 1
@@ -76,16 +89,130 @@ func (gh GitHub) AddWebhook(hook webhook) error {
 	cfg["content_type"] = "json"
 	events := []string{"push", "pull_request"}
 	active := true
-	hookDefinition := &github.Hook{
+	return &github.Hook{
 		Config: cfg,
 		Events: events,
 		Active: &active,
 	}
-	// Create webhook
-	_, _, err = gh.Client.Repositories.CreateHook(gh.Context, gh.Org, gh.Repo, hookDefinition)
+}
+
+func (gh GitHub) HeadCommit(branch string) (string, string, error) {
+	if branch == "" {
+		repository, _, err := gh.Client.Repositories.Get(gh.Context, gh.Org, gh.Repo)
+		if err != nil {
+			return "", "", err
+		}
+		branch = repository.GetDefaultBranch()
+	}
+	ghBranch, _, err := gh.Client.Repositories.GetBranch(gh.Context, gh.Org, gh.Repo, branch)
+	if err != nil {
+		return "", "", err
+	}
+	return branch, ghBranch.GetCommit().GetSHA(), nil
+}
+
+func (gh GitHub) SetCommitStatus(sha string, status commitStatus) error {
+	_, _, err := gh.Client.Repositories.CreateStatus(gh.Context, gh.Org, gh.Repo, sha, &github.RepoStatus{
+		State:       github.String(status.State),
+		Description: github.String(status.Description),
+		TargetURL:   github.String(status.TargetURL),
+		Context:     github.String(status.Context),
+	})
+	return err
+}
+
+func (gh GitHub) AddPullRequestComment(number int, body string) error {
+	_, _, err := gh.Client.Issues.CreateComment(gh.Context, gh.Org, gh.Repo, number, &github.IssueComment{Body: github.String(body)})
 	return err
 }
 
+// A client authenticated as the GitHub App's installation on the repository, as check runs need
+func (gh GitHub) checksClient() (*github.Client, error) {
+	app, err := checksGitHubApp()
+	if err != nil {
+		return nil, err
+	}
+	token, err := app.installationToken(gh.Client.BaseURL.String(), gh.Org, gh.Repo, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	client := github.NewClient(utils.CreateOAuth2Client(gh.Context, token))
+	client.BaseURL = gh.Client.BaseURL
+	return client, nil
+}
+
+func (gh GitHub) CreateCheckRun(sha string, run checkRun) (int64, error) {
+	client, err := gh.checksClient()
+	if err != nil {
+		return 0, err
+	}
+	created, _, err := client.Checks.CreateCheckRun(gh.Context, gh.Org, gh.Repo, github.CreateCheckRunOptions{
+		Name:        run.Name,
+		HeadSHA:     sha,
+		DetailsURL:  github.String(run.DetailsURL),
+		ExternalID:  github.String(run.ExternalID),
+		Status:      github.String(run.Status),
+		Conclusion:  optionalString(run.Conclusion),
+		StartedAt:   gitHubTimestamp(run.StartedAt),
+		CompletedAt: gitHubTimestamp(run.CompletedAt),
+		Output:      gitHubCheckRunOutput(run),
+		Actions:     gitHubCheckRunActions(run),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return created.GetID(), nil
+}
+
+func (gh GitHub) UpdateCheckRun(id int64, run checkRun) error {
+	client, err := gh.checksClient()
+	if err != nil {
+		return err
+	}
+	_, _, err = client.Checks.UpdateCheckRun(gh.Context, gh.Org, gh.Repo, id, github.UpdateCheckRunOptions{
+		Name:        run.Name,
+		DetailsURL:  github.String(run.DetailsURL),
+		ExternalID:  github.String(run.ExternalID),
+		Status:      github.String(run.Status),
+		Conclusion:  optionalString(run.Conclusion),
+		CompletedAt: gitHubTimestamp(run.CompletedAt),
+		Output:      gitHubCheckRunOutput(run),
+		Actions:     gitHubCheckRunActions(run),
+	})
+	return err
+}
+
+func gitHubCheckRunOutput(run checkRun) *github.CheckRunOutput {
+	return &github.CheckRunOutput{
+		Title:   github.String(run.Title),
+		Summary: github.String(run.Summary),
+		Text:    optionalString(run.Text),
+	}
+}
+
+func gitHubCheckRunActions(run checkRun) []*github.CheckRunAction {
+	actions := []*github.CheckRunAction{}
+	for _, action := range run.Actions {
+		actions = append(actions, &github.CheckRunAction{Label: action.Label, Description: action.Description, Identifier: action.Identifier})
+	}
+	return actions
+}
+
+func gitHubTimestamp(t *metav1.Time) *github.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return &github.Timestamp{Time: t.Time}
+}
+
+// Empty strings are left out of requests
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return github.String(s)
+}
+
 func (gh GitHub) DeleteWebhook(hook GitWebhook) error {
 	_, err := gh.Client.Repositories.DeleteHook(gh.Context, gh.Org, gh.Repo, int64(hook.GetID()))
 	return err
@@ -0,0 +1,161 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------
+Check runs can only be created by a GitHub App, so they are reported with an
+installation token of the app configured by GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY
+rather than the webhook's access token. The app authenticates with a JWT signed by its
+private key to look up its installation on the repository and create a token for it.
+Installation tokens last an hour and are cached per repository until shortly before
+they expire.
+---------------------------------------*/
+
+const (
+	// The JWT is backdated to allow for clock drift, and GitHub accepts at most ten minutes of validity
+	appJWTBackdate = 60 * time.Second
+	appJWTLifetime = 9 * time.Minute
+	// Installation tokens are replaced this long before they expire
+	installationTokenMargin = 5 * time.Minute
+
+	gitHubAppAcceptHeader = "application/vnd.github.machine-man-preview+json"
+)
+
+type gitHubApp struct {
+	id  string
+	key *rsa.PrivateKey
+
+	mutex  sync.Mutex
+	tokens map[string]installationToken
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	checksApp     *gitHubApp
+	checksAppErr  error
+	checksAppOnce sync.Once
+)
+
+// The GitHub App check runs are reported with, read from the environment on first use
+func checksGitHubApp() (*gitHubApp, error) {
+	checksAppOnce.Do(func() {
+		checksApp, checksAppErr = newGitHubApp(os.Getenv("GITHUB_APP_ID"), os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+	})
+	return checksApp, checksAppErr
+}
+
+func newGitHubApp(id, privateKey string) (*gitHubApp, error) {
+	if id == "" || privateKey == "" {
+		return nil, errors.New("check runs need a GitHub App, set GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY")
+	}
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("the GitHub App's private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if pkcs8Err != nil || !ok {
+			return nil, fmt.Errorf("error reading the GitHub App's private key: %s", err)
+		}
+		key = rsaKey
+	}
+	return &gitHubApp{id: id, key: key, tokens: map[string]installationToken{}}, nil
+}
+
+// A JWT identifying the app, signed with RS256
+func (app *gitHubApp) jwt(now time.Time) (string, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-appJWTBackdate).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": app.id,
+	})
+	unsigned := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, app.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + encode(signature), nil
+}
+
+// An installation token for the repository, from the cache while it has long enough left
+func (app *gitHubApp) installationToken(apiURL, org, repo string, now time.Time) (string, error) {
+	key := apiURL + org + "/" + repo
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	if token, found := app.tokens[key]; found && now.Add(installationTokenMargin).Before(token.ExpiresAt) {
+		return token.Token, nil
+	}
+
+	jwt, err := app.jwt(now)
+	if err != nil {
+		return "", err
+	}
+	installation := struct {
+		ID int64 `json:"id"`
+	}{}
+	if err := gitHubAppRequest("GET", apiURL+"repos/"+org+"/"+repo+"/installation", jwt, http.StatusOK, &installation); err != nil {
+		return "", fmt.Errorf("error finding the GitHub App's installation on %s/%s: %s", org, repo, err)
+	}
+	token := installationToken{}
+	if err := gitHubAppRequest("POST", fmt.Sprintf("%sapp/installations/%d/access_tokens", apiURL, installation.ID), jwt, http.StatusCreated, &token); err != nil {
+		return "", fmt.Errorf("error creating an installation token for %s/%s: %s", org, repo, err)
+	}
+	app.tokens[key] = token
+	return token.Token, nil
+}
+
+func gitHubAppRequest(method, url, jwt string, expectedStatus int, result interface{}) error {
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Accept", gitHubAppAcceptHeader)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != expectedStatus {
+		return fmt.Errorf("%s %s returned %d", method, strings.TrimSuffix(url, "/"), response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testGitHubApp(t *testing.T) *gitHubApp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating a private key: %s", err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	app, err := newGitHubApp("1234", string(privateKey))
+	if err != nil {
+		t.Fatalf("Error reading the GitHub App: %s", err)
+	}
+	return app
+}
+
+func TestGitHubAppJWT(t *testing.T) {
+	app := testGitHubApp(t)
+	now := time.Now()
+	jwt, err := app.jwt(now)
+	if err != nil {
+		t.Fatalf("Error signing a JWT: %s", err)
+	}
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT %s does not have three parts", jwt)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&app.key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("JWT signature did not verify: %s", err)
+	}
+	claims := struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}{}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(payload, &claims)
+	if claims.Issuer != "1234" || claims.IssuedAt >= now.Unix() || claims.ExpiresAt-now.Unix() > 600 {
+		t.Errorf("JWT claims were %+v", claims)
+	}
+
+	if _, err := newGitHubApp("", ""); err == nil {
+		t.Error("Expected an error reading a GitHub App without an ID or private key")
+	}
+}
+
+func TestGitHubAppInstallationToken(t *testing.T) {
+	app := testGitHubApp(t)
+	now := time.Now()
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/repos/owner/repo/installation":
+			fmt.Fprint(w, `{"id":42}`)
+		case "/app/installations/42/access_tokens":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token":"token-%d","expires_at":%q}`, len(requests), now.Add(time.Hour).Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	token, err := app.installationToken(server.URL+"/", "owner", "repo", now)
+	if err != nil || token != "token-2" {
+		t.Fatalf("Installation token was %s with error %v, expected token-2", token, err)
+	}
+	if token, _ := app.installationToken(server.URL+"/", "owner", "repo", now.Add(30*time.Minute)); token != "token-2" || len(requests) != 2 {
+		t.Errorf("Installation token was %s after %d requests, expected the cached token", token, len(requests))
+	}
+	if token, _ := app.installationToken(server.URL+"/", "owner", "repo", now.Add(56*time.Minute)); token != "token-4" {
+		t.Errorf("Installation token about to expire was not replaced, token was %s", token)
+	}
+	if _, err := app.installationToken(server.URL+"/", "owner", "other", now); err == nil {
+		t.Error("Expected an error for a repository the app is not installed on")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	ingressName          = "el-" + eventListenerName
+	ingressServicePort   = 8080
+	defaultIngressPath   = "/"
+	legacyIngressClass   = "kubernetes.io/ingress.class"
+	networkingV1Resource = "ingresses"
+)
+
+var ingressResource = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: networkingV1Resource}
+
+// The eventlistener is exposed with a networking.k8s.io/v1 Ingress when the cluster
+// serves that API, otherwise with the extensions/v1beta1 Ingress older clusters have.
+// The typed clients only know about extensions/v1beta1, so the dynamic client is used
+// for networking.k8s.io/v1.
+func (r Resource) createIngress(installNS string) error {
+	host, path, err := r.ingressHostAndPath()
+	if err != nil {
+		return err
+	}
+	if r.networkingV1IngressSupported() {
+		_, err = r.DynamicClient.Resource(ingressResource).Namespace(installNS).Create(r.newIngress(installNS, host, path), metav1.CreateOptions{})
+	} else {
+		_, err = r.K8sClient.ExtensionsV1beta1().Ingresses(installNS).Create(r.newLegacyIngress(installNS, host, path))
+	}
+	if err != nil {
+		return err
+	}
+	logging.Log.Debug("Ingress has been created")
+	return nil
+}
+
+func (r Resource) deleteIngress(installNS string) error {
+	var err error
+	if r.networkingV1IngressSupported() {
+		err = r.DynamicClient.Resource(ingressResource).Namespace(installNS).Delete(ingressName, &metav1.DeleteOptions{})
+	} else {
+		err = r.K8sClient.ExtensionsV1beta1().Ingresses(installNS).Delete(ingressName, &metav1.DeleteOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	logging.Log.Debug("Ingress has been deleted")
+	return nil
+}
+
+// Asks the API server whether it serves networking.k8s.io/v1 Ingresses
+func (r Resource) networkingV1IngressSupported() bool {
+	if !r.servesResource(ingressResource) {
+		logging.Log.Debugf("%s is not served, using extensions/v1beta1 Ingresses", ingressResource.GroupVersion().String())
+		return false
+	}
+	return true
+}
+
+// The host the webhooks call and the path routed to the eventlistener. The path is
+// INGRESS_PATH if set, otherwise the path of the callback URL, otherwise "/".
+func (r Resource) ingressHostAndPath() (string, string, error) {
+	callback := r.Defaults.CallbackURL
+	if !strings.Contains(callback, "://") {
+		// Unlike webhook creation, the ingress does not need a protocol specified
+		callback = "http://" + callback
+	}
+	callbackURL, err := url.Parse(callback)
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing the webhook callback URL %s: %s", r.Defaults.CallbackURL, err)
+	}
+	path := r.Defaults.IngressPath
+	if path == "" {
+		path = callbackURL.Path
+	}
+	if path == "" {
+		path = defaultIngressPath
+	}
+	return callbackURL.Hostname(), path, nil
+}
+
+// The networking.k8s.io/v1 Ingress for the eventlistener
+func (r Resource) newIngress(installNS, host, path string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"rules": []interface{}{
+			map[string]interface{}{
+				"host": host,
+				"http": map[string]interface{}{
+					"paths": []interface{}{
+						map[string]interface{}{
+							"path":     path,
+							"pathType": "Prefix",
+							"backend": map[string]interface{}{
+								"service": map[string]interface{}{
+									"name": ingressName,
+									"port": map[string]interface{}{"number": int64(ingressServicePort)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if r.Defaults.IngressClass != "" {
+		spec["ingressClassName"] = r.Defaults.IngressClass
+	}
+	if r.Defaults.IngressTLSSecret != "" {
+		spec["tls"] = []interface{}{
+			map[string]interface{}{
+				"hosts":      []interface{}{host},
+				"secretName": r.Defaults.IngressTLSSecret,
+			},
+		}
+	}
+
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	u.SetAPIVersion(ingressResource.GroupVersion().String())
+	u.SetKind("Ingress")
+	u.SetName(ingressName)
+	u.SetNamespace(installNS)
+	if len(r.Defaults.IngressAnnotations) > 0 {
+		u.SetAnnotations(r.Defaults.IngressAnnotations)
+	}
+	return u
+}
+
+// The extensions/v1beta1 Ingress for the eventlistener, which has no ingressClassName
+// so the class is given with the kubernetes.io/ingress.class annotation
+func (r Resource) newLegacyIngress(installNS, host, path string) *v1beta1.Ingress {
+	annotations := map[string]string{}
+	for key, value := range r.Defaults.IngressAnnotations {
+		annotations[key] = value
+	}
+	if r.Defaults.IngressClass != "" {
+		annotations[legacyIngressClass] = r.Defaults.IngressClass
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ingressName,
+			Namespace:   installNS,
+			Annotations: annotations,
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{
+								{
+									Path: path,
+									Backend: v1beta1.IngressBackend{
+										ServiceName: ingressName,
+										ServicePort: intstr.IntOrString{
+											Type:   intstr.Int,
+											IntVal: ingressServicePort,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if r.Defaults.IngressTLSSecret != "" {
+		ingress.Spec.TLS = []v1beta1.IngressTLS{{Hosts: []string{host}, SecretName: r.Defaults.IngressTLSSecret}}
+	}
+	return ingress
+}
+
+// Parses INGRESS_ANNOTATIONS, a JSON object of annotation names to values
+func parseIngressAnnotations(annotations string) (map[string]string, error) {
+	if strings.TrimSpace(annotations) == "" {
+		return nil, nil
+	}
+	parsed := map[string]string{}
+	if err := json.Unmarshal([]byte(annotations), &parsed); err != nil {
+		return nil, fmt.Errorf("INGRESS_ANNOTATIONS must be a JSON object of annotation names to values: %s", err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIngressHostAndPath(t *testing.T) {
+	tests := []struct {
+		callback     string
+		ingressPath  string
+		expectedHost string
+		expectedPath string
+	}{
+		{"http://listener.10.0.0.1.nip.io", "", "listener.10.0.0.1.nip.io", "/"},
+		{"listener.10.0.0.1.nip.io", "", "listener.10.0.0.1.nip.io", "/"},
+		{"https://hooks.example.com:443/tekton", "", "hooks.example.com", "/tekton"},
+		{"https://hooks.example.com/tekton", "/webhooks", "hooks.example.com", "/webhooks"},
+	}
+	for _, tt := range tests {
+		r := dummyResource()
+		r.Defaults.CallbackURL = tt.callback
+		r.Defaults.IngressPath = tt.ingressPath
+		host, path, err := r.ingressHostAndPath()
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", tt.callback, err)
+			continue
+		}
+		if host != tt.expectedHost || path != tt.expectedPath {
+			t.Errorf("Host and path for %s were %s and %s, expected %s and %s", tt.callback, host, path, tt.expectedHost, tt.expectedPath)
+		}
+	}
+}
+
+func TestNewIngress(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.IngressClass = "nginx"
+	r.Defaults.IngressTLSSecret = "listener-tls"
+	r.Defaults.IngressAnnotations = map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt"}
+
+	u := r.newIngress(installNs, "hooks.example.com", "/")
+	if u.GetAPIVersion() != "networking.k8s.io/v1" || u.GetKind() != "Ingress" {
+		t.Errorf("Ingress was %s %s, expected networking.k8s.io/v1 Ingress", u.GetAPIVersion(), u.GetKind())
+	}
+	if !reflect.DeepEqual(u.GetAnnotations(), r.Defaults.IngressAnnotations) {
+		t.Errorf("Ingress annotations were %v, expected %v", u.GetAnnotations(), r.Defaults.IngressAnnotations)
+	}
+	if class, _, _ := unstructured.NestedString(u.Object, "spec", "ingressClassName"); class != "nginx" {
+		t.Errorf("Ingress class was %s, expected nginx", class)
+	}
+	tls, _, _ := unstructured.NestedSlice(u.Object, "spec", "tls")
+	if len(tls) != 1 || tls[0].(map[string]interface{})["secretName"] != "listener-tls" {
+		t.Errorf("Unexpected ingress TLS %v", tls)
+	}
+	rules, _, _ := unstructured.NestedSlice(u.Object, "spec", "rules")
+	paths, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{}), "http", "paths")
+	service, _, _ := unstructured.NestedString(paths[0].(map[string]interface{}), "backend", "service", "name")
+	port, _, _ := unstructured.NestedInt64(paths[0].(map[string]interface{}), "backend", "service", "port", "number")
+	if service != "el-"+eventListenerName || port != 8080 {
+		t.Errorf("Ingress backend was %s:%d, expected el-%s:8080", service, port, eventListenerName)
+	}
+}
+
+func TestNewLegacyIngress(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.IngressClass = "nginx"
+	r.Defaults.IngressTLSSecret = "listener-tls"
+	r.Defaults.IngressAnnotations = map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt"}
+
+	ingress := r.newLegacyIngress(installNs, "hooks.example.com", "/")
+	expectedAnnotations := map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt", "kubernetes.io/ingress.class": "nginx"}
+	if !reflect.DeepEqual(ingress.Annotations, expectedAnnotations) {
+		t.Errorf("Ingress annotations were %v, expected %v", ingress.Annotations, expectedAnnotations)
+	}
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "listener-tls" || ingress.Spec.TLS[0].Hosts[0] != "hooks.example.com" {
+		t.Errorf("Unexpected ingress TLS %v", ingress.Spec.TLS)
+	}
+	// The configured annotations must not be changed by adding the class
+	if _, found := r.Defaults.IngressAnnotations["kubernetes.io/ingress.class"]; found {
+		t.Error("The ingress class annotation was added to the configured annotations")
+	}
+}
+
+func TestCreateIngressFallsBackToExtensionsV1beta1(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.CallbackURL = "http://listener.10.0.0.1.nip.io"
+	if err := r.createIngress(installNs); err != nil {
+		t.Fatalf("Unexpected error creating ingress: %s", err)
+	}
+	ingress, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-"+eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("extensions/v1beta1 Ingress was not created: %s", err)
+	}
+	if ingress.Spec.Rules[0].Host != "listener.10.0.0.1.nip.io" {
+		t.Errorf("Ingress host was %s, expected listener.10.0.0.1.nip.io", ingress.Spec.Rules[0].Host)
+	}
+	if err := r.deleteIngress(installNs); err != nil {
+		t.Errorf("Unexpected error deleting ingress: %s", err)
+	}
+}
+
+func TestCreateNetworkingV1Ingress(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.CallbackURL = "https://hooks.example.com"
+	k8sClient := fakek8sclientset.NewSimpleClientset()
+	k8sClient.Fake.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "networking.k8s.io/v1", APIResources: []metav1.APIResource{{Name: "ingresses", Namespaced: true, Kind: "Ingress"}}},
+	}
+	r.K8sClient = k8sClient
+	r.DynamicClient = fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+
+	if err := r.createIngress(installNs); err != nil {
+		t.Fatalf("Unexpected error creating ingress: %s", err)
+	}
+	if _, err := r.DynamicClient.Resource(ingressResource).Namespace(installNs).Get("el-"+eventListenerName, metav1.GetOptions{}); err != nil {
+		t.Errorf("networking.k8s.io/v1 Ingress was not created: %s", err)
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-"+eventListenerName, metav1.GetOptions{}); err == nil {
+		t.Error("An extensions/v1beta1 Ingress should not be created when networking.k8s.io/v1 is served")
+	}
+	if err := r.deleteIngress(installNs); err != nil {
+		t.Errorf("Unexpected error deleting ingress: %s", err)
+	}
+}
+
+func TestParseIngressAnnotations(t *testing.T) {
+	annotations, err := parseIngressAnnotations(`{"cert-manager.io/cluster-issuer": "letsencrypt"}`)
+	if err != nil || annotations["cert-manager.io/cluster-issuer"] != "letsencrypt" {
+		t.Errorf("Unexpected annotations %v, error %v", annotations, err)
+	}
+	if annotations, err := parseIngressAnnotations(""); err != nil || annotations != nil {
+		t.Errorf("Expected no annotations, got %v and %v", annotations, err)
+	}
+	if _, err := parseIngressAnnotations("cert-manager.io/cluster-issuer=letsencrypt"); err == nil {
+		t.Error("Expected an error for annotations that are not JSON")
+	}
+}
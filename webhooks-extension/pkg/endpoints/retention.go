@@ -0,0 +1,275 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+/*--------------------------------------
+A webhook can have a retention policy for its PipelineRuns: keep the last KeepRuns
+completed runs of each branch, and delete completed runs older than MaxRunAge. The
+last successful run of each branch is always kept, and runs that have not completed
+are never deleted. Each replica prunes on an interval, deleting a run another replica
+already deleted is not an error.
+
+The monitor TaskRuns and pull-request PipelineResources created in the install
+namespace for pull requests are removed once no PipelineRun remains for the delivery
+they were created for.
+---------------------------------------*/
+
+const (
+	// Set by Tekton on TaskRuns, naming their task
+	taskLabel = "tekton.dev/task"
+	// Monitor TaskRuns are kept this long after completing, even once their delivery has no PipelineRuns
+	monitorRetentionGrace = time.Hour
+)
+
+// Parses a run age, a duration such as "36h" or a number of days such as "7d"
+func parseRunAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("maximum run age %s is not a number of days or a duration", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, fmt.Errorf("maximum run age %s is not a number of days or a duration", age)
+	}
+	return duration, nil
+}
+
+func validateRetention(hook webhook) []error {
+	validationErrors := []error{}
+	if hook.KeepRuns < 0 {
+		validationErrors = append(validationErrors, errors.New("the number of runs to keep cannot be negative"))
+	}
+	if hook.MaxRunAge != "" {
+		if age, err := parseRunAge(hook.MaxRunAge); err != nil {
+			validationErrors = append(validationErrors, err)
+		} else if age <= 0 {
+			validationErrors = append(validationErrors, fmt.Errorf("maximum run age %s must be positive", hook.MaxRunAge))
+		}
+	}
+	return validationErrors
+}
+
+func hasRetention(hook webhook) bool {
+	return hook.KeepRuns > 0 || hook.MaxRunAge != ""
+}
+
+/*
+	Headers recording the webhook's retention policy, which the extension enforces.
+	The interceptor ignores them.
+*/
+func getRetentionHeaders(webhook webhook) []pipelinesv1alpha1.Param {
+	if !hasRetention(webhook) {
+		return nil
+	}
+	return []pipelinesv1alpha1.Param{
+		{Name: "Wext-Keep-Runs", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: strconv.Itoa(webhook.KeepRuns)}},
+		{Name: "Wext-Max-Run-Age", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: webhook.MaxRunAge}},
+	}
+}
+
+// PruneRuns enforces the retention policies of webhooks on the interval, until the process exits
+func (r Resource) PruneRuns(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		r.pruneRuns(time.Now())
+	}
+}
+
+func (r Resource) pruneRuns(now time.Time) {
+	hooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		logging.Log.Errorf("error getting webhooks to prune their runs: %s", err)
+		return
+	}
+	for _, hook := range hooks {
+		if !hasRetention(hook) {
+			continue
+		}
+		if err := r.pruneWebhookRuns(hook, now); err != nil {
+			logging.Log.Errorf("error pruning the runs of webhook %s: %s", hook.Name, err)
+		}
+	}
+	if err := r.pruneMonitorRuns(hooks, now); err != nil {
+		logging.Log.Errorf("error pruning monitor TaskRuns: %s", err)
+	}
+}
+
+// Deletes the webhook's completed PipelineRuns its retention policy does not keep
+func (r Resource) pruneWebhookRuns(hook webhook, now time.Time) error {
+	// Runs may carry the git labels with or without the webhooks.tekton.dev/ prefix, so select on
+	// the label Tekton adds and leave matching the repository to runsToPrune
+	selector := "tekton.dev/pipeline=" + hook.Pipeline
+	pipelineRuns, err := r.TektonClient.TektonV1alpha1().PipelineRuns(hook.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range runsToPrune(hook, pipelineRuns.Items, now) {
+		err := r.TektonClient.TektonV1alpha1().PipelineRuns(hook.Namespace).Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		logging.Log.Infof("Pruned PipelineRun %s of webhook %s", name, hook.Name)
+	}
+	return nil
+}
+
+// The names of the webhook's completed runs beyond the last KeepRuns of their branch, or older than MaxRunAge
+func runsToPrune(hook webhook, pipelineRuns []pipelinesv1alpha1.PipelineRun, now time.Time) []string {
+	var maxAge time.Duration
+	if hook.MaxRunAge != "" {
+		maxAge, _ = parseRunAge(hook.MaxRunAge)
+	}
+
+	byBranch := map[string][]pipelinesv1alpha1.PipelineRun{}
+	for _, pipelineRun := range pipelineRuns {
+		if !pipelineRunForWebhook(pipelineRun, hook.GitRepositoryURL, hook.Pipeline) {
+			continue
+		}
+		if pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsUnknown() {
+			continue
+		}
+		branch := gitLabel(pipelineRun.GetLabels(), "gitBranch")
+		byBranch[branch] = append(byBranch[branch], pipelineRun)
+	}
+
+	toPrune := []string{}
+	for _, runs := range byBranch {
+		sort.Slice(runs, func(i, j int) bool {
+			return runs[j].CreationTimestamp.Before(&runs[i].CreationTimestamp)
+		})
+		keptSuccess := false
+		for i, pipelineRun := range runs {
+			if !keptSuccess && pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue() {
+				keptSuccess = true
+				continue
+			}
+			tooMany := hook.KeepRuns > 0 && i >= hook.KeepRuns
+			tooOld := maxAge > 0 && now.Sub(completedAt(pipelineRun)) > maxAge
+			if tooMany || tooOld {
+				toPrune = append(toPrune, pipelineRun.Name)
+			}
+		}
+	}
+	sort.Strings(toPrune)
+	return toPrune
+}
+
+// When the run completed, or was created if that is not recorded
+func completedAt(pipelineRun pipelinesv1alpha1.PipelineRun) time.Time {
+	if pipelineRun.Status.CompletionTime != nil {
+		return pipelineRun.Status.CompletionTime.Time
+	}
+	return pipelineRun.CreationTimestamp.Time
+}
+
+/*
+	Deletes the completed monitor TaskRuns in the install namespace, and the
+	pull-request PipelineResources created with them, for deliveries that no longer
+	have PipelineRuns. Deliveries are identified by the event ID label Tekton Triggers
+	sets on each resource it creates.
+*/
+func (r Resource) pruneMonitorRuns(hooks []webhook, now time.Time) error {
+	taskRuns, err := r.TektonClient.TektonV1alpha1().TaskRuns(r.Defaults.Namespace).List(metav1.ListOptions{LabelSelector: triggersEventIDLabel})
+	if err != nil {
+		return err
+	}
+	pullTasks := map[string]bool{"monitor-task": true}
+	for _, hook := range hooks {
+		if hook.PullTask != "" {
+			pullTasks[hook.PullTask] = true
+		}
+	}
+
+	var pipelineRunEvents map[string]bool
+	for _, taskRun := range taskRuns.Items {
+		if !pullTasks[taskRun.Labels[taskLabel]] || taskRun.Status.GetCondition(apis.ConditionSucceeded).IsUnknown() {
+			continue
+		}
+		completed := taskRun.CreationTimestamp.Time
+		if taskRun.Status.CompletionTime != nil {
+			completed = taskRun.Status.CompletionTime.Time
+		}
+		if now.Sub(completed) < monitorRetentionGrace {
+			continue
+		}
+		if pipelineRunEvents == nil {
+			if pipelineRunEvents, err = r.pipelineRunEventIDs(); err != nil {
+				return err
+			}
+		}
+		eventID := taskRun.Labels[triggersEventIDLabel]
+		if pipelineRunEvents[eventID] {
+			continue
+		}
+		if err := r.deleteMonitorRun(taskRun.Name, eventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The event IDs of the deliveries that PipelineRuns in any namespace were created for
+func (r Resource) pipelineRunEventIDs() (map[string]bool, error) {
+	pipelineRuns, err := r.TektonClient.TektonV1alpha1().PipelineRuns("").List(metav1.ListOptions{LabelSelector: triggersEventIDLabel})
+	if err != nil {
+		return nil, err
+	}
+	eventIDs := map[string]bool{}
+	for _, pipelineRun := range pipelineRuns.Items {
+		eventIDs[pipelineRun.Labels[triggersEventIDLabel]] = true
+	}
+	return eventIDs, nil
+}
+
+func (r Resource) deleteMonitorRun(taskRunName, eventID string) error {
+	resources := r.TektonClient.TektonV1alpha1().PipelineResources(r.Defaults.Namespace)
+	pipelineResources, err := resources.List(metav1.ListOptions{LabelSelector: triggersEventIDLabel + "=" + eventID})
+	if err != nil {
+		return err
+	}
+	for _, pipelineResource := range pipelineResources.Items {
+		if !strings.HasPrefix(pipelineResource.Name, "pull-request-") {
+			continue
+		}
+		if err := resources.Delete(pipelineResource.Name, &metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		logging.Log.Infof("Pruned PipelineResource %s", pipelineResource.Name)
+	}
+	err = r.TektonClient.TektonV1alpha1().TaskRuns(r.Defaults.Namespace).Delete(taskRunName, &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	logging.Log.Infof("Pruned monitor TaskRun %s", taskRunName)
+	return nil
+}
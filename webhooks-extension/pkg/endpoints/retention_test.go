@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+func TestRunsToPrune(t *testing.T) {
+	now := time.Now()
+	hook := runsHook
+	hook.KeepRuns = 2
+	pipelineRuns := []pipelinesv1alpha1.PipelineRun{
+		*webhookPipelineRun("master-1", "pipeline", "master", now.Add(-5*time.Hour), corev1.ConditionTrue, "Succeeded"),
+		*webhookPipelineRun("master-2", "pipeline", "master", now.Add(-4*time.Hour), corev1.ConditionFalse, "Failed"),
+		*webhookPipelineRun("master-3", "pipeline", "master", now.Add(-3*time.Hour), corev1.ConditionTrue, "Succeeded"),
+		*webhookPipelineRun("master-4", "pipeline", "master", now.Add(-2*time.Hour), corev1.ConditionFalse, "Failed"),
+		*webhookPipelineRun("master-5", "pipeline", "master", now.Add(-time.Hour), corev1.ConditionFalse, "Failed"),
+		*webhookPipelineRun("master-running", "pipeline", "master", now.Add(-10*time.Hour), corev1.ConditionUnknown, "Running"),
+		*webhookPipelineRun("feature-1", "pipeline", "feature", now.Add(-6*time.Hour), corev1.ConditionFalse, "Failed"),
+		*webhookPipelineRun("other-pipeline", "other", "master", now.Add(-10*time.Hour), corev1.ConditionFalse, "Failed"),
+	}
+
+	// master-3 is kept as the last successful run of master
+	if toPrune := runsToPrune(hook, pipelineRuns, now); !reflect.DeepEqual(toPrune, []string{"master-1", "master-2"}) {
+		t.Errorf("Runs to prune keeping 2 per branch were %v", toPrune)
+	}
+
+	hook.KeepRuns = 0
+	hook.MaxRunAge = "150m"
+	if toPrune := runsToPrune(hook, pipelineRuns, now); !reflect.DeepEqual(toPrune, []string{"feature-1", "master-1", "master-2"}) {
+		t.Errorf("Runs to prune older than 150m were %v", toPrune)
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	for _, age := range []string{"7d", "36h", "90m"} {
+		hook := runsHook
+		hook.MaxRunAge = age
+		if validationErrors := validateRetention(hook); len(validationErrors) != 0 {
+			t.Errorf("Maximum run age %s returned validation errors %v", age, validationErrors)
+		}
+	}
+	for _, age := range []string{"d", "7 days", "-1h", "0d"} {
+		hook := runsHook
+		hook.MaxRunAge = age
+		if validationErrors := validateRetention(hook); len(validationErrors) != 1 {
+			t.Errorf("Maximum run age %s returned validation errors %v, expected 1", age, validationErrors)
+		}
+	}
+	hook := runsHook
+	hook.KeepRuns = -1
+	if validationErrors := validateRetention(hook); len(validationErrors) != 1 {
+		t.Errorf("Negative runs to keep returned validation errors %v, expected 1", validationErrors)
+	}
+}
+
+func TestPruneRuns(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	r.TektonClient = fakeclientset.NewSimpleClientset()
+	hook := runsHook
+	hook.KeepRuns = 1
+	if _, err := r.createEventListener(hook, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	hooks, _ := r.getWebhooksFromEventListener()
+	if len(hooks) != 1 || hooks[0].KeepRuns != 1 {
+		t.Fatalf("Webhooks read from the eventlistener were %+v, expected one keeping 1 run", hooks)
+	}
+
+	now := time.Now()
+	// Runs created by older releases carry the git labels without the webhooks.tekton.dev/ prefix
+	unprefixed := webhookPipelineRun("older", "pipeline", "master", now.Add(-3*time.Hour), corev1.ConditionFalse, "Failed")
+	for _, name := range []string{"gitServer", "gitOrg", "gitRepo", "gitBranch"} {
+		unprefixed.Labels[name] = unprefixed.Labels["webhooks.tekton.dev/"+name]
+		delete(unprefixed.Labels, "webhooks.tekton.dev/"+name)
+	}
+	for _, pipelineRun := range []*pipelinesv1alpha1.PipelineRun{
+		unprefixed,
+		webhookPipelineRun("old", "pipeline", "master", now.Add(-2*time.Hour), corev1.ConditionFalse, "Failed"),
+		webhookPipelineRun("new", "pipeline", "master", now.Add(-time.Hour), corev1.ConditionFalse, "Failed"),
+	} {
+		r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(pipelineRun)
+	}
+	for _, eventID := range []string{"old-event", "new-event"} {
+		monitorRun := &pipelinesv1alpha1.TaskRun{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "monitor-taskrun-" + eventID,
+				Namespace:         installNs,
+				CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+				Labels:            map[string]string{taskLabel: "monitor-task", triggersEventIDLabel: eventID},
+			},
+		}
+		monitorRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionTrue})
+		r.TektonClient.TektonV1alpha1().TaskRuns(installNs).Create(monitorRun)
+		r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Create(&pipelinesv1alpha1.PipelineResource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pull-request-" + eventID,
+				Namespace: installNs,
+				Labels:    map[string]string{triggersEventIDLabel: eventID},
+			},
+		})
+	}
+
+	r.pruneRuns(now)
+
+	pipelineRuns, _ := r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").List(metav1.ListOptions{})
+	if len(pipelineRuns.Items) != 1 || pipelineRuns.Items[0].Name != "new" {
+		t.Errorf("PipelineRuns after pruning were %+v, expected only the newest", pipelineRuns.Items)
+	}
+	taskRuns, _ := r.TektonClient.TektonV1alpha1().TaskRuns(installNs).List(metav1.ListOptions{})
+	if len(taskRuns.Items) != 1 || taskRuns.Items[0].Name != "monitor-taskrun-new-event" {
+		t.Errorf("Monitor TaskRuns after pruning were %+v, expected only the one for the remaining run", taskRuns.Items)
+	}
+	resources, _ := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).List(metav1.ListOptions{})
+	if len(resources.Items) != 1 || resources.Items[0].Name != "pull-request-new-event" {
+		t.Errorf("PipelineResources after pruning were %+v, expected only the one for the remaining run", resources.Items)
+	}
+}
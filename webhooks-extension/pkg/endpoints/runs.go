@@ -0,0 +1,247 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+/*--------------------------------------
+The PipelineRuns of a webhook are those of its pipeline in its namespace labelled
+with its repository, as described in docs/Labels.md. They are listed newest first,
+a page at a time.
+---------------------------------------*/
+
+const (
+	defaultRunsPageSize = 20
+	maxRunsPageSize     = 100
+
+	runStateRunning   = "running"
+	runStateSucceeded = "succeeded"
+	runStateFailed    = "failed"
+	runStateCancelled = "cancelled"
+
+	// Set by Tekton Triggers on the resources created for a delivery
+	triggersEventIDLabel = "tekton.dev/triggers-eventid"
+)
+
+var runStates = []string{runStateRunning, runStateSucceeded, runStateFailed, runStateCancelled}
+
+type webhookRun struct {
+	Name           string       `json:"name"`
+	Namespace      string       `json:"namespace"`
+	State          string       `json:"state"`
+	Reason         string       `json:"reason,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	Branch         string       `json:"branch,omitempty"`
+	SHA            string       `json:"sha,omitempty"`
+	Event          string       `json:"event,omitempty"`
+	EventID        string       `json:"eventID,omitempty"`
+}
+
+type webhookRunPage struct {
+	Total int          `json:"total"`
+	Page  int          `json:"page"`
+	Limit int          `json:"limit"`
+	Runs  []webhookRun `json:"runs"`
+}
+
+// Lists a page of the PipelineRuns of a webhook, optionally only those for a branch or in a state
+func (r Resource) getWebhookRuns(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	repo := request.QueryParameter("repository")
+	namespace := request.QueryParameter("namespace")
+	branch := request.QueryParameter("branch")
+	state := strings.ToLower(request.QueryParameter("state"))
+
+	if namespace == "" || repo == "" {
+		theError := errors.New("bad request information provided, a namespace and a repository must be specified as query parameters")
+		logging.Log.Error(theError)
+		RespondError(response, theError, http.StatusBadRequest)
+		return
+	}
+	if state != "" && !containsRunState(state) {
+		theError := fmt.Errorf("bad request information provided, state must be one of %s", strings.Join(runStates, ", "))
+		logging.Log.Error(theError)
+		RespondError(response, theError, http.StatusBadRequest)
+		return
+	}
+	page, err := positiveQueryParameter(request, "page", 1)
+	if err != nil {
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	limit, err := positiveQueryParameter(request, "limit", defaultRunsPageSize)
+	if err == nil && limit > maxRunsPageSize {
+		err = fmt.Errorf("bad request information provided, limit cannot be more than %d", maxRunsPageSize)
+	}
+	if err != nil {
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	r.respondWebhookRuns(response, name, namespace, repo, branch, state, page, limit)
+}
+
+func (r Resource) respondWebhookRuns(response *restful.Response, name, namespace, repo, branch, state string, page, limit int) {
+	webhooks, err := r.getHooksForRepo(repo)
+	if err != nil {
+		RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	var hook *webhook
+	for i := range webhooks {
+		if webhooks[i].Name == name && webhooks[i].Namespace == namespace {
+			hook = &webhooks[i]
+		}
+	}
+	if hook == nil {
+		err := fmt.Errorf("no webhook found with name %s associated with namespace %s and repository %s", name, namespace, repo)
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	pipelineRuns, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		logging.Log.Errorf("Unable to retrieve PipelineRuns in the namespace %s! Error: %s", namespace, err.Error())
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(pipelineRuns.Items, func(i, j int) bool {
+		return pipelineRuns.Items[j].CreationTimestamp.Before(&pipelineRuns.Items[i].CreationTimestamp)
+	})
+
+	runs := []webhookRun{}
+	for _, pipelineRun := range pipelineRuns.Items {
+		if !pipelineRunForWebhook(pipelineRun, repo, hook.Pipeline) {
+			continue
+		}
+		run := newWebhookRun(pipelineRun)
+		if (branch == "" || run.Branch == branch) && (state == "" || run.State == state) {
+			runs = append(runs, run)
+		}
+	}
+
+	result := webhookRunPage{Total: len(runs), Page: page, Limit: limit, Runs: []webhookRun{}}
+	if start := (page - 1) * limit; start < len(runs) {
+		end := start + limit
+		if end > len(runs) {
+			end = len(runs)
+		}
+		result.Runs = runs[start:end]
+	}
+	response.WriteEntity(result)
+}
+
+/*
+	Whether the PipelineRun is of the pipeline and labelled with the repository, as
+	described in docs/Labels.md. Runs labelled without the webhooks.tekton.dev/ prefix,
+	as by earlier versions of the documentation, are matched too.
+*/
+func pipelineRunForWebhook(pipelineRun pipelinesv1alpha1.PipelineRun, gitRepoURL, pipeline string) bool {
+	if pipelineRun.Spec.PipelineRef.Name != pipeline {
+		return false
+	}
+	labels := pipelineRun.GetLabels()
+	foundRepoURL := fmt.Sprintf("https://%s/%s/%s", gitLabel(labels, "gitServer"), gitLabel(labels, "gitOrg"), gitLabel(labels, "gitRepo"))
+
+	gitRepoURL = strings.ToLower(strings.TrimSuffix(gitRepoURL, ".git"))
+	foundRepoURL = strings.ToLower(strings.TrimSuffix(foundRepoURL, ".git"))
+	return foundRepoURL == gitRepoURL
+}
+
+func gitLabel(labels map[string]string, name string) string {
+	if value, found := labels["webhooks.tekton.dev/"+name]; found {
+		return value
+	}
+	return labels[name]
+}
+
+/*
+	The run's state, times and what triggered it. The commit and event come from the
+	optional webhooks.tekton.dev/gitCommit and webhooks.tekton.dev/gitEvent labels, or
+	else the gitrevision and event-type params of the example pipelines.
+*/
+func newWebhookRun(pipelineRun pipelinesv1alpha1.PipelineRun) webhookRun {
+	labels := pipelineRun.GetLabels()
+	run := webhookRun{
+		Name:           pipelineRun.Name,
+		Namespace:      pipelineRun.Namespace,
+		StartTime:      pipelineRun.Status.StartTime,
+		CompletionTime: pipelineRun.Status.CompletionTime,
+		Branch:         gitLabel(labels, "gitBranch"),
+		SHA:            labels["webhooks.tekton.dev/gitCommit"],
+		Event:          labels["webhooks.tekton.dev/gitEvent"],
+		EventID:        labels[triggersEventIDLabel],
+	}
+	for _, param := range pipelineRun.Spec.Params {
+		if param.Name == "gitrevision" && run.SHA == "" {
+			run.SHA = param.Value.StringVal
+		}
+		if param.Name == "event-type" && run.Event == "" {
+			run.Event = param.Value.StringVal
+		}
+	}
+
+	condition := pipelineRun.Status.GetCondition(apis.ConditionSucceeded)
+	switch {
+	case condition == nil || condition.IsUnknown():
+		run.State = runStateRunning
+	case condition.IsTrue():
+		run.State = runStateSucceeded
+	case condition.Reason == "PipelineRunCancelled":
+		run.State = runStateCancelled
+	default:
+		run.State = runStateFailed
+	}
+	if condition != nil {
+		run.Reason = condition.Reason
+	}
+	return run
+}
+
+func containsRunState(state string) bool {
+	for _, runState := range runStates {
+		if state == runState {
+			return true
+		}
+	}
+	return false
+}
+
+func positiveQueryParameter(request *restful.Request, name string, defaultValue int) (int, error) {
+	value := request.QueryParameter(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		return 0, fmt.Errorf("bad request information provided, %s must be a positive number", name)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+var runsHook = webhook{
+	Name:             "runs",
+	Namespace:        "pipelines",
+	GitRepositoryURL: "https://github.com/owner/repo",
+	AccessTokenRef:   "token",
+	Pipeline:         "pipeline",
+	PullTask:         "monitor-task",
+}
+
+func webhookPipelineRun(name, pipeline, branch string, created time.Time, status corev1.ConditionStatus, reason string) *pipelinesv1alpha1.PipelineRun {
+	pipelineRun := &pipelinesv1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "pipelines",
+			CreationTimestamp: metav1.NewTime(created),
+			Labels: map[string]string{
+				"webhooks.tekton.dev/gitServer": "github.com",
+				"webhooks.tekton.dev/gitOrg":    "owner",
+				"webhooks.tekton.dev/gitRepo":   "repo",
+				"webhooks.tekton.dev/gitBranch": branch,
+				triggersEventIDLabel:            name + "-event",
+				"tekton.dev/pipeline":           pipeline,
+			},
+		},
+		Spec: pipelinesv1alpha1.PipelineRunSpec{
+			PipelineRef: pipelinesv1alpha1.PipelineRef{Name: pipeline},
+			Params: []pipelinesv1alpha1.Param{
+				{Name: "gitrevision", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "1234567890abcdef"}},
+				{Name: "event-type", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "push"}},
+			},
+		},
+	}
+	pipelineRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: status, Reason: reason})
+	return pipelineRun
+}
+
+func getRuns(r *Resource, query string, t *testing.T) (int, webhookRunPage) {
+	httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/runs/runs?repository=https://github.com/owner/repo&namespace=pipelines"+query, nil)
+	httpWriter := httptest.NewRecorder()
+	r.getWebhookRuns(dummyRestfulRequest(httpReq, "runs"), dummyRestfulResponse(httpWriter))
+	page := webhookRunPage{}
+	if httpWriter.Code == http.StatusOK {
+		if err := json.NewDecoder(httpWriter.Body).Decode(&page); err != nil {
+			t.Fatalf("Error decoding runs: %s", err)
+		}
+	}
+	return httpWriter.Code, page
+}
+
+func TestGetWebhookRuns(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	if _, err := r.createEventListener(runsHook, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	now := time.Now()
+	pipelineRuns := []*pipelinesv1alpha1.PipelineRun{
+		webhookPipelineRun("oldest", "pipeline", "master", now.Add(-3*time.Hour), corev1.ConditionTrue, "Succeeded"),
+		webhookPipelineRun("failed", "pipeline", "feature", now.Add(-2*time.Hour), corev1.ConditionFalse, "Failed"),
+		webhookPipelineRun("cancelled", "pipeline", "master", now.Add(-time.Hour), corev1.ConditionFalse, "PipelineRunCancelled"),
+		webhookPipelineRun("newest", "pipeline", "master", now, corev1.ConditionUnknown, "Running"),
+		webhookPipelineRun("other-pipeline", "other", "master", now, corev1.ConditionUnknown, "Running"),
+	}
+	otherRepo := webhookPipelineRun("other-repo", "pipeline", "master", now, corev1.ConditionUnknown, "Running")
+	otherRepo.Labels["webhooks.tekton.dev/gitRepo"] = "other"
+	pipelineRuns = append(pipelineRuns, otherRepo)
+	for _, pipelineRun := range pipelineRuns {
+		if _, err := r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(pipelineRun); err != nil {
+			t.Fatalf("Error creating PipelineRun: %s", err)
+		}
+	}
+
+	status, page := getRuns(r, "", t)
+	if status != http.StatusOK || page.Total != 4 || len(page.Runs) != 4 {
+		t.Fatalf("Got status %d and %+v, expected the webhook's four runs", status, page)
+	}
+	expectedStates := map[string]string{"newest": runStateRunning, "cancelled": runStateCancelled, "failed": runStateFailed, "oldest": runStateSucceeded}
+	for i, name := range []string{"newest", "cancelled", "failed", "oldest"} {
+		run := page.Runs[i]
+		if run.Name != name || run.State != expectedStates[name] {
+			t.Errorf("Run %d was %s in state %s, expected %s in state %s", i, run.Name, run.State, name, expectedStates[name])
+		}
+		if run.SHA != "1234567890abcdef" || run.Event != "push" || run.EventID != name+"-event" {
+			t.Errorf("Run %s had commit %s, event %s and event ID %s", run.Name, run.SHA, run.Event, run.EventID)
+		}
+	}
+
+	if _, page := getRuns(r, "&branch=master&state=succeeded", t); page.Total != 1 || page.Runs[0].Name != "oldest" {
+		t.Errorf("Filtered runs were %+v, expected the succeeded run on master", page.Runs)
+	}
+	if _, page := getRuns(r, "&page=2&limit=3", t); page.Total != 4 || len(page.Runs) != 1 || page.Runs[0].Name != "oldest" {
+		t.Errorf("Second page of runs was %+v, expected the oldest run", page.Runs)
+	}
+	if _, page := getRuns(r, "&page=3&limit=3", t); len(page.Runs) != 0 {
+		t.Errorf("Page beyond the runs was %+v, expected no runs", page.Runs)
+	}
+
+	for _, query := range []string{"&state=done", "&page=0", "&limit=a", "&limit=101"} {
+		if status, _ := getRuns(r, query, t); status != http.StatusBadRequest {
+			t.Errorf("Query %s returned status %d, expected %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPipelineRunForWebhookWithUnprefixedLabels(t *testing.T) {
+	pipelineRun := webhookPipelineRun("unprefixed", "pipeline", "master", time.Now(), corev1.ConditionTrue, "Succeeded")
+	pipelineRun.Labels = map[string]string{"gitServer": "github.com", "gitOrg": "owner", "gitRepo": "repo"}
+	if !pipelineRunForWebhook(*pipelineRun, "https://github.com/Owner/repo.git", "pipeline") {
+		t.Error("Expected a run labelled without the webhooks.tekton.dev/ prefix to match")
+	}
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	github "github.com/google/go-github/github"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/*--------------------------------------
+A webhook can have cron schedules on which its pipeline is run as if its branch had
+been pushed to. The head of the branch is read from the git provider and a push
+delivery for it, signed with the webhook's secret token, is sent to the eventlistener
+so that it is validated, enriched and bound exactly as one from the provider. The
+delivery names the webhook's push trigger so that other webhooks on the repository
+ignore it. Each replica checks the schedules every minute, and the minute a webhook
+was last run for is recorded in a ConfigMap so that only one replica runs it.
+---------------------------------------*/
+
+const (
+	// Set on the ConfigMaps recording when a webhook's schedules last ran
+	webhookScheduleLabel = "webhooks.tekton.dev/schedule"
+	// Sent with scheduled and replayed deliveries, naming the only triggers, comma separated,
+	// that should process them
+	targetTriggersHeader = "X-Webhooks-Tekton-Trigger"
+	// Sent with scheduled deliveries, holding the schedule that was due
+	scheduleHeader = "X-Webhooks-Tekton-Schedule"
+)
+
+// Where scheduled deliveries are sent, the eventlistener's service
+var eventListenerURL = func(installNs string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", routeName, installNs, ingressServicePort)
+}
+
+// Sends deliveries to the eventlistener, with a timeout so that one that does not answer
+// cannot hold up the schedules due after it
+var eventListenerClient = &http.Client{Timeout: 10 * time.Second}
+
+// The minutes, hours, days of the month, months and days of the week a cron schedule is due on
+type cronSchedule struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek map[int]bool
+	// Whether the days of the month and of the week were restricted, as when both
+	// are then a day matching either is due
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Splits a webhook's schedules, which are separated by semicolons or newlines
+func splitSchedules(schedules string) []string {
+	split := []string{}
+	for _, schedule := range strings.FieldsFunc(schedules, func(r rune) bool { return r == ';' || r == '\n' }) {
+		if schedule = strings.TrimSpace(schedule); schedule != "" {
+			split = append(split, schedule)
+		}
+	}
+	return split
+}
+
+/*
+	Parses a standard five field cron expression, minute hour day-of-month month
+	day-of-week, or one of the @ shorthands. Fields are lists of *, single values and
+	ranges, each optionally with a /step. Sunday is 0 or 7.
+*/
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	if shorthand, ok := cronShorthands[strings.ToLower(expression)]; ok {
+		expression = shorthand
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q does not have five fields", expression)
+	}
+	schedule := &cronSchedule{}
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("schedule %q has an invalid minute: %s", expression, err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("schedule %q has an invalid hour: %s", expression, err)
+	}
+	if schedule.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("schedule %q has an invalid day of the month: %s", expression, err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("schedule %q has an invalid month: %s", expression, err)
+	}
+	if schedule.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("schedule %q has an invalid day of the week: %s", expression, err)
+	}
+	if schedule.daysOfWeek[7] {
+		schedule.daysOfWeek[0] = true
+	}
+	schedule.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("step %s is not a positive number", part[slash+1:])
+			}
+			part = part[:slash]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("%s is not a number", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("%s is not a number", bounds[1])
+				}
+			} else if step > 1 {
+				// A single value with a step runs to the end of the range, as 5/15 is 5-59/15
+				high = max
+			}
+			if low < min || high > max || low > high {
+				return nil, fmt.Errorf("%s is outside %d-%d", part, min, max)
+			}
+		}
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Whether the schedule is due in the minute starting at t
+func (s *cronSchedule) due(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+	dayOfMonth := s.daysOfMonth[t.Day()]
+	dayOfWeek := s.daysOfWeek[int(t.Weekday())]
+	if !s.anyDayOfMonth && !s.anyDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+// Checks the webhook's schedules parse, and that it can have them
+func validateSchedules(hook webhook) []error {
+	validationErrors := []error{}
+	schedules := splitSchedules(hook.Schedules)
+	if len(schedules) > 0 && isGeneric(hook) {
+		validationErrors = append(validationErrors, errors.New("schedules are not supported for generic webhooks, which have no branch to run for"))
+	}
+	for _, schedule := range schedules {
+		if _, err := parseCronSchedule(schedule); err != nil {
+			validationErrors = append(validationErrors, err)
+		}
+	}
+	return validationErrors
+}
+
+// Runs the schedules of every webhook at the start of each minute, in UTC
+func (r Resource) RunSchedules() {
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(next.Sub(now))
+		r.runSchedules(next)
+	}
+}
+
+// Runs the webhooks with a schedule due in the given minute
+func (r Resource) runSchedules(minute time.Time) {
+	hooks, err := r.getWebhooksFromEventListener()
+	if err != nil {
+		logging.Log.Errorf("error getting webhooks to run their schedules: %s", err)
+		return
+	}
+	scheduled := map[string]bool{}
+	for _, hook := range hooks {
+		schedules := splitSchedules(hook.Schedules)
+		if len(schedules) == 0 {
+			continue
+		}
+		scheduled[scheduleRecordName(hook)] = true
+		for _, expression := range schedules {
+			schedule, err := parseCronSchedule(expression)
+			if err != nil {
+				logging.Log.Errorf("error parsing schedule %q of webhook %s: %s", expression, hook.Name, err)
+				continue
+			}
+			if !schedule.due(minute) {
+				continue
+			}
+			claimed, err := r.claimScheduledRun(hook, minute)
+			if err != nil {
+				logging.Log.Errorf("error recording the scheduled run of webhook %s: %s", hook.Name, err)
+			}
+			if claimed {
+				if err := r.runScheduled(hook, expression); err != nil {
+					logging.Log.Errorf("error running schedule %q of webhook %s: %s", expression, hook.Name, err)
+				}
+			}
+			// A webhook is run once a minute however many of its schedules are due
+			break
+		}
+	}
+	r.removeUnusedScheduleRecords(scheduled)
+}
+
+func scheduleRecordName(hook webhook) string {
+	return "webhook-schedule-" + hook.Name + "-" + hook.Namespace
+}
+
+/*
+	Records that the webhook is run for the minute, returning false if it already was,
+	by this or another replica. An update of the record conflicts if another replica
+	wrote it first.
+*/
+func (r Resource) claimScheduledRun(hook webhook, minute time.Time) (bool, error) {
+	configMaps := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace)
+	record, err := configMaps.Get(scheduleRecordName(hook), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		record = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      scheduleRecordName(hook),
+				Namespace: r.Defaults.Namespace,
+				Labels:    map[string]string{webhookScheduleLabel: "true"},
+			},
+			Data: map[string]string{"lastRun": minute.Format(time.RFC3339)},
+		}
+		if _, err := configMaps.Create(record); err != nil {
+			if k8serrors.IsAlreadyExists(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if lastRun, err := time.Parse(time.RFC3339, record.Data["lastRun"]); err == nil && !lastRun.Before(minute) {
+		return false, nil
+	}
+	if record.Data == nil {
+		record.Data = map[string]string{}
+	}
+	record.Data["lastRun"] = minute.Format(time.RFC3339)
+	if _, err := configMaps.Update(record); err != nil {
+		if k8serrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Deletes the records of webhooks that no longer have schedules
+func (r Resource) removeUnusedScheduleRecords(scheduled map[string]bool) {
+	records, err := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace).List(metav1.ListOptions{LabelSelector: webhookScheduleLabel})
+	if err != nil {
+		logging.Log.Errorf("error listing webhook schedule records: %s", err)
+		return
+	}
+	for _, record := range records.Items {
+		if scheduled[record.Name] {
+			continue
+		}
+		err := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace).Delete(record.Name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logging.Log.Errorf("error deleting webhook schedule record %s: %s", record.Name, err)
+		}
+	}
+}
+
+// Sends a push delivery for the head of the webhook's schedule branch to the eventlistener
+func (r Resource) runScheduled(hook webhook, schedule string) error {
+	_, gitOwner, gitRepo, err := getGitValues(hook.GitRepositoryURL)
+	if err != nil {
+		return err
+	}
+	gitProvider, err := r.createGitProviderForWebhook(hook, gitOwner, gitRepo)
+	if err != nil {
+		return err
+	}
+	branch, sha, err := gitProvider.HeadCommit(hook.ScheduleBranch)
+	if err != nil {
+		return fmt.Errorf("error getting the head of branch %q: %s", hook.ScheduleBranch, err)
+	}
+	logging.Log.Infof("Running webhook %s for schedule %q at %s of branch %s", hook.Name, schedule, sha, branch)
+	return r.sendScheduledDelivery(hook, schedule, scheduledPushEvent(hook, gitOwner, gitRepo, branch, sha))
+}
+
+// The push event the git provider would send for the branch being pushed to the commit
+func scheduledPushEvent(hook webhook, gitOwner, gitRepo, branch, sha string) *github.PushEvent {
+	ref := "refs/heads/" + branch
+	cloneURL := hook.GitRepositoryURL + ".git"
+	fullName := gitOwner + "/" + gitRepo
+	message := "Scheduled run"
+	return &github.PushEvent{
+		Ref:    &ref,
+		After:  &sha,
+		Before: &sha,
+		HeadCommit: &github.PushEventCommit{
+			ID:      &sha,
+			Message: &message,
+		},
+		Repo: &github.PushEventRepository{
+			Name:     &gitRepo,
+			FullName: &fullName,
+			CloneURL: &cloneURL,
+			HTMLURL:  &hook.GitRepositoryURL,
+			Owner:    &github.User{Login: &gitOwner, Name: &gitOwner},
+		},
+	}
+}
+
+// Signs the event with the webhook's secret token and sends it to the eventlistener
+func (r Resource) sendScheduledDelivery(hook webhook, schedule string, event *github.PushEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, secretToken, err := r.CredentialStore.GetTokens(hook.AccessTokenRef)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha1.New, []byte(secretToken))
+	mac.Write(payload)
+
+	request, err := http.NewRequest(http.MethodPost, eventListenerURL(r.Defaults.Namespace), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Github-Event", "push")
+	request.Header.Set("X-Github-Delivery", fmt.Sprintf("scheduled-%s-%s-%d", hook.Name, hook.Namespace, time.Now().Unix()))
+	request.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	request.Header.Set(targetTriggersHeader, hook.Name+"-"+hook.Namespace+"-push-event")
+	request.Header.Set(scheduleHeader, schedule)
+
+	response, err := eventListenerClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("eventlistener returned %d for the scheduled delivery", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	github "github.com/google/go-github/github"
+)
+
+var scheduledHook = webhook{
+	Name:             "nightly",
+	Namespace:        "pipelines",
+	GitRepositoryURL: "https://github.com/owner/repo",
+	AccessTokenRef:   "token",
+	Pipeline:         "pipeline",
+	PullTask:         "monitor-task",
+	Schedules:        "0 2 * * *;30 4 * * 1-5",
+	ScheduleBranch:   "release",
+}
+
+func TestCronScheduleDue(t *testing.T) {
+	// A Monday
+	monday := time.Date(2019, time.December, 2, 2, 0, 0, 0, time.UTC)
+	tests := []struct {
+		schedule string
+		at       time.Time
+		due      bool
+	}{
+		{"0 2 * * *", monday, true},
+		{"0 2 * * *", monday.Add(time.Minute), false},
+		{"*/15 * * * *", monday.Add(45 * time.Minute), true},
+		{"*/15 * * * *", monday.Add(50 * time.Minute), false},
+		{"5/15 * * * *", monday.Add(20 * time.Minute), true},
+		{"0 1-3 * * 1-5", monday, true},
+		{"0 2 * * 0,6", monday, false},
+		{"0 2 * * 7", monday.AddDate(0, 0, 6), true},
+		{"0 2 1 * *", monday, false},
+		// Restricted days of the month and week are due on either
+		{"0 2 1 * 1", monday, true},
+		{"0 2 2 12 *", monday, true},
+		{"@daily", monday.Add(-2 * time.Hour), true},
+		{"@hourly", monday, true},
+	}
+	for _, test := range tests {
+		schedule, err := parseCronSchedule(test.schedule)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", test.schedule, err)
+			continue
+		}
+		if due := schedule.due(test.at); due != test.due {
+			t.Errorf("Schedule %s due at %s was %t, expected %t", test.schedule, test.at, due, test.due)
+		}
+	}
+}
+
+func TestValidateSchedules(t *testing.T) {
+	for _, schedules := range []string{"0 2 * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		hook := scheduledHook
+		hook.Schedules = schedules
+		if validationErrors := validateSchedules(hook); len(validationErrors) != 1 {
+			t.Errorf("Schedules %q returned validation errors %v, expected 1", schedules, validationErrors)
+		}
+	}
+	hook := genericHook
+	hook.Schedules = "@daily"
+	if validationErrors := validateSchedules(hook); len(validationErrors) != 1 {
+		t.Errorf("Generic webhook with a schedule returned validation errors %v, expected 1", validationErrors)
+	}
+	if splitSchedules(" 0 2 * * *;\n@daily\n") == nil || len(splitSchedules(" 0 2 * * *;\n@daily\n")) != 2 {
+		t.Errorf("Schedules were not split on semicolons and newlines, got %q", splitSchedules(" 0 2 * * *;\n@daily\n"))
+	}
+}
+
+func TestSchedulesReadFromEventListener(t *testing.T) {
+	os.Setenv("SERVICE_ACCOUNT", "tekton-test-service-account")
+	r := dummyResource()
+	if _, err := r.createEventListener(scheduledHook, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	hooks, _ := r.getWebhooksFromEventListener()
+	if len(hooks) != 1 || hooks[0].Schedules != scheduledHook.Schedules || hooks[0].ScheduleBranch != "release" {
+		t.Errorf("Webhooks read from the eventlistener were %+v, expected one with its schedules", hooks)
+	}
+}
+
+func TestClaimScheduledRun(t *testing.T) {
+	r := dummyResource()
+	minute := time.Date(2019, time.December, 2, 2, 0, 0, 0, time.UTC)
+	for i, expected := range []bool{true, false} {
+		if claimed, err := r.claimScheduledRun(scheduledHook, minute); err != nil || claimed != expected {
+			t.Errorf("Claim %d returned %t with error %v, expected %t", i, claimed, err, expected)
+		}
+	}
+	if claimed, err := r.claimScheduledRun(scheduledHook, minute.Add(time.Minute)); err != nil || !claimed {
+		t.Errorf("Claim for the next minute returned %t with error %v, expected to be claimed", claimed, err)
+	}
+
+	// The record is removed once the webhook no longer has schedules
+	r.removeUnusedScheduleRecords(map[string]bool{})
+	if claimed, _ := r.claimScheduledRun(scheduledHook, minute.Add(time.Minute)); !claimed {
+		t.Error("Schedule record was not removed")
+	}
+}
+
+func TestSendScheduledDelivery(t *testing.T) {
+	r := dummyResource()
+	createTriggerResources(scheduledHook, r)
+
+	var received *http.Request
+	var body []byte
+	eventListener := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received = request
+		body, _ = ioutil.ReadAll(request.Body)
+		writer.WriteHeader(http.StatusCreated)
+	}))
+	defer eventListener.Close()
+	defer func(url func(string) string) { eventListenerURL = url }(eventListenerURL)
+	eventListenerURL = func(string) string { return eventListener.URL }
+
+	event := scheduledPushEvent(scheduledHook, "owner", "repo", "release", "1234567890abcdef")
+	if err := r.sendScheduledDelivery(scheduledHook, "0 2 * * *", event); err != nil {
+		t.Fatalf("Error sending scheduled delivery: %s", err)
+	}
+
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write(body)
+	if received.Header.Get("X-Hub-Signature") != "sha1="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Scheduled delivery was not signed with the secret token")
+	}
+	if received.Header.Get("X-Github-Event") != "push" || received.Header.Get(targetTriggersHeader) != "nightly-pipelines-push-event" {
+		t.Errorf("Scheduled delivery had headers %v, expected a push for the webhook's push trigger", received.Header)
+	}
+	push := github.PushEvent{}
+	if err := json.Unmarshal(body, &push); err != nil {
+		t.Fatalf("Error decoding scheduled delivery: %s", err)
+	}
+	if push.GetRef() != "refs/heads/release" || push.GetHeadCommit().GetID() != "1234567890abcdef" || push.GetRepo().GetCloneURL() != "https://github.com/owner/repo.git" {
+		t.Errorf("Scheduled delivery was for %s at %s of %s", push.GetRef(), push.GetHeadCommit().GetID(), push.GetRepo().GetCloneURL())
+	}
+}
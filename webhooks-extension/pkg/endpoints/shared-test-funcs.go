@@ -22,6 +22,7 @@ import (
 	restful "github.com/emicklei/go-restful"
 	"github.com/mitchellh/mapstructure"
 	fakeroutesclientset "github.com/openshift/client-go/route/clientset/versioned/fake"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/credentialstore"
 	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
 	faketriggerclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned/fake"
@@ -125,21 +126,26 @@ func dummyDefaults() EnvDefaults {
 
 func updateResourceDefaults(r *Resource, newDefaults EnvDefaults) *Resource {
 	newResource := Resource{
-		K8sClient:      r.K8sClient,
-		TektonClient:   r.TektonClient,
-		TriggersClient: r.TriggersClient,
-		Defaults:       newDefaults,
+		K8sClient:       r.K8sClient,
+		TektonClient:    r.TektonClient,
+		TriggersClient:  r.TriggersClient,
+		DynamicClient:   r.DynamicClient,
+		CredentialStore: credentialstore.NewKubernetesStore(r.K8sClient, newDefaults.Namespace),
+		Defaults:        newDefaults,
 	}
 	return &newResource
 }
 
 func dummyResource() *Resource {
+	k8sClient := dummyK8sClientset()
+	defaults := dummyDefaults()
 	resource := Resource{
-		K8sClient:      dummyK8sClientset(),
-		TektonClient:   dummyClientset(),
-		TriggersClient: dummyTriggersClientset(),
-		RoutesClient:   dummyRoutesClientset(),
-		Defaults:       dummyDefaults(),
+		K8sClient:       k8sClient,
+		TektonClient:    dummyClientset(),
+		TriggersClient:  dummyTriggersClientset(),
+		RoutesClient:    dummyRoutesClientset(),
+		CredentialStore: credentialstore.NewKubernetesStore(k8sClient, defaults.Namespace),
+		Defaults:        defaults,
 	}
 
 	return &resource
@@ -0,0 +1,418 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+/*--------------------------------------
+The status of the PipelineRuns started by a pull request delivery is reported by the
+extension. The monitor trigger creates a pull-request PipelineResource in the install
+namespace for the delivery, labelled with the delivery's event ID by Tekton Triggers,
+which records the pull request and what has been reported so far. The reporter watches
+PipelineRuns with an event ID and sets the commit status of the pull request's head:
+pending while the delivery's PipelineRuns run, then success, failure, or error if they
+have not completed within the timeout. The final status is followed by a summary
+comment on the pull request, and the record is deleted.
+
+Replicas claim each report by updating the record, so a status is only reported by
+the replica whose update succeeds. If reporting fails the claim is handed back, so that
+the report is made again on the next pass. The final state is therefore only left
+recorded once its comment has been added, and the record is then deleted.
+---------------------------------------*/
+
+const (
+	// On the PipelineResources recording pull request deliveries to report on
+	statusReportLabel = "webhooks.tekton.dev/status-report"
+	// Set by the monitor trigger template from the pull request delivery
+	headCommitAnnotation  = "webhooks.tekton.dev/head-commit"
+	dashboardAnnotation   = "webhooks.tekton.dev/dashboard-url"
+	commentAnnotationBase = "webhooks.tekton.dev/comment-"
+	// The commit status last reported, and the runs seen, as namespace/name/pipeline separated by commas
+	reportedStateAnnotation = "webhooks.tekton.dev/reported-state"
+	reportedRunsAnnotation  = "webhooks.tekton.dev/reported-runs"
+
+	statusContext = "Tekton"
+	// Reports are also brought up to date on this interval, catching timeouts and missed watch events
+	statusResyncInterval = 30 * time.Second
+	// A delivery with no PipelineRuns this long after its record was created is not reported on
+	noRunsGrace = 2 * time.Minute
+
+	// A run that was seen and has since been deleted
+	runStateMissing = "missing"
+)
+
+// Commit statuses, as named by GitHub
+const (
+	commitStatePending = "pending"
+	commitStateSuccess = "success"
+	commitStateFailure = "failure"
+	commitStateError   = "error"
+)
+
+type commitStatus struct {
+	State       string
+	Description string
+	TargetURL   string
+	Context     string
+}
+
+type reportedRun struct {
+	Name      string
+	Namespace string
+	Pipeline  string
+	State     string
+}
+
+type deliveryReport struct {
+	Status commitStatus
+	Runs   []reportedRun
+	// Whether the status is final, so the summary comment is added
+	Final bool
+}
+
+// The git provider the status of a pull request is reported to, replaced in tests
+var statusProviderFor = func(r Resource, hook webhook, org, repo string) (GitProvider, error) {
+	return r.createGitProviderForWebhook(hook, org, repo)
+}
+
+// ReportStatuses reports the status of pull request deliveries' PipelineRuns as they change, until the process exits
+func (r Resource) ReportStatuses(timeout time.Duration) {
+	changed := make(chan string, 100)
+	go r.watchDeliveryRuns(changed)
+	resync := time.NewTicker(statusResyncInterval)
+	for {
+		select {
+		case eventID := <-changed:
+			r.reportDeliveries(eventID, timeout, time.Now())
+		case <-resync.C:
+			r.reportDeliveries("", timeout, time.Now())
+		}
+	}
+}
+
+// Sends the event ID of every PipelineRun created for a delivery as it changes
+func (r Resource) watchDeliveryRuns(changed chan<- string) {
+	for {
+		watcher, err := r.TektonClient.TektonV1alpha1().PipelineRuns("").Watch(metav1.ListOptions{LabelSelector: triggersEventIDLabel})
+		if err != nil {
+			logging.Log.Errorf("error watching PipelineRuns to report their status: %s", err)
+			time.Sleep(statusResyncInterval)
+			continue
+		}
+		for event := range watcher.ResultChan() {
+			if pipelineRun, ok := event.Object.(*pipelinesv1alpha1.PipelineRun); ok {
+				changed <- pipelineRun.Labels[triggersEventIDLabel]
+			}
+		}
+	}
+}
+
+// Brings the reports of the delivery with the event ID, or of every delivery if it is empty, up to date
+func (r Resource) reportDeliveries(eventID string, timeout time.Duration, now time.Time) {
+	selector := statusReportLabel + "=true"
+	if eventID != "" {
+		selector += "," + triggersEventIDLabel + "=" + eventID
+	}
+	records, err := r.TektonClient.TektonV1alpha1().PipelineResources(r.Defaults.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		logging.Log.Errorf("error listing pull requests to report on: %s", err)
+		return
+	}
+	for i := range records.Items {
+		if err := r.reportDelivery(&records.Items[i], timeout, now); err != nil {
+			logging.Log.Errorf("error reporting the status of %s: %s", records.Items[i].Name, err)
+		}
+	}
+}
+
+func (r Resource) reportDelivery(record *pipelinesv1alpha1.PipelineResource, timeout time.Duration, now time.Time) error {
+	records := r.TektonClient.TektonV1alpha1().PipelineResources(r.Defaults.Namespace)
+	pipelineRuns, err := r.TektonClient.TektonV1alpha1().PipelineRuns("").List(metav1.ListOptions{LabelSelector: triggersEventIDLabel + "=" + record.Labels[triggersEventIDLabel]})
+	if err != nil {
+		return err
+	}
+
+	report := newDeliveryReport(record, pipelineRuns.Items, timeout, now)
+	if len(report.Runs) == 0 {
+		// Nothing was run for the delivery, so there is nothing to report
+		if now.Sub(record.CreationTimestamp.Time) > noRunsGrace {
+			return ignoreNotFound(records.Delete(record.Name, &metav1.DeleteOptions{}))
+		}
+		return nil
+	}
+	reportedRuns := formatReportedRuns(report.Runs)
+	stateChanged := record.Annotations[reportedStateAnnotation] != report.Status.State
+	checkRuns, checks, checksChanged := map[string]checkRun{}, map[string]reportedCheck{}, false
+	if reportingCheckRuns() {
+		dashboard := strings.TrimSuffix(r.getDashboardURL(r.Defaults.Namespace), "/")
+		checkRuns = newCheckRuns(record, report, pipelineRuns.Items, dashboard, now)
+		checks, checksChanged = changedCheckRuns(record, checkRuns)
+	}
+	if !stateChanged && !checksChanged && record.Annotations[reportedRunsAnnotation] == reportedRuns {
+		if report.Final {
+			// Reported, but the record was not deleted
+			return ignoreNotFound(records.Delete(record.Name, &metav1.DeleteOptions{}))
+		}
+		return nil
+	}
+
+	// Claim the report, another replica has made it if the record changed since it was read
+	previousState := record.Annotations[reportedStateAnnotation]
+	if record.Annotations == nil {
+		record.Annotations = map[string]string{}
+	}
+	record.Annotations[reportedStateAnnotation] = report.Status.State
+	record.Annotations[reportedRunsAnnotation] = reportedRuns
+	if reportingCheckRuns() {
+		record.Annotations[checkRunsAnnotation] = formatReportedChecks(checks)
+	}
+	if _, err := records.Update(record); err != nil {
+		if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !stateChanged && !checksChanged {
+		return nil
+	}
+
+	if err := r.publishReport(record, report, stateChanged, checkRuns, checks); err != nil {
+		if stateChanged {
+			r.unclaimReport(record.Name, report.Status.State, previousState)
+		}
+		return err
+	}
+	if !report.Final {
+		return nil
+	}
+	return ignoreNotFound(records.Delete(record.Name, &metav1.DeleteOptions{}))
+}
+
+// Reports the delivery's status, or its check runs, to the git provider, along with the summary comment once the state is final
+func (r Resource) publishReport(record *pipelinesv1alpha1.PipelineResource, report deliveryReport, stateChanged bool, checkRuns map[string]checkRun, checks map[string]reportedCheck) error {
+	repoURL, number, err := parsePullRequestURL(recordParam(record, "url"))
+	if err != nil {
+		return err
+	}
+	_, org, repo, err := getGitValues(repoURL)
+	if err != nil {
+		return err
+	}
+	secret := ""
+	if len(record.Spec.SecretParams) > 0 {
+		secret = record.Spec.SecretParams[0].SecretName
+	}
+	gitProvider, err := statusProviderFor(r, webhook{GitRepositoryURL: repoURL, AccessTokenRef: secret}, org, repo)
+	if err != nil {
+		return err
+	}
+	if reportingCheckRuns() {
+		if err := r.reportCheckRuns(gitProvider, record, checkRuns, checks); err != nil {
+			return err
+		}
+	} else if stateChanged {
+		if err := gitProvider.SetCommitStatus(record.Annotations[headCommitAnnotation], report.Status); err != nil {
+			return err
+		}
+		logging.Log.Infof("Reported %s status of pull request %s/%s#%d", report.Status.State, org, repo, number)
+	}
+	if !report.Final || !stateChanged {
+		return nil
+	}
+	return gitProvider.AddPullRequestComment(number, reportComment(record, report.Runs))
+}
+
+// Records the state as not reported after all, unless it has been reported since
+func (r Resource) unclaimReport(name, claimed, previous string) {
+	records := r.TektonClient.TektonV1alpha1().PipelineResources(r.Defaults.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := records.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations[reportedStateAnnotation] != claimed {
+			return nil
+		}
+		latest.Annotations[reportedStateAnnotation] = previous
+		_, err = records.Update(latest)
+		return err
+	})
+	if err := ignoreNotFound(err); err != nil {
+		logging.Log.Errorf("error handing back the report of %s, it will not be retried: %s", name, err)
+	}
+}
+
+// Deleting a record another replica already deleted is not an error
+func ignoreNotFound(err error) error {
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// The status of a delivery's runs, including those seen earlier that have since been deleted
+func newDeliveryReport(record *pipelinesv1alpha1.PipelineResource, pipelineRuns []pipelinesv1alpha1.PipelineRun, timeout time.Duration, now time.Time) deliveryReport {
+	report := deliveryReport{}
+	found := map[string]bool{}
+	for _, pipelineRun := range pipelineRuns {
+		run := reportedRun{
+			Name:      pipelineRun.Name,
+			Namespace: pipelineRun.Namespace,
+			Pipeline:  pipelineRun.Spec.PipelineRef.Name,
+			State:     newWebhookRun(pipelineRun).State,
+		}
+		found[run.Namespace+"/"+run.Name] = true
+		report.Runs = append(report.Runs, run)
+	}
+	for _, run := range parseReportedRuns(record.Annotations[reportedRunsAnnotation]) {
+		if !found[run.Namespace+"/"+run.Name] {
+			run.State = runStateMissing
+			report.Runs = append(report.Runs, run)
+		}
+	}
+	sort.Slice(report.Runs, func(i, j int) bool {
+		if report.Runs[i].State != report.Runs[j].State {
+			return reportOrder(report.Runs[i].State) < reportOrder(report.Runs[j].State)
+		}
+		return report.Runs[i].Namespace+"/"+report.Runs[i].Name < report.Runs[j].Namespace+"/"+report.Runs[j].Name
+	})
+
+	counts := map[string]int{}
+	for _, run := range report.Runs {
+		counts[run.State]++
+	}
+	failed := counts[runStateFailed] + counts[runStateCancelled]
+	report.Status = commitStatus{Context: statusContext, TargetURL: dashboardLink(record) + "/#/pipelineruns"}
+	report.Final = true
+	switch {
+	case counts[runStateRunning] > 0 && now.Sub(record.CreationTimestamp.Time) < timeout:
+		report.Status.State, report.Status.Description = commitStatePending, "pipelines in progress"
+		report.Final = false
+	case counts[runStateRunning] > 0:
+		report.Status.State, report.Status.Description = commitStateError, "timed out monitoring pipeline runs"
+	case failed > 0:
+		report.Status.State, report.Status.Description = commitStateFailure, fmt.Sprintf("%d pipeline(s) failed!", failed)
+	case counts[runStateMissing] > 0:
+		report.Status.State, report.Status.Description = commitStateFailure, fmt.Sprintf("%d pipeline(s) missing!", counts[runStateMissing])
+	default:
+		report.Status.State, report.Status.Description = commitStateSuccess, "All pipelines succeeded!"
+	}
+	return report
+}
+
+// Runs are listed succeeded, failed, incomplete then missing in the summary comment
+func reportOrder(state string) int {
+	switch state {
+	case runStateSucceeded:
+		return 0
+	case runStateFailed, runStateCancelled:
+		return 1
+	case runStateRunning:
+		return 2
+	}
+	return 3
+}
+
+// The summary comment, with the status of each run linked to it in the dashboard
+func reportComment(record *pipelinesv1alpha1.PipelineResource, runs []reportedRun) string {
+	comments := map[string]string{
+		runStateSucceeded: "Success",
+		runStateFailed:    "Failed",
+		runStateRunning:   "Unknown",
+		runStateMissing:   "Missing",
+	}
+	for state, name := range map[string]string{runStateSucceeded: "success", runStateFailed: "failure", runStateRunning: "timeout", runStateMissing: "missing"} {
+		if comment := record.Annotations[commentAnnotationBase+name]; comment != "" {
+			comments[state] = comment
+		}
+	}
+	comments[runStateCancelled] = comments[runStateFailed]
+
+	lines := []string{
+		"## Tekton Status Report \n",
+		"Status | Pipeline | PipelineRun | Namespace",
+		":----- | :------- | :--------------- | :--------",
+	}
+	for _, run := range runs {
+		link := dashboardLink(record) + "/#/namespaces/" + run.Namespace + "/pipelineruns/"
+		if run.State != runStateMissing {
+			link += run.Name
+		}
+		lines = append(lines, fmt.Sprintf("[**%s**](%s) | %s | %s | %s", comments[run.State], link, run.Pipeline, run.Name, run.Namespace))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The dashboard URL recorded for the delivery, without a trailing slash
+func dashboardLink(record *pipelinesv1alpha1.PipelineResource) string {
+	dashboard := record.Annotations[dashboardAnnotation]
+	if !strings.HasPrefix(dashboard, "http") {
+		dashboard = "http://" + dashboard
+	}
+	return strings.TrimSuffix(dashboard, "/")
+}
+
+func formatReportedRuns(runs []reportedRun) string {
+	entries := []string{}
+	for _, run := range runs {
+		entries = append(entries, run.Namespace+"/"+run.Name+"/"+run.Pipeline)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func parseReportedRuns(annotation string) []reportedRun {
+	runs := []reportedRun{}
+	for _, entry := range strings.Split(annotation, ",") {
+		parts := strings.SplitN(entry, "/", 3)
+		if len(parts) == 3 {
+			runs = append(runs, reportedRun{Namespace: parts[0], Name: parts[1], Pipeline: parts[2]})
+		}
+	}
+	return runs
+}
+
+func recordParam(record *pipelinesv1alpha1.PipelineResource, name string) string {
+	for _, param := range record.Spec.Params {
+		if param.Name == name {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+// Splits a pull request URL such as https://github.com/owner/repo/pull/1 into the repository URL and the number
+func parsePullRequestURL(pullRequestURL string) (string, int, error) {
+	i := strings.LastIndex(pullRequestURL, "/pull/")
+	if i < 0 {
+		return "", 0, fmt.Errorf("%q is not the URL of a pull request", pullRequestURL)
+	}
+	number, err := strconv.Atoi(pullRequestURL[i+len("/pull/"):])
+	if err != nil {
+		return "", 0, fmt.Errorf("%q is not the URL of a pull request", pullRequestURL)
+	}
+	return pullRequestURL[:i], number, nil
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Records the statuses, comments and check runs reported to it
+type reportingGitProvider struct {
+	hook     webhook
+	org      string
+	repo     string
+	statuses []string
+	comments []string
+	checks   []string
+	// The number of times each call fails before it succeeds
+	fail map[string]int
+}
+
+func (p *reportingGitProvider) failing(call string) error {
+	if p.fail[call] == 0 {
+		return nil
+	}
+	p.fail[call]--
+	return errors.New(call + " failed")
+}
+
+func (p *reportingGitProvider) AddWebhook(hook webhook) error                    { return nil }
+func (p *reportingGitProvider) DeleteWebhook(hook GitWebhook) error              { return nil }
+func (p *reportingGitProvider) GetAllWebhooks() ([]GitWebhook, error)            { return nil, nil }
+func (p *reportingGitProvider) WebhookPayload(hook webhook) interface{}          { return nil }
+func (p *reportingGitProvider) HeadCommit(branch string) (string, string, error) { return "", "", nil }
+
+func (p *reportingGitProvider) SetCommitStatus(sha string, status commitStatus) error {
+	if err := p.failing("SetCommitStatus"); err != nil {
+		return err
+	}
+	p.statuses = append(p.statuses, sha+" "+status.State+" "+status.Description+" "+status.TargetURL)
+	return nil
+}
+
+func (p *reportingGitProvider) AddPullRequestComment(number int, body string) error {
+	if err := p.failing("AddPullRequestComment"); err != nil {
+		return err
+	}
+	p.comments = append(p.comments, body)
+	return nil
+}
+
+func (p *reportingGitProvider) CreateCheckRun(sha string, run checkRun) (int64, error) {
+	if err := p.failing("CreateCheckRun"); err != nil {
+		return 0, err
+	}
+	p.checks = append(p.checks, strings.Join([]string{"create", sha, run.Name, run.Status, run.Conclusion, run.Title}, " "))
+	return int64(len(p.checks)), nil
+}
+
+func (p *reportingGitProvider) UpdateCheckRun(id int64, run checkRun) error {
+	if err := p.failing("UpdateCheckRun"); err != nil {
+		return err
+	}
+	p.checks = append(p.checks, strings.Join([]string{"update", strconv.FormatInt(id, 10), run.Name, run.Status, run.Conclusion, run.Title}, " "))
+	return nil
+}
+
+func statusReportRecord(eventID string, created time.Time) *pipelinesv1alpha1.PipelineResource {
+	return &pipelinesv1alpha1.PipelineResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pull-request-" + eventID,
+			Namespace:         installNs,
+			CreationTimestamp: metav1.NewTime(created),
+			Labels:            map[string]string{triggersEventIDLabel: eventID, statusReportLabel: "true"},
+			Annotations: map[string]string{
+				headCommitAnnotation:              "1234567890abcdef",
+				dashboardAnnotation:               "localhost:9097/",
+				commentAnnotationBase + "missing": "Fehlt",
+			},
+		},
+		Spec: pipelinesv1alpha1.PipelineResourceSpec{
+			Type:         pipelinesv1alpha1.PipelineResourceTypePullRequest,
+			Params:       []pipelinesv1alpha1.ResourceParam{{Name: "url", Value: "https://github.com/owner/repo/pull/7"}},
+			SecretParams: []pipelinesv1alpha1.SecretParam{{FieldName: "githubToken", SecretKey: "accessToken", SecretName: "github-secret"}},
+		},
+	}
+}
+
+func TestReportDeliveries(t *testing.T) {
+	provider := &reportingGitProvider{}
+	defer func(providerFor func(Resource, webhook, string, string) (GitProvider, error)) {
+		statusProviderFor = providerFor
+	}(statusProviderFor)
+	statusProviderFor = func(r Resource, hook webhook, org, repo string) (GitProvider, error) {
+		provider.hook, provider.org, provider.repo = hook, org, repo
+		return provider, nil
+	}
+
+	r := dummyResource()
+	r.TektonClient = fakeclientset.NewSimpleClientset()
+	now := time.Now()
+	r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Create(statusReportRecord("event", now.Add(-5*time.Minute)))
+	for _, name := range []string{"first", "second"} {
+		pipelineRun := webhookPipelineRun(name, "pipeline-"+name, "feature", now, corev1.ConditionUnknown, "Running")
+		pipelineRun.Labels[triggersEventIDLabel] = "event"
+		r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(pipelineRun)
+	}
+
+	r.reportDeliveries("event", time.Hour, now)
+	r.reportDeliveries("", time.Hour, now)
+	expectedStatuses := []string{"1234567890abcdef pending pipelines in progress http://localhost:9097/#/pipelineruns"}
+	if !reflect.DeepEqual(provider.statuses, expectedStatuses) {
+		t.Errorf("Statuses reported while the runs were in progress were %v, expected %v", provider.statuses, expectedStatuses)
+	}
+	if provider.hook.AccessTokenRef != "github-secret" || provider.org != "owner" || provider.repo != "repo" {
+		t.Errorf("Statuses were reported to %s/%s with %s", provider.org, provider.repo, provider.hook.AccessTokenRef)
+	}
+
+	first := webhookPipelineRun("first", "pipeline-first", "feature", now, corev1.ConditionTrue, "Succeeded")
+	first.Labels[triggersEventIDLabel] = "event"
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Update(first)
+	r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Delete("second", &metav1.DeleteOptions{})
+	r.reportDeliveries("event", time.Hour, now)
+
+	expectedStatuses = append(expectedStatuses, "1234567890abcdef failure 1 pipeline(s) missing! http://localhost:9097/#/pipelineruns")
+	if !reflect.DeepEqual(provider.statuses, expectedStatuses) {
+		t.Errorf("Statuses reported once the runs completed were %v, expected %v", provider.statuses, expectedStatuses)
+	}
+	expectedComment := strings.Join([]string{
+		"## Tekton Status Report \n",
+		"Status | Pipeline | PipelineRun | Namespace",
+		":----- | :------- | :--------------- | :--------",
+		"[**Success**](http://localhost:9097/#/namespaces/pipelines/pipelineruns/first) | pipeline-first | first | pipelines",
+		"[**Fehlt**](http://localhost:9097/#/namespaces/pipelines/pipelineruns/) | pipeline-second | second | pipelines",
+	}, "\n")
+	if len(provider.comments) != 1 || provider.comments[0] != expectedComment {
+		t.Errorf("Comments added were %v, expected %s", provider.comments, expectedComment)
+	}
+	records, _ := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).List(metav1.ListOptions{})
+	if len(records.Items) != 0 {
+		t.Errorf("Records left once the final status was reported were %+v", records.Items)
+	}
+}
+
+func TestReportDeliveriesRetriesFailedReports(t *testing.T) {
+	defer func(providerFor func(Resource, webhook, string, string) (GitProvider, error)) {
+		statusProviderFor = providerFor
+	}(statusProviderFor)
+	for _, failingCall := range []string{"SetCommitStatus", "AddPullRequestComment"} {
+		provider := &reportingGitProvider{fail: map[string]int{failingCall: 1}}
+		statusProviderFor = func(r Resource, hook webhook, org, repo string) (GitProvider, error) {
+			return provider, nil
+		}
+		r := dummyResource()
+		r.TektonClient = fakeclientset.NewSimpleClientset()
+		now := time.Now()
+		r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Create(statusReportRecord("event", now))
+		pipelineRun := webhookPipelineRun("run", "pipeline", "feature", now, corev1.ConditionTrue, "Succeeded")
+		pipelineRun.Labels[triggersEventIDLabel] = "event"
+		r.TektonClient.TektonV1alpha1().PipelineRuns("pipelines").Create(pipelineRun)
+
+		r.reportDeliveries("event", time.Hour, now)
+		if _, err := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Get("pull-request-event", metav1.GetOptions{}); err != nil {
+			t.Fatalf("Record was deleted although %s failed: %s", failingCall, err)
+		}
+		r.reportDeliveries("event", time.Hour, now)
+
+		if len(provider.statuses) == 0 || !strings.HasPrefix(provider.statuses[len(provider.statuses)-1], "1234567890abcdef success") || len(provider.comments) != 1 {
+			t.Errorf("After %s failed once, reported statuses %v and comments %v", failingCall, provider.statuses, provider.comments)
+		}
+		records, _ := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).List(metav1.ListOptions{})
+		if len(records.Items) != 0 {
+			t.Errorf("Records left once the report was retried after %s failed were %+v", failingCall, records.Items)
+		}
+	}
+}
+
+func TestDeliveryReportStates(t *testing.T) {
+	now := time.Now()
+	record := statusReportRecord("event", now.Add(-2*time.Hour))
+	tests := []struct {
+		statuses []corev1.ConditionStatus
+		state    string
+		final    bool
+	}{
+		{[]corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionTrue}, commitStateSuccess, true},
+		{[]corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse}, commitStateFailure, true},
+		{[]corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionUnknown}, commitStateError, true},
+	}
+	for _, test := range tests {
+		pipelineRuns := []pipelinesv1alpha1.PipelineRun{}
+		for i, status := range test.statuses {
+			pipelineRuns = append(pipelineRuns, *webhookPipelineRun("run-"+strconv.Itoa(i), "pipeline", "feature", now, status, ""))
+		}
+		report := newDeliveryReport(record, pipelineRuns, time.Hour, now)
+		if report.Status.State != test.state || report.Final != test.final {
+			t.Errorf("Report of runs %v was %s (final %t), expected %s (final %t)", test.statuses, report.Status.State, report.Final, test.state, test.final)
+		}
+	}
+
+	noRuns := statusReportRecord("none", now.Add(-time.Hour))
+	r := dummyResource()
+	r.TektonClient = fakeclientset.NewSimpleClientset(noRuns)
+	if err := r.reportDelivery(noRuns, time.Hour, now); err != nil {
+		t.Fatalf("Error reporting a delivery without runs: %s", err)
+	}
+	if _, err := r.TektonClient.TektonV1alpha1().PipelineResources(installNs).Get(noRuns.Name, metav1.GetOptions{}); err == nil {
+		t.Error("The record of a delivery that ran nothing was not deleted")
+	}
+}
+
+func TestParsePullRequestURL(t *testing.T) {
+	repoURL, number, err := parsePullRequestURL("https://github.example.com/owner/repo/pull/42")
+	if err != nil || repoURL != "https://github.example.com/owner/repo" || number != 42 {
+		t.Errorf("Pull request URL was parsed as %s #%d (%v)", repoURL, number, err)
+	}
+	for _, invalid := range []string{"https://github.com/owner/repo", "https://github.com/owner/repo/pull/new"} {
+		if _, _, err := parsePullRequestURL(invalid); err == nil {
+			t.Errorf("Expected an error parsing %s as a pull request URL", invalid)
+		}
+	}
+}
@@ -15,11 +15,15 @@ package endpoints
 
 import (
 	"os"
+	"strconv"
+	"strings"
 
 	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/credentialstore"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	tektoncdclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	triggersclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned"
+	"k8s.io/client-go/dynamic"
 	k8sclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -30,7 +34,10 @@ type Resource struct {
 	K8sClient      k8sclientset.Interface
 	TriggersClient triggersclientset.Interface
 	RoutesClient   routeclientset.Interface
-	Defaults       EnvDefaults
+	DynamicClient  dynamic.Interface
+	// Where the tokens of the credentials used by webhooks are read from
+	CredentialStore credentialstore.CredentialStore
+	Defaults        EnvDefaults
 }
 
 // NewResource returns a new Resource instantiated with its clientsets
@@ -70,22 +77,79 @@ func NewResource() (Resource, error) {
 		return Resource{}, err
 	}
 
+	// Used for resources in newer API versions than our typed clients know about
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		logging.Log.Errorf("error building dynamic client: %s.", err.Error())
+		return Resource{}, err
+	}
+
 	defaults := EnvDefaults{
 		Namespace:      os.Getenv("INSTALLED_NAMESPACE"),
 		DockerRegistry: os.Getenv("DOCKER_REGISTRY_LOCATION"),
 		CallbackURL:    os.Getenv("WEBHOOK_CALLBACK_URL"),
+		// Either "header" (the default) or "interceptorrequest"
+		InterceptorProtocol: os.Getenv("INTERCEPTOR_PROTOCOL"),
+		// Access tokens are checked with the git provider unless this is "false"
+		VerifyCredentials: strings.ToLower(os.Getenv("VERIFY_CREDENTIALS")) != "false",
+		// Either "hex" (the default) or "base64"
+		SecretTokenEncoding: os.Getenv("SECRET_TOKEN_ENCODING"),
+		IngressClass:        os.Getenv("INGRESS_CLASS"),
+		IngressTLSSecret:    os.Getenv("INGRESS_TLS_SECRET"),
+		IngressPath:         os.Getenv("INGRESS_PATH"),
+		GatewayName:         os.Getenv("GATEWAY_NAME"),
+		GatewayNamespace:    os.Getenv("GATEWAY_NAMESPACE"),
+		GatewaySectionName:  os.Getenv("GATEWAY_SECTION_NAME"),
 	}
 	if defaults.Namespace == "" {
 		// If no namespace provided, use "default"
 		defaults.Namespace = "default"
 	}
+	if defaults.InterceptorProtocol == "" {
+		defaults.InterceptorProtocol = interceptorProtocolHeader
+	}
+	if defaults.SecretTokenEncoding == "" {
+		defaults.SecretTokenEncoding = secretTokenEncodingHex
+	}
+	defaults.SecretTokenLength = defaultSecretTokenLength
+	if length, err := strconv.Atoi(os.Getenv("SECRET_TOKEN_LENGTH")); err == nil && length > 0 {
+		defaults.SecretTokenLength = length
+	}
+	defaults.MinSecretTokenEntropy = defaultMinSecretTokenEntropy
+	if entropy, err := strconv.ParseFloat(os.Getenv("MIN_SECRET_TOKEN_ENTROPY"), 64); err == nil && entropy > 0 {
+		defaults.MinSecretTokenEntropy = entropy
+	}
+
+	defaults.IngressAnnotations, err = parseIngressAnnotations(os.Getenv("INGRESS_ANNOTATIONS"))
+	if err != nil {
+		logging.Log.Errorf("error reading ingress annotations: %s.", err.Error())
+		return Resource{}, err
+	}
+
+	_, platformSet := os.LookupEnv("PLATFORM")
+	defaults.Exposure = defaultExposure(strings.ToLower(os.Getenv("EVENTLISTENER_EXPOSURE")), platformSet)
+
+	credentialStore, err := credentialstore.NewFromEnv(k8sClient, defaults.Namespace)
+	if err != nil {
+		logging.Log.Errorf("error creating credential store: %s.", err.Error())
+		return Resource{}, err
+	}
 
 	r := Resource{
-		K8sClient:      k8sClient,
-		TektonClient:   tektonClient,
-		TriggersClient: triggersClient,
-		RoutesClient:   routesClient,
-		Defaults:       defaults,
+		K8sClient:       k8sClient,
+		TektonClient:    tektonClient,
+		TriggersClient:  triggersClient,
+		RoutesClient:    routesClient,
+		DynamicClient:   dynamicClient,
+		CredentialStore: credentialStore,
+		Defaults:        defaults,
+	}
+	if _, err := r.eventListenerExposer(); err != nil {
+		logging.Log.Errorf("error reading eventlistener exposure: %s.", err.Error())
+		return Resource{}, err
+	}
+	if defaults.Exposure == exposureHTTPRoute && defaults.GatewayName == "" {
+		logging.Log.Warn("GATEWAY_NAME is not set, webhooks cannot be created until it is")
 	}
 	return r, nil
 }
@@ -106,13 +170,54 @@ type webhook struct {
 	OnFailureComment string `json:"onfailurecomment,omitempty"`
 	OnTimeoutComment string `json:"ontimeoutcomment,omitempty"`
 	OnMissingComment string `json:"onmissingcomment,omitempty"`
+	// Deliveries are rejected while this many of the webhook's PipelineRuns are in flight, 0 for no limit
+	MaxConcurrentRuns int `json:"maxconcurrentruns,omitempty"`
+	// Cancel the in-flight PipelineRuns of a branch or pull request when a new commit arrives for it
+	CancelSuperseded bool `json:"cancelsuperseded,omitempty"`
+	// Comma separated CIDRs deliveries are accepted from, overriding the global allowlist
+	AllowedSourceRanges string `json:"allowedsourceranges,omitempty"`
+	// Copy the credential into the namespace and add it to the service account's secrets
+	SyncCredential bool `json:"synccredential,omitempty"`
+	// "generic" for an event source other than a Git provider, empty for a Git repository
+	Type string `json:"type,omitempty"`
+	// Deliveries to a generic webhook carry the HMAC of the payload in SignatureHeader, and
+	// the value at IdentityPath in the payload must be IdentityValue
+	SignatureHeader    string `json:"signatureheader,omitempty"`
+	SignatureAlgorithm string `json:"signaturealgorithm,omitempty"`
+	IdentityPath       string `json:"identitypath,omitempty"`
+	IdentityValue      string `json:"identityvalue,omitempty"`
+	// Cron schedules, separated by semicolons, on which the pipeline is run as for a push
+	// of the head of ScheduleBranch, the repository's default branch if empty
+	Schedules      string `json:"schedules,omitempty"`
+	ScheduleBranch string `json:"schedulebranch,omitempty"`
+	// Completed PipelineRuns beyond the last KeepRuns of each branch, or older than MaxRunAge,
+	// are deleted, except the last successful run of each branch
+	KeepRuns  int    `json:"keepruns,omitempty"`
+	MaxRunAge string `json:"maxrunage,omitempty"`
 }
 
 // ConfigMapName ... the name of the ConfigMap to create
 const ConfigMapName = "githubwebhook"
 
 type EnvDefaults struct {
-	Namespace      string `json:"namespace"`
-	DockerRegistry string `json:"dockerregistry"`
-	CallbackURL    string `json:"endpointurl"`
+	Namespace           string `json:"namespace"`
+	DockerRegistry      string `json:"dockerregistry"`
+	CallbackURL         string `json:"endpointurl"`
+	InterceptorProtocol string `json:"interceptorprotocol"`
+	VerifyCredentials   bool   `json:"verifycredentials"`
+	// Generated secret tokens are SecretTokenLength random bytes in SecretTokenEncoding
+	SecretTokenLength     int     `json:"secrettokenlength"`
+	SecretTokenEncoding   string  `json:"secrettokenencoding"`
+	MinSecretTokenEntropy float64 `json:"minsecrettokenentropy"`
+	// How the Ingress exposing the eventlistener is created
+	IngressClass       string            `json:"ingressclass,omitempty"`
+	IngressTLSSecret   string            `json:"ingresstlssecret,omitempty"`
+	IngressPath        string            `json:"ingresspath,omitempty"`
+	IngressAnnotations map[string]string `json:"ingressannotations,omitempty"`
+	// Either "ingress", "route", "httproute" or "none"
+	Exposure string `json:"exposure"`
+	// The Gateway an HTTPRoute is attached to
+	GatewayName        string `json:"gatewayname,omitempty"`
+	GatewayNamespace   string `json:"gatewaynamespace,omitempty"`
+	GatewaySectionName string `json:"gatewaysectionname,omitempty"`
 }
@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	github "github.com/google/go-github/github"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/utils"
+)
+
+// What the git provider reports about a credential's access token
+type credentialVerification struct {
+	User string `json:"user"`
+	// Nil when the provider does not report scopes, as for fine-grained tokens
+	Scopes []string   `json:"scopes"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+}
+
+// Either of these scopes allows webhooks to be created and deleted
+var hookScopes = []string{"admin:repo_hook", "repo"}
+
+// Layouts of the GitHub-Authentication-Token-Expiration header
+var tokenExpiryLayouts = []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"}
+
+/*--------------------------------------
+This file implements the endpoint from webhooks.go:
+	ws.Route(ws.POST("/credentials/{name}/verify").To(r.verifyCredential))
+---------------------------------------*/
+
+func (r Resource) verifyCredential(request *restful.Request, response *restful.Response) {
+	credName := request.PathParameter("name")
+	cred, found := r.getCredentialByName(credName, false, response)
+	if !found {
+		return
+	}
+	if cred.Name == "" {
+		utils.RespondErrorMessage(response, fmt.Sprintf("error: secret '%s' is not a credential", credName), http.StatusNotFound)
+		return
+	}
+
+	verification, status, err := verifyAccessToken(cred)
+	if err != nil {
+		utils.RespondMessageAndLogError(response, err, err.Error(), status)
+		return
+	}
+	response.WriteEntity(verification)
+}
+
+// Checks the credential's access token with the git provider. On error, returns the http status
+// to respond with: 400 if the provider rejected the token or it cannot manage webhooks.
+func verifyAccessToken(cred credential) (credentialVerification, int, error) {
+	accessToken := cred.AccessToken
+	if accessToken == "" && cred.Type == credentialTypeBasicAuth {
+		accessToken = cred.Password
+	}
+
+	apiURL, err := gitServerAPIURL(cred.GitServer)
+	if err != nil {
+		return credentialVerification{}, http.StatusBadRequest, err
+	}
+	ctx := context.Background()
+	client := github.NewClient(utils.CreateOAuth2Client(ctx, accessToken))
+	client.BaseURL = apiURL
+
+	user, ghResponse, err := client.Users.Get(ctx, "")
+	if err != nil {
+		if ghResponse != nil && (ghResponse.StatusCode == http.StatusUnauthorized || ghResponse.StatusCode == http.StatusForbidden) {
+			return credentialVerification{}, http.StatusBadRequest, fmt.Errorf("error: the access token was rejected by %s, it may have expired or been revoked", apiURL.Host)
+		}
+		logging.Log.Errorf("error verifying access token with %s: %s", apiURL, err)
+		return credentialVerification{}, http.StatusBadGateway, fmt.Errorf("error: unable to verify the access token with %s", apiURL.Host)
+	}
+
+	verification := credentialVerification{User: user.GetLogin()}
+	if scopes, reported := ghResponse.Header["X-Oauth-Scopes"]; reported {
+		verification.Scopes = parseScopes(strings.Join(scopes, ","))
+		if !hasHookScope(verification.Scopes) {
+			return verification, http.StatusBadRequest, fmt.Errorf("error: the access token must have one of the scopes %s to manage webhooks", strings.Join(hookScopes, ", "))
+		}
+	}
+	if expiry := ghResponse.Header.Get("GitHub-Authentication-Token-Expiration"); expiry != "" {
+		for _, layout := range tokenExpiryLayouts {
+			if parsed, err := time.Parse(layout, expiry); err == nil {
+				verification.Expiry = &parsed
+				break
+			}
+		}
+	}
+	return verification, http.StatusOK, nil
+}
+
+// Returns the API URL of the git server, the server of public GitHub if none is given
+func gitServerAPIURL(gitServer string) (*url.URL, error) {
+	if gitServer == "" {
+		gitServer = defaultGitServer
+	}
+	if !strings.Contains(gitServer, "://") {
+		gitServer = "https://" + gitServer
+	}
+	serverURL, err := url.Parse(gitServer)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(serverURL.Host, "github.com") {
+		return url.Parse("https://api.github.com/")
+	}
+	// GHE
+	return url.Parse(serverURL.Scheme + "://" + serverURL.Host + "/api/v3/")
+}
+
+func parseScopes(header string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(header, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func hasHookScope(scopes []string) bool {
+	for _, scope := range scopes {
+		for _, hookScope := range hookScopes {
+			if scope == hookScope {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A stand-in for the GitHub user API, responding to each token as configured
+func dummyGitHubUserAPI(t *testing.T, scopes map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/v3/user" {
+			t.Errorf("Unexpected request to %s", request.URL.Path)
+		}
+		token := request.Header.Get("Authorization")[len("Bearer "):]
+		tokenScopes, found := scopes[token]
+		if !found {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writer.Header().Set("X-OAuth-Scopes", tokenScopes)
+		writer.Header().Set("GitHub-Authentication-Token-Expiration", "2030-01-02 03:04:05 UTC")
+		writer.Write([]byte(`{"login": "octocat"}`))
+	}))
+}
+
+func postCredential(cred credential, r *Resource) *httptest.ResponseRecorder {
+	jsonBody, _ := json.Marshal(cred)
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8383/webhooks/credentials", bytes.NewBuffer(jsonBody))
+	req := dummyRestfulRequest(httpReq, "")
+	httpWriter := httptest.NewRecorder()
+	resp := dummyRestfulResponse(httpWriter)
+	r.createCredential(req, resp)
+	return httpWriter
+}
+
+func TestCreateVerifiedCredential(t *testing.T) {
+	server := dummyGitHubUserAPI(t, map[string]string{"good-token": "repo, read:org"})
+	defer server.Close()
+	r := dummyResource()
+	r.Defaults.VerifyCredentials = true
+
+	httpWriter := postCredential(credential{Name: "verified", AccessToken: "good-token", GitServer: server.URL}, r)
+	if httpWriter.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating a credential with a valid token but got %d: %s", httpWriter.Code, httpWriter.Body.String())
+	}
+
+	verification := credentialVerification{}
+	if err := json.Unmarshal(httpWriter.Body.Bytes(), &verification); err != nil {
+		t.Fatalf("Error reading verification from response: %s", err)
+	}
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if verification.User != "octocat" || !reflect.DeepEqual(verification.Scopes, []string{"repo", "read:org"}) || verification.Expiry == nil || !verification.Expiry.Equal(expiry) {
+		t.Errorf("Unexpected verification %+v", verification)
+	}
+	if cred := r.getK8sCredential("verified"); cred.GitServer != server.URL {
+		t.Errorf("Git server was not stored with the credential, got %+v", cred)
+	}
+}
+
+func TestCreateCredentialRefusesTokens(t *testing.T) {
+	server := dummyGitHubUserAPI(t, map[string]string{"no-hook-token": "read:org, write:repo_hook"})
+	defer server.Close()
+	r := dummyResource()
+	r.Defaults.VerifyCredentials = true
+
+	for _, token := range []string{"no-hook-token", "expired-token"} {
+		httpWriter := postCredential(credential{Name: "refused", AccessToken: token, GitServer: server.URL}, r)
+		if httpWriter.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 creating a credential with %s but got %d", token, httpWriter.Code)
+		}
+	}
+
+	// Verify no credentials have been created
+	checkCredentials([]credential{}, "", r, t)
+}
+
+func TestVerifyCredential(t *testing.T) {
+	server := dummyGitHubUserAPI(t, map[string]string{"good-token": "admin:repo_hook"})
+	defer server.Close()
+	r := dummyResource()
+
+	postCredential(credential{Name: "good", AccessToken: "good-token", GitServer: server.URL}, r)
+	postCredential(credential{Name: "revoked", AccessToken: "revoked-token", GitServer: server.URL}, r)
+
+	tests := []struct {
+		name           string
+		expectedStatus int
+	}{
+		{"good", http.StatusOK},
+		{"revoked", http.StatusBadRequest},
+		{"missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8383/webhooks/credentials/"+tt.name+"/verify", nil)
+		req := dummyRestfulRequest(httpReq, tt.name)
+		httpWriter := httptest.NewRecorder()
+		resp := dummyRestfulResponse(httpWriter)
+		r.verifyCredential(req, resp)
+		if httpWriter.Code != tt.expectedStatus {
+			t.Errorf("Expected %d verifying credential %s but got %d", tt.expectedStatus, tt.name, httpWriter.Code)
+		}
+	}
+}
+
+func TestGitServerAPIURL(t *testing.T) {
+	tests := map[string]string{
+		"":                           "https://api.github.com/",
+		"github.com":                 "https://api.github.com/",
+		"https://github.com":         "https://api.github.com/",
+		"github.example.com":         "https://github.example.com/api/v3/",
+		"http://github.example.com/": "http://github.example.com/api/v3/",
+	}
+	for gitServer, expected := range tests {
+		apiURL, err := gitServerAPIURL(gitServer)
+		if err != nil {
+			t.Errorf("Unexpected error for git server %s: %s", gitServer, err)
+			continue
+		}
+		if apiURL.String() != expected {
+			t.Errorf("API URL for git server %s was %s, expected %s", gitServer, apiURL, expected)
+		}
+	}
+}
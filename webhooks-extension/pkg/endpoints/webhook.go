@@ -22,22 +22,18 @@ import (
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/api/extensions/v1beta1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/retry"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 )
 
 var (
-	modifyingEventListenerLock sync.Mutex
-	actions                    = pipelinesv1alpha1.Param{Name: "Wext-Incoming-Actions", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "opened,reopened,synchronize"}}
+	actions = pipelinesv1alpha1.Param{Name: "Wext-Incoming-Actions", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "opened,reopened,synchronize"}}
 )
 
 const (
@@ -50,35 +46,7 @@ const (
 	the point of webhook creation.
 */
 func (r Resource) createEventListener(webhook webhook, namespace, monitorTriggerName string) (*v1alpha1.EventListener, error) {
-	hookParams, monitorParams := r.getParams(webhook)
-
-	pushTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-push-event",
-		webhook.Pipeline+"-push-binding",
-		webhook.Pipeline+"-template",
-		webhook.GitRepositoryURL,
-		"push",
-		webhook.AccessTokenRef,
-		hookParams)
-
-	pullRequestTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-pullrequest-event",
-		webhook.Pipeline+"-pullrequest-binding",
-		webhook.Pipeline+"-template",
-		webhook.GitRepositoryURL,
-		"pull_request",
-		webhook.AccessTokenRef,
-		hookParams)
-	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, actions)
-
-	monitorTrigger := r.newTrigger(monitorTriggerName,
-		webhook.PullTask+"-binding",
-		webhook.PullTask+"-template",
-		webhook.GitRepositoryURL,
-		"pull_request",
-		webhook.AccessTokenRef,
-		monitorParams)
-	monitorTrigger.Interceptor.Header = append(monitorTrigger.Interceptor.Header, actions)
-
-	triggers := []v1alpha1.EventListenerTrigger{pushTrigger, pullRequestTrigger, monitorTrigger}
+	triggers := r.webhookTriggers(webhook, monitorTriggerName)
 
 	eventListener := v1alpha1.EventListener{
 		ObjectMeta: metav1.ObjectMeta{
@@ -90,7 +58,7 @@ func (r Resource) createEventListener(webhook webhook, namespace, monitorTrigger
 			Triggers:           triggers,
 		},
 	}
-	return r.TriggersClient.TektonV1alpha1().EventListeners(namespace).Create(&eventListener)
+	return r.createEventListenerResource(&eventListener)
 }
 
 /*
@@ -98,48 +66,75 @@ func (r Resource) createEventListener(webhook webhook, namespace, monitorTrigger
 	run with a single eventlistener.
 */
 func (r Resource) updateEventListener(eventListener *v1alpha1.EventListener, webhook webhook, monitorTriggerName string) (*v1alpha1.EventListener, error) {
+	eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, r.addedTriggers(eventListener.Spec.Triggers, webhook, monitorTriggerName)...)
+	return r.updateEventListenerResource(eventListener)
+}
+
+// The triggers adding the webhook to an eventlistener with the given triggers: its push
+// and pull request triggers, and the repository's monitor trigger if there is none yet
+func (r Resource) addedTriggers(triggers []v1alpha1.EventListenerTrigger, webhook webhook, monitorTriggerName string) []v1alpha1.EventListenerTrigger {
+	if isGeneric(webhook) {
+		return []v1alpha1.EventListenerTrigger{r.newGenericTrigger(webhook)}
+	}
+	pushTrigger, pullRequestTrigger, monitorTrigger := r.newWebhookTriggers(webhook, monitorTriggerName)
+	added := []v1alpha1.EventListenerTrigger{pushTrigger, pullRequestTrigger}
+	for _, trigger := range triggers {
+		if trigger.Name == monitorTriggerName {
+			return added
+		}
+	}
+	return append(added, monitorTrigger)
+}
+
+// All of the triggers for a webhook on an eventlistener it is the first on
+func (r Resource) webhookTriggers(webhook webhook, monitorTriggerName string) []v1alpha1.EventListenerTrigger {
+	if isGeneric(webhook) {
+		return []v1alpha1.EventListenerTrigger{r.newGenericTrigger(webhook)}
+	}
+	pushTrigger, pullRequestTrigger, monitorTrigger := r.newWebhookTriggers(webhook, monitorTriggerName)
+	return []v1alpha1.EventListenerTrigger{pushTrigger, pullRequestTrigger, monitorTrigger}
+}
+
+// The push, pull request and monitor triggers for a webhook
+func (r Resource) newWebhookTriggers(webhook webhook, monitorTriggerName string) (pushTrigger, pullRequestTrigger, monitorTrigger v1alpha1.EventListenerTrigger) {
 	hookParams, monitorParams := r.getParams(webhook)
-	newPushTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-push-event",
+
+	pushTrigger = r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-push-event",
 		webhook.Pipeline+"-push-binding",
 		webhook.Pipeline+"-template",
 		webhook.GitRepositoryURL,
 		"push",
 		webhook.AccessTokenRef,
 		hookParams)
+	pushTrigger.Interceptor.Header = append(pushTrigger.Interceptor.Header, r.getConcurrencyHeaders(webhook)...)
+	pushTrigger.Interceptor.Header = append(pushTrigger.Interceptor.Header, getSourceRangeHeaders(webhook)...)
+	pushTrigger.Interceptor.Header = append(pushTrigger.Interceptor.Header, getCredentialSyncHeaders(webhook)...)
+	pushTrigger.Interceptor.Header = append(pushTrigger.Interceptor.Header, getScheduleHeaders(webhook)...)
+	pushTrigger.Interceptor.Header = append(pushTrigger.Interceptor.Header, getRetentionHeaders(webhook)...)
 
-	newPullRequestTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-pullrequest-event",
+	pullRequestTrigger = r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-pullrequest-event",
 		webhook.Pipeline+"-pullrequest-binding",
 		webhook.Pipeline+"-template",
 		webhook.GitRepositoryURL,
 		"pull_request",
 		webhook.AccessTokenRef,
 		hookParams)
-	newPullRequestTrigger.Interceptor.Header = append(newPullRequestTrigger.Interceptor.Header, actions)
-
-	eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, newPushTrigger)
-	eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, newPullRequestTrigger)
-
-	existingMonitorFound := false
-	for _, trigger := range eventListener.Spec.Triggers {
-		if trigger.Name == monitorTriggerName {
-			existingMonitorFound = true
-			break
-		}
-	}
-	if !existingMonitorFound {
-		newMonitor := r.newTrigger(monitorTriggerName,
-			webhook.PullTask+"-binding",
-			webhook.PullTask+"-template",
-			webhook.GitRepositoryURL,
-			"pull_request",
-			webhook.AccessTokenRef,
-			monitorParams)
-		newMonitor.Interceptor.Header = append(newMonitor.Interceptor.Header, actions)
-
-		eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, newMonitor)
-	}
+	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, actions)
+	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, r.getConcurrencyHeaders(webhook)...)
+	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, getSourceRangeHeaders(webhook)...)
+	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, getCredentialSyncHeaders(webhook)...)
+	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, getScheduleHeaders(webhook)...)
+	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, getRetentionHeaders(webhook)...)
 
-	return r.TriggersClient.TektonV1alpha1().EventListeners(eventListener.GetNamespace()).Update(eventListener)
+	monitorTrigger = r.newTrigger(monitorTriggerName,
+		webhook.PullTask+"-binding",
+		webhook.PullTask+"-template",
+		webhook.GitRepositoryURL,
+		"pull_request",
+		webhook.AccessTokenRef,
+		monitorParams)
+	monitorTrigger.Interceptor.Header = append(monitorTrigger.Interceptor.Header, actions)
+	return pushTrigger, pullRequestTrigger, monitorTrigger
 }
 
 func (r Resource) newTrigger(name, bindingName, templateName, repoURL, event, secretName string, params []pipelinesv1alpha1.Param) v1alpha1.EventListenerTrigger {
@@ -160,16 +155,81 @@ func (r Resource) newTrigger(name, bindingName, templateName, repoURL, event, se
 				{Name: "Wext-Repository-Url", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: repoURL}},
 				{Name: "Wext-Incoming-Event", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: event}},
 				{Name: "Wext-Secret-Name", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: secretName}}},
-			ObjectRef: &corev1.ObjectReference{
-				APIVersion: "v1",
-				Kind:       "Service",
-				Name:       "tekton-webhooks-extension-validator",
-				Namespace:  r.Defaults.Namespace,
-			},
+			ObjectRef: r.interceptorServiceRef(),
 		},
 	}
 }
 
+/*
+	Headers asking the interceptor to cap the webhook's in-flight PipelineRuns, or to
+	cancel those superseded by a new commit. Runs are found by the labels described in
+	docs/Labels.md and the tekton.dev/pipeline label added by Tekton.
+*/
+func (r Resource) getConcurrencyHeaders(webhook webhook) []pipelinesv1alpha1.Param {
+	if webhook.MaxConcurrentRuns <= 0 && !webhook.CancelSuperseded {
+		return nil
+	}
+	server, org, repo, err := getGitValues(webhook.GitRepositoryURL)
+	if err != nil {
+		logging.Log.Errorf("error returned from getGitValues: %s", err)
+		return nil
+	}
+	server = strings.TrimPrefix(server, "https://")
+	server = strings.TrimPrefix(server, "http://")
+	selector := fmt.Sprintf("webhooks.tekton.dev/gitServer=%s,webhooks.tekton.dev/gitOrg=%s,webhooks.tekton.dev/gitRepo=%s,tekton.dev/pipeline=%s", server, org, repo, webhook.Pipeline)
+
+	headers := []pipelinesv1alpha1.Param{}
+	if webhook.MaxConcurrentRuns > 0 {
+		headers = append(headers, pipelinesv1alpha1.Param{Name: "Wext-Max-Concurrent-Runs", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: strconv.Itoa(webhook.MaxConcurrentRuns)}})
+	}
+	if webhook.CancelSuperseded {
+		headers = append(headers, pipelinesv1alpha1.Param{Name: "Wext-Cancel-Superseded", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "true"}})
+	}
+	return append(headers,
+		pipelinesv1alpha1.Param{Name: "Wext-Concurrency-Namespace", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: webhook.Namespace}},
+		pipelinesv1alpha1.Param{Name: "Wext-Concurrency-Selector", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: selector}})
+}
+
+/*
+	Header asking the interceptor to only accept deliveries from the webhook's
+	source ranges, rather than the global allowlist.
+*/
+func getSourceRangeHeaders(webhook webhook) []pipelinesv1alpha1.Param {
+	if webhook.AllowedSourceRanges == "" {
+		return nil
+	}
+	return []pipelinesv1alpha1.Param{
+		{Name: "Wext-Allowed-Source-Ranges", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: webhook.AllowedSourceRanges}},
+	}
+}
+
+/*
+	Header recording that the webhook's credential is synchronized into its namespace,
+	the interceptor ignores it.
+*/
+func getCredentialSyncHeaders(webhook webhook) []pipelinesv1alpha1.Param {
+	if !webhook.SyncCredential {
+		return nil
+	}
+	return []pipelinesv1alpha1.Param{
+		{Name: "Wext-Sync-Credential", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "true"}},
+	}
+}
+
+/*
+	Headers recording the webhook's schedules, which the extension runs. The
+	interceptor ignores them.
+*/
+func getScheduleHeaders(webhook webhook) []pipelinesv1alpha1.Param {
+	if webhook.Schedules == "" {
+		return nil
+	}
+	return []pipelinesv1alpha1.Param{
+		{Name: "Wext-Schedules", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: webhook.Schedules}},
+		{Name: "Wext-Schedule-Branch", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: webhook.ScheduleBranch}},
+	}
+}
+
 /*
 	Processing of the inputs into the required structure for
 	the eventlistener.
@@ -323,11 +383,7 @@ func getGitValues(url string) (gitServer, gitOwner, gitRepo string, err error) {
 
 // Creates a webhook for a given repository and populates (creating if doesn't yet exist) an eventlistener
 func (r Resource) createWebhook(request *restful.Request, response *restful.Response) {
-	modifyingEventListenerLock.Lock()
-	defer modifyingEventListenerLock.Unlock()
-
 	logging.Log.Infof("Webhook creation request received with request: %+v.", request)
-	installNs := r.Defaults.Namespace
 
 	webhook := webhook{}
 	if err := request.ReadEntity(&webhook); err != nil {
@@ -336,237 +392,151 @@ func (r Resource) createWebhook(request *restful.Request, response *restful.Resp
 		return
 	}
 
-	// Sanitize GitRepositoryURL
-	webhook.GitRepositoryURL = strings.TrimSuffix(webhook.GitRepositoryURL, ".git")
-
-	if webhook.PullTask == "" {
-		webhook.PullTask = "monitor-task"
+	dryRun, err := dryRunRequested(request)
+	if err != nil {
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
 	}
 
-	if webhook.Name != "" {
-		if len(webhook.Name) > 57 {
-			tooLongMessage := fmt.Sprintf("requested release name (%s) must be less than 58 characters", webhook.Name)
-			err := errors.New(tooLongMessage)
-			logging.Log.Errorf("error: %s", err.Error())
-			RespondError(response, err, http.StatusBadRequest)
-			return
-		}
+	hooks, validationErrors := r.validateWebhook(&webhook)
+	if dryRun {
+		r.respondDryRun(response, r.dryRunCreation(webhook, hooks, validationErrors))
+		return
 	}
-
-	dockerRegDefault := r.Defaults.DockerRegistry
-	// remove prefixes if any
-	webhook.DockerRegistry = strings.TrimPrefix(webhook.DockerRegistry, "https://")
-	webhook.DockerRegistry = strings.TrimPrefix(webhook.DockerRegistry, "http://")
-	if webhook.DockerRegistry == "" && dockerRegDefault != "" {
-		webhook.DockerRegistry = dockerRegDefault
+	if len(validationErrors) > 0 {
+		RespondError(response, validationErrors[0], http.StatusBadRequest)
+		return
 	}
-	logging.Log.Debugf("Docker registry location is: %s", webhook.DockerRegistry)
 
-	namespace := webhook.Namespace
-	if namespace == "" {
-		err := errors.New("a namespace for creating a webhook is required, but none was given")
-		logging.Log.Errorf("error: %s.", err.Error())
-		RespondError(response, err, http.StatusBadRequest)
+	creation, err := r.newWebhookCreation(webhook, len(gitProviderHooks(hooks)) == 0)
+	if err != nil {
+		logging.Log.Errorf("%s", err)
+		RespondError(response, errors.New("error parsing GitRepositoryURL, check pod logs for more details"), http.StatusInternalServerError)
 		return
 	}
-
-	if !strings.HasPrefix(webhook.GitRepositoryURL, "http") {
-		err := errors.New("the supplied GitRepositoryURL does not specify the protocol http:// or https://")
-		logging.Log.Errorf("error: %s", err.Error())
-		RespondError(response, err, http.StatusBadRequest)
+	if status, err := creation.run(); err != nil {
+		logging.Log.Errorf("%s", err)
+		RespondError(response, err, status)
 		return
 	}
 
-	pieces := strings.Split(webhook.GitRepositoryURL, "/")
-	if len(pieces) < 4 {
-		logging.Log.Errorf("error creating webhook: GitRepositoryURL format error (%+v).", webhook.GitRepositoryURL)
-		RespondError(response, errors.New("GitRepositoryURL format error"), http.StatusBadRequest)
-		return
+	response.WriteHeader(http.StatusCreated)
+}
+
+/*
+	Sanitizes the webhook and fills in its defaults, then checks it can be created.
+	Returns the webhooks already on its repository and every problem found.
+*/
+func (r Resource) validateWebhook(hook *webhook) ([]webhook, []error) {
+	installNs := r.Defaults.Namespace
+	validationErrors := []error{}
+	invalid := func(err error) {
+		logging.Log.Errorf("error: %s", err.Error())
+		validationErrors = append(validationErrors, err)
 	}
 
-	hooks, err := r.getHooksForRepo(webhook.GitRepositoryURL)
-	if len(hooks) > 0 {
-		for _, hook := range hooks {
-			if hook.Name == webhook.Name && hook.Namespace == webhook.Namespace {
-				logging.Log.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v with the Name %s and Namespace %s.", webhook.GitRepositoryURL, webhook.Name, webhook.Namespace)
-				RespondError(response, errors.New("Webhook already exists for the specified Git repository with the same name, targeting the same namespace"), http.StatusBadRequest)
-				return
-			}
-			if hook.Pipeline == webhook.Pipeline && hook.Namespace == webhook.Namespace {
-				logging.Log.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v, running pipeline %s in namespace %s.", webhook.GitRepositoryURL, webhook.Pipeline, webhook.Namespace)
-				RespondError(response, errors.New("Webhook already exists for the specified Git repository, running the same pipeline in the same namespace"), http.StatusBadRequest)
-				return
-			}
-			if hook.PullTask != webhook.PullTask {
-				msg := fmt.Sprintf("PullTask mismatch. Webhooks on a repository must use the same PullTask existing webhooks use %s not %s.", hook.PullTask, webhook.PullTask)
-				logging.Log.Errorf("error creating webhook: " + msg)
-				RespondError(response, errors.New(msg), http.StatusBadRequest)
-				return
-			}
+	// Sanitize GitRepositoryURL
+	hook.GitRepositoryURL = strings.TrimSuffix(hook.GitRepositoryURL, ".git")
+
+	if hook.Type != "" && !isGeneric(*hook) {
+		invalid(fmt.Errorf("webhook type %s is not supported, leave it empty for a Git repository or use %s", hook.Type, webhookTypeGeneric))
+	}
+	if isGeneric(*hook) {
+		for _, err := range validateGenericSettings(hook) {
+			invalid(err)
 		}
+	} else if hook.PullTask == "" {
+		hook.PullTask = "monitor-task"
 	}
 
-	_, templateErr := r.TriggersClient.TektonV1alpha1().TriggerTemplates(installNs).Get(webhook.Pipeline+"-template", metav1.GetOptions{})
-	_, pushErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(webhook.Pipeline+"-push-binding", metav1.GetOptions{})
-	_, pullrequestErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(webhook.Pipeline+"-pullrequest-binding", metav1.GetOptions{})
-	if templateErr != nil || pushErr != nil || pullrequestErr != nil {
-		msg := fmt.Sprintf("Could not find the required trigger template or trigger bindings in namespace: %s. Expected to find: %s, %s and %s", installNs, webhook.Pipeline+"-template", webhook.Pipeline+"-push-binding", webhook.Pipeline+"-pullrequest-binding")
-		logging.Log.Errorf("%s", msg)
-		logging.Log.Errorf("template error: `%s`, pushbinding error: `%s`, pullrequest error: `%s`", templateErr, pushErr, pullrequestErr)
-		RespondError(response, errors.New(msg), http.StatusBadRequest)
-		return
+	if len(hook.Name) > 57 {
+		invalid(fmt.Errorf("requested release name (%s) must be less than 58 characters", hook.Name))
 	}
 
-	eventListener, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
-	if err != nil && !k8serrors.IsNotFound(err) {
-		msg := fmt.Sprintf("unable to create webhook due to error listing Tekton eventlistener: %s", err)
-		logging.Log.Errorf("%s", msg)
-		RespondError(response, errors.New(msg), http.StatusInternalServerError)
-		return
+	dockerRegDefault := r.Defaults.DockerRegistry
+	// remove prefixes if any
+	hook.DockerRegistry = strings.TrimPrefix(hook.DockerRegistry, "https://")
+	hook.DockerRegistry = strings.TrimPrefix(hook.DockerRegistry, "http://")
+	if hook.DockerRegistry == "" && dockerRegDefault != "" {
+		hook.DockerRegistry = dockerRegDefault
 	}
+	logging.Log.Debugf("Docker registry location is: %s", hook.DockerRegistry)
 
-	gitServer, gitOwner, gitRepo, err := getGitValues(webhook.GitRepositoryURL)
-	if err != nil {
-		logging.Log.Errorf("error parsing git repository URL %s in getGitValues(): %s", webhook.GitRepositoryURL, err)
-		RespondError(response, errors.New("error parsing GitRepositoryURL, check pod logs for more details"), http.StatusInternalServerError)
-		return
+	if hook.MaxConcurrentRuns < 0 {
+		invalid(errors.New("the maximum number of concurrent runs cannot be negative"))
+	}
+	if hook.CancelSuperseded && isGeneric(*hook) {
+		invalid(errors.New("superseded runs cannot be cancelled for a generic webhook, as its deliveries have no branch"))
 	}
-	sanitisedURL := gitServer + "/" + gitOwner + "/" + gitRepo
-	// Single monitor trigger for all triggers on a repo - thus name to use for monitor is
-	monitorTriggerName := strings.TrimPrefix(gitServer+"/"+gitOwner+"/"+gitRepo, "http://")
-	monitorTriggerName = strings.TrimPrefix(monitorTriggerName, "https://")
 
-	if eventListener != nil && eventListener.GetName() != "" {
-		_, err := r.updateEventListener(eventListener, webhook, monitorTriggerName)
-		if err != nil {
-			msg := fmt.Sprintf("error creating webhook due to error updating eventlistener: %s", err)
-			logging.Log.Errorf("%s", msg)
-			RespondError(response, errors.New(msg), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		logging.Log.Info("No existing eventlistener found, creating a new one...")
-		_, err := r.createEventListener(webhook, installNs, monitorTriggerName)
-		if err != nil {
-			msg := fmt.Sprintf("error creating webhook due to error creating eventlistener. Error was: %s", err)
-			logging.Log.Errorf("%s", msg)
-			RespondError(response, errors.New(msg), http.StatusInternalServerError)
-			return
-		}
+	hook.Schedules = strings.Join(splitSchedules(hook.Schedules), ";")
+	hook.ScheduleBranch = strings.TrimPrefix(strings.TrimSpace(hook.ScheduleBranch), "refs/heads/")
+	for _, err := range validateSchedules(*hook) {
+		invalid(err)
+	}
 
-		_, varexists := os.LookupEnv("PLATFORM")
-		if !varexists {
-			err = r.createDeleteIngress("create", installNs)
-			if err != nil {
-				msg := fmt.Sprintf("error creating webhook due to error creating ingress. Error was: %s", err)
-				logging.Log.Errorf("%s", msg)
-				logging.Log.Debugf("Deleting eventlistener as failed creating Ingress")
-				err2 := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Delete(eventListenerName, &metav1.DeleteOptions{})
-				if err2 != nil {
-					updatedMsg := fmt.Sprintf("error creating webhook due to error creating ingress. Also failed to cleanup and delete eventlistener. Errors were: %s and %s", err, err2)
-					RespondError(response, errors.New(updatedMsg), http.StatusInternalServerError)
-					return
-				}
-				RespondError(response, errors.New(msg), http.StatusInternalServerError)
-				return
-			} else {
-				logging.Log.Debug("ingress creation succeeded")
-			}
-		} else {
-			if err := r.createOpenshiftRoute(routeName); err != nil {
-				logging.Log.Debug("Failed to create Route, deleting EventListener...")
-				err2 := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Delete(eventListenerName, &metav1.DeleteOptions{})
-				if err2 != nil {
-					updatedMsg := fmt.Sprintf("Error creating webhook due to error creating route. Also failed to cleanup and delete eventlistener. Errors were: %s and %s", err, err2)
-					RespondError(response, errors.New(updatedMsg), http.StatusInternalServerError)
-					return
-				}
-				RespondError(response, err, http.StatusInternalServerError)
-				return
-			}
-		}
+	hook.MaxRunAge = strings.TrimSpace(hook.MaxRunAge)
+	for _, err := range validateRetention(*hook) {
+		invalid(err)
+	}
 
+	if sourceRanges, err := sanitizeSourceRanges(hook.AllowedSourceRanges); err != nil {
+		invalid(err)
+	} else {
+		hook.AllowedSourceRanges = sourceRanges
 	}
 
-	if len(hooks) == 0 {
-		// // Give the eventlistener a chance to be up and running or webhook ping
-		// // will get a 503 and might confuse people (although resend will work)
-		for i := 0; i < 30; i = i + 1 {
-			a, _ := r.K8sClient.Apps().Deployments(installNs).Get(routeName, metav1.GetOptions{})
-			replicas := a.Status.ReadyReplicas
-			if replicas > 0 {
-				break
-			}
-			time.Sleep(1 * time.Second)
-		}
+	if hook.Namespace == "" {
+		invalid(errors.New("a namespace for creating a webhook is required, but none was given"))
+	}
 
-		// Create webhook
-		err = r.AddWebhook(webhook, gitOwner, gitRepo)
-		if err != nil {
-			err2 := r.deleteFromEventListener(webhook.Name+"-"+webhook.Namespace, installNs, monitorTriggerName, webhook.GitRepositoryURL)
-			if err2 != nil {
-				updatedMsg := fmt.Sprintf("error creating webhook. Also failed to cleanup and delete entry from eventlistener. Errors were: %s and %s", err, err2)
-				RespondError(response, errors.New(updatedMsg), http.StatusInternalServerError)
-				return
-			}
-			RespondError(response, err, http.StatusInternalServerError)
-			return
-		}
-		logging.Log.Debug("webhook creation succeeded")
+	var hooks []webhook
+	if !strings.HasPrefix(hook.GitRepositoryURL, "http") {
+		invalid(errors.New("the supplied GitRepositoryURL does not specify the protocol http:// or https://"))
+	} else if pieces := strings.Split(hook.GitRepositoryURL, "/"); len(pieces) < 4 {
+		logging.Log.Errorf("error creating webhook: GitRepositoryURL format error (%+v).", hook.GitRepositoryURL)
+		validationErrors = append(validationErrors, errors.New("GitRepositoryURL format error"))
 	} else {
-		logging.Log.Debugf("webhook already exists for repository %s - not creating new hook in GitHub", sanitisedURL)
+		hooks, _ = r.getHooksForRepo(hook.GitRepositoryURL)
+	}
+	for _, existing := range hooks {
+		if existing.Name == hook.Name && existing.Namespace == hook.Namespace {
+			logging.Log.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v with the Name %s and Namespace %s.", hook.GitRepositoryURL, hook.Name, hook.Namespace)
+			validationErrors = append(validationErrors, errors.New("Webhook already exists for the specified Git repository with the same name, targeting the same namespace"))
+		}
+		if existing.Pipeline == hook.Pipeline && existing.Namespace == hook.Namespace {
+			logging.Log.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v, running pipeline %s in namespace %s.", hook.GitRepositoryURL, hook.Pipeline, hook.Namespace)
+			validationErrors = append(validationErrors, errors.New("Webhook already exists for the specified Git repository, running the same pipeline in the same namespace"))
+		}
+		if !isGeneric(existing) && !isGeneric(*hook) && existing.PullTask != hook.PullTask {
+			invalid(fmt.Errorf("PullTask mismatch. Webhooks on a repository must use the same PullTask existing webhooks use %s not %s.", existing.PullTask, hook.PullTask))
+		}
 	}
 
-	response.WriteHeader(http.StatusCreated)
+	_, templateErr := r.TriggersClient.TektonV1alpha1().TriggerTemplates(installNs).Get(hook.Pipeline+"-template", metav1.GetOptions{})
+	if isGeneric(*hook) {
+		_, bindingErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(hook.Pipeline+"-generic-binding", metav1.GetOptions{})
+		if templateErr != nil || bindingErr != nil {
+			logging.Log.Errorf("template error: `%s`, generic binding error: `%s`", templateErr, bindingErr)
+			invalid(fmt.Errorf("Could not find the required trigger template or trigger binding in namespace: %s. Expected to find: %s and %s", installNs, hook.Pipeline+"-template", hook.Pipeline+"-generic-binding"))
+		}
+		return hooks, validationErrors
+	}
+	_, pushErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(hook.Pipeline+"-push-binding", metav1.GetOptions{})
+	_, pullrequestErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(hook.Pipeline+"-pullrequest-binding", metav1.GetOptions{})
+	if templateErr != nil || pushErr != nil || pullrequestErr != nil {
+		logging.Log.Errorf("template error: `%s`, pushbinding error: `%s`, pullrequest error: `%s`", templateErr, pushErr, pullrequestErr)
+		invalid(fmt.Errorf("Could not find the required trigger template or trigger bindings in namespace: %s. Expected to find: %s, %s and %s", installNs, hook.Pipeline+"-template", hook.Pipeline+"-push-binding", hook.Pipeline+"-pullrequest-binding"))
+	}
+	return hooks, validationErrors
 }
 
 func (r Resource) createDeleteIngress(mode, installNS string) error {
 	if mode == "create" {
-		// Unlike webhook creation, the ingress does not need a protocol specified
-		callback := strings.TrimPrefix(r.Defaults.CallbackURL, "http://")
-		callback = strings.TrimPrefix(callback, "https://")
-
-		ingress := &v1beta1.Ingress{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "el-" + eventListenerName,
-				Namespace: installNS,
-			},
-			Spec: v1beta1.IngressSpec{
-				Rules: []v1beta1.IngressRule{
-					{
-						Host: callback,
-						IngressRuleValue: v1beta1.IngressRuleValue{
-							HTTP: &v1beta1.HTTPIngressRuleValue{
-								Paths: []v1beta1.HTTPIngressPath{
-									{
-										Backend: v1beta1.IngressBackend{
-											ServiceName: "el-" + eventListenerName,
-											ServicePort: intstr.IntOrString{
-												Type:   intstr.Int,
-												IntVal: 8080,
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		}
-		ingress, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNS).Create(ingress)
-		if err != nil {
-			return err
-		}
-		logging.Log.Debug("Ingress has been created")
-		return nil
+		return r.createIngress(installNS)
 	} else if mode == "delete" {
-		err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNS).Delete("el-"+eventListenerName, &metav1.DeleteOptions{})
-		if err != nil {
-			return err
-		}
-		logging.Log.Debug("Ingress has been deleted")
-		return nil
+		return r.deleteIngress(installNS)
 	} else {
 		logging.Log.Debug("Wrong mode")
 		return errors.New("Wrong mode for createDeleteIngress")
@@ -575,8 +545,6 @@ func (r Resource) createDeleteIngress(mode, installNS string) error {
 
 // Removes from Eventlistener, removes the webhook
 func (r Resource) deleteWebhook(request *restful.Request, response *restful.Response) {
-	modifyingEventListenerLock.Lock()
-	defer modifyingEventListenerLock.Unlock()
 	logging.Log.Debug("In deleteWebhook")
 	name := request.PathParameter("name")
 	repo := request.QueryParameter("repository")
@@ -596,6 +564,13 @@ func (r Resource) deleteWebhook(request *restful.Request, response *restful.Resp
 		}
 	}
 
+	dryRun, err := dryRunRequested(request)
+	if err != nil {
+		logging.Log.Error(err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
 	if namespace == "" || repo == "" {
 		theError := errors.New("bad request information provided, a namespace and a repository must be specified as query parameters")
 		logging.Log.Error(theError)
@@ -628,7 +603,12 @@ func (r Resource) deleteWebhook(request *restful.Request, response *restful.Resp
 	for _, hook := range webhooks {
 		if hook.Name == name && hook.Namespace == namespace {
 			found = true
-			if len(webhooks) == 1 {
+			lastOnRepo := !isGeneric(hook) && len(gitProviderHooks(webhooks)) == 1
+			if dryRun {
+				r.respondDryRun(response, r.dryRunDeletion(hook, lastOnRepo, monitorTriggerName))
+				return
+			}
+			if lastOnRepo {
 				logging.Log.Debug("No other pipelines triggered by this GitHub webhook, deleting webhook")
 				// Delete webhook
 				err := r.RemoveWebhook(hook, gitOwner, gitRepo)
@@ -649,6 +629,14 @@ func (r Resource) deleteWebhook(request *restful.Request, response *restful.Resp
 				RespondError(response, theError, http.StatusInternalServerError)
 				return
 			}
+			if hook.SyncCredential {
+				if err := r.removeSyncedCredential(hook); err != nil {
+					logging.Log.Errorf("error removing credential %s synchronized into namespace %s: %s", hook.AccessTokenRef, namespace, err)
+				}
+			}
+			if err := r.deliveryHistory().Delete(eventListenerEntryPrefix); err != nil {
+				logging.Log.Errorf("error removing the deliveries recorded for webhook %s: %s", name, err)
+			}
 
 			response.WriteHeader(204)
 		}
@@ -664,16 +652,80 @@ func (r Resource) deleteWebhook(request *restful.Request, response *restful.Resp
 }
 
 func (r Resource) deleteFromEventListener(name, installNS, monitorTriggerName, repoOnParams string) error {
-	logging.Log.Debugf("Deleting triggers for %s from the eventlistener", name)
-	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Get(eventListenerName, metav1.GetOptions{})
+	deleted, err := r.removeFromEventListener(name, installNS, monitorTriggerName, repoOnParams)
+	if err != nil || !deleted {
+		return err
+	}
+
+	exposer, err := r.eventListenerExposer()
 	if err != nil {
 		return err
 	}
+	if err := exposer.unexpose(installNS); err != nil {
+		logging.Log.Errorf("error deleting webhook due to error deleting %s: %s", exposer.kind(), err)
+		return err
+	}
+	logging.Log.Debugf("%s deletion succeeded", exposer.kind())
+
+	// Another replica may have created, and exposed, a new eventlistener since this one was deleted
+	if el, err := r.getEventListener(installNS); err == nil && el.GetName() != "" && !eventListenerBeingDeleted(el) {
+		logging.Log.Debugf("eventlistener was created again, recreating %s", exposer.kind())
+		if err := exposer.expose(installNS); err != nil && !k8serrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the webhook's triggers from the eventlistener, deleting the eventlistener if no triggers remain
+func (r Resource) removeFromEventListener(name, installNS, monitorTriggerName, repoOnParams string) (bool, error) {
+	logging.Log.Debugf("Deleting triggers for %s from the eventlistener", name)
+	deleting := false
+	var marked *v1alpha1.EventListener
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		el, err := r.getEventListener(installNS)
+		if err != nil {
+			return err
+		}
+		newTriggers := remainingTriggers(el.Spec.Triggers, name, monitorTriggerName, repoOnParams)
+		deleting = len(newTriggers) == 0
+		if deleting {
+			// Marked before it is deleted so that webhooks added meanwhile are not lost with it
+			if el.Annotations == nil {
+				el.Annotations = map[string]string{}
+			}
+			el.Annotations[eventListenerDeletingAnnotation] = "true"
+		} else {
+			el.Spec.Triggers = newTriggers
+		}
+		marked, err = r.updateEventListenerResource(el)
+		return err
+	})
+	if err != nil {
+		logging.Log.Errorf("error updating eventlistener: %s", err)
+		return false, err
+	}
+	if !deleting {
+		return false, nil
+	}
+	err = r.deleteEventListener(marked)
+	if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+		// Another replica replaced the marked eventlistener and owns the new one
+		logging.Log.Debugf("eventlistener marked for deletion was replaced by another replica")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	toRemove := []string{name + "-push-event", name + "-pullrequest-event"}
+// The triggers left once the webhook's are removed. The repository's monitor trigger
+// is kept while other webhooks on the repository remain.
+func remainingTriggers(currentTriggers []v1alpha1.EventListenerTrigger, name, monitorTriggerName, repoOnParams string) []v1alpha1.EventListenerTrigger {
+	toRemove := []string{name + "-push-event", name + "-pullrequest-event", name + genericTriggerSuffix}
 
 	newTriggers := []v1alpha1.EventListenerTrigger{}
-	currentTriggers := el.Spec.Triggers
 
 	monitorTrigger := v1alpha1.EventListenerTrigger{}
 	triggersOnRepo := 0
@@ -683,16 +735,22 @@ func (r Resource) deleteFromEventListener(name, installNS, monitorTriggerName, r
 		if t.Name == monitorTriggerName {
 			monitorTrigger = t
 		} else {
-			interceptorParams := t.Interceptor.Header
-			for _, p := range interceptorParams {
-				if p.Name == "Wext-Repository-Url" && p.Value.StringVal == repoOnParams {
-					triggersOnRepo++
+			// Generic triggers have no monitor trigger
+			onRepo := false
+			if !strings.HasSuffix(t.Name, genericTriggerSuffix) {
+				for _, p := range t.Interceptor.Header {
+					if p.Name == "Wext-Repository-Url" && p.Value.StringVal == repoOnParams {
+						onRepo = true
+						triggersOnRepo++
+					}
 				}
 			}
 			found := false
 			for _, triggerName := range toRemove {
 				if triggerName == t.Name {
-					triggersDeleted++
+					if onRepo {
+						triggersDeleted++
+					}
 					found = true
 					break
 				}
@@ -703,43 +761,10 @@ func (r Resource) deleteFromEventListener(name, installNS, monitorTriggerName, r
 		}
 	}
 
-	if triggersOnRepo > triggersDeleted {
+	if triggersOnRepo > triggersDeleted && monitorTrigger.Name != "" {
 		newTriggers = append(newTriggers, monitorTrigger)
 	}
-
-	if len(newTriggers) == 0 {
-		err = r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Delete(el.GetName(), &metav1.DeleteOptions{})
-		if err != nil {
-			return err
-		}
-
-		_, varExists := os.LookupEnv("PLATFORM")
-		if !varExists {
-			err = r.createDeleteIngress("delete", installNS)
-			if err != nil {
-				logging.Log.Errorf("error deleting ingress: %s", err)
-				return err
-			} else {
-				logging.Log.Debug("Ingress deleted")
-				return nil
-			}
-		} else {
-			if err := r.deleteOpenshiftRoute(routeName); err != nil {
-				msg := fmt.Sprintf("error deleting webhook due to error deleting route. Error was: %s", err)
-				logging.Log.Errorf("%s", msg)
-				return err
-			}
-			logging.Log.Debug("route deletion succeeded")
-		}
-	} else {
-		el.Spec.Triggers = newTriggers
-		_, err = r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Update(el)
-		if err != nil {
-			logging.Log.Errorf("error updating eventlistener: %s", err)
-			return err
-		}
-	}
-	return err
+	return newTriggers
 }
 
 func (r Resource) getAllWebhooks(request *restful.Request, response *restful.Response) {
@@ -771,7 +796,7 @@ func (r Resource) getHooksForRepo(gitURL string) ([]webhook, error) {
 
 func (r Resource) getWebhooksFromEventListener() ([]webhook, error) {
 	logging.Log.Debugf("Getting webhooks from eventlistener")
-	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(r.Defaults.Namespace).Get(eventListenerName, metav1.GetOptions{})
+	el, err := r.getEventListener(r.Defaults.Namespace)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			return []webhook{}, nil
@@ -788,6 +813,9 @@ func (r Resource) getWebhooksFromEventListener() ([]webhook, error) {
 		} else if strings.HasSuffix(trigger.Name, "-pullrequest-event") {
 			hook = getHookFromTrigger(trigger, "-pullrequest-event")
 			checkHook = true
+		} else if strings.HasSuffix(trigger.Name, genericTriggerSuffix) {
+			hook = getHookFromTrigger(trigger, genericTriggerSuffix)
+			checkHook = true
 		}
 		if checkHook && !containedInArray(hooks, hook) {
 			hooks = append(hooks, hook)
@@ -799,6 +827,14 @@ func (r Resource) getWebhooksFromEventListener() ([]webhook, error) {
 func getHookFromTrigger(t v1alpha1.EventListenerTrigger, suffix string) webhook {
 
 	var releaseName, namespace, serviceaccount, pulltask, dockerreg, helmsecret, repo, gitSecret string
+	var maxConcurrentRuns int
+	var cancelSuperseded bool
+	var sourceRanges string
+	var syncCredential bool
+	var hookType, signatureHeader, signatureAlgorithm, identityPath, identityValue string
+	var schedules, scheduleBranch string
+	var keepRuns int
+	var maxRunAge string
 	for _, param := range t.Params {
 		switch param.Name {
 		case "webhooks-tekton-release-name":
@@ -822,25 +858,85 @@ func getHookFromTrigger(t v1alpha1.EventListenerTrigger, suffix string) webhook
 			repo = header.Value.StringVal
 		case "Wext-Secret-Name":
 			gitSecret = header.Value.StringVal
+		case "Wext-Max-Concurrent-Runs":
+			maxConcurrentRuns, _ = strconv.Atoi(header.Value.StringVal)
+		case "Wext-Cancel-Superseded":
+			cancelSuperseded = header.Value.StringVal == "true"
+		case "Wext-Allowed-Source-Ranges":
+			sourceRanges = header.Value.StringVal
+		case "Wext-Sync-Credential":
+			syncCredential = header.Value.StringVal == "true"
+		case "Wext-Webhook-Type":
+			hookType = header.Value.StringVal
+		case "Wext-Signature-Header":
+			signatureHeader = header.Value.StringVal
+		case "Wext-Signature-Algorithm":
+			signatureAlgorithm = header.Value.StringVal
+		case "Wext-Identity-Path":
+			identityPath = header.Value.StringVal
+		case "Wext-Identity-Value":
+			identityValue = header.Value.StringVal
+		case "Wext-Schedules":
+			schedules = header.Value.StringVal
+		case "Wext-Schedule-Branch":
+			scheduleBranch = header.Value.StringVal
+		case "Wext-Keep-Runs":
+			keepRuns, _ = strconv.Atoi(header.Value.StringVal)
+		case "Wext-Max-Run-Age":
+			maxRunAge = header.Value.StringVal
 		}
 	}
 
 	triggerAsHook := webhook{
-		Name:             strings.TrimSuffix(t.Name, "-"+namespace+suffix),
-		Namespace:        namespace,
-		Pipeline:         strings.TrimSuffix(t.Template.Name, "-template"),
-		GitRepositoryURL: repo,
-		HelmSecret:       helmsecret,
-		PullTask:         pulltask,
-		DockerRegistry:   dockerreg,
-		ServiceAccount:   serviceaccount,
-		ReleaseName:      releaseName,
-		AccessTokenRef:   gitSecret,
+		Name:                strings.TrimSuffix(t.Name, "-"+namespace+suffix),
+		Namespace:           namespace,
+		Pipeline:            strings.TrimSuffix(t.Template.Name, "-template"),
+		GitRepositoryURL:    repo,
+		HelmSecret:          helmsecret,
+		PullTask:            pulltask,
+		DockerRegistry:      dockerreg,
+		ServiceAccount:      serviceaccount,
+		ReleaseName:         releaseName,
+		AccessTokenRef:      gitSecret,
+		MaxConcurrentRuns:   maxConcurrentRuns,
+		CancelSuperseded:    cancelSuperseded,
+		AllowedSourceRanges: sourceRanges,
+		SyncCredential:      syncCredential,
+		Type:                hookType,
+		SignatureHeader:     signatureHeader,
+		SignatureAlgorithm:  signatureAlgorithm,
+		IdentityPath:        identityPath,
+		IdentityValue:       identityValue,
+		Schedules:           schedules,
+		ScheduleBranch:      scheduleBranch,
+		KeepRuns:            keepRuns,
+		MaxRunAge:           maxRunAge,
 	}
 
 	return triggerAsHook
 }
 
+/*
+	Checks each of the comma separated source ranges is a CIDR, a single address
+	or "github", and returns them without whitespace.
+*/
+func sanitizeSourceRanges(sourceRanges string) (string, error) {
+	sanitized := []string{}
+	for _, sourceRange := range strings.Split(sourceRanges, ",") {
+		sourceRange = strings.TrimSpace(sourceRange)
+		if sourceRange == "" {
+			continue
+		}
+		if strings.ToLower(sourceRange) != "github" && net.ParseIP(sourceRange) == nil {
+			if _, _, err := net.ParseCIDR(sourceRange); err != nil {
+				return "", fmt.Errorf("allowed source range %s is not a CIDR or an address", sourceRange)
+			}
+		}
+		sanitized = append(sanitized, sourceRange)
+	}
+	return strings.Join(sanitized, ","), nil
+}
+
 func containedInArray(array []webhook, hook webhook) bool {
 	for _, item := range array {
 		if item == hook {
@@ -862,25 +958,14 @@ func (r Resource) deletePipelineRuns(gitRepoURL, namespace, pipeline string) err
 
 	found := false
 	for _, pipelineRun := range allPipelineRuns.Items {
-		if pipelineRun.Spec.PipelineRef.Name == pipeline {
-			labels := pipelineRun.GetLabels()
-			serverURL := labels["gitServer"]
-			orgName := labels["gitOrg"]
-			repoName := labels["gitRepo"]
-			foundRepoURL := fmt.Sprintf("https://%s/%s/%s", serverURL, orgName, repoName)
-
-			gitRepoURL = strings.ToLower(strings.TrimSuffix(gitRepoURL, ".git"))
-			foundRepoURL = strings.ToLower(strings.TrimSuffix(foundRepoURL, ".git"))
-
-			if foundRepoURL == gitRepoURL {
-				found = true
-				err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).Delete(pipelineRun.Name, &metav1.DeleteOptions{})
-				if err != nil {
-					logging.Log.Errorf("failed to delete %s, error: %s", pipelineRun.Name, err.Error())
-					return err
-				}
-				logging.Log.Infof("Deleted PipelineRun %s", pipelineRun.Name)
+		if pipelineRunForWebhook(pipelineRun, gitRepoURL, pipeline) {
+			found = true
+			err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).Delete(pipelineRun.Name, &metav1.DeleteOptions{})
+			if err != nil {
+				logging.Log.Errorf("failed to delete %s, error: %s", pipelineRun.Name, err.Error())
+				return err
 			}
+			logging.Log.Infof("Deleted PipelineRun %s", pipelineRun.Name)
 		}
 	}
 	if !found {
@@ -929,10 +1014,18 @@ func (r Resource) RegisterExtensionWebService(container *restful.Container) {
 	ws.Route(ws.GET("/").To(r.getAllWebhooks))
 	ws.Route(ws.GET("/defaults").To(r.getDefaults))
 	ws.Route(ws.DELETE("/{name}").To(r.deleteWebhook))
+	ws.Route(ws.GET("/{name}/deliveries").To(r.getDeliveries))
+	ws.Route(ws.GET("/{name}/runs").To(r.getWebhookRuns))
+	ws.Route(ws.POST("/{name}/deliveries/{id}/replay").To(r.replayDelivery))
+	ws.Route(ws.POST("/checkruns").To(r.handleCheckRunEvent))
+	ws.Route(ws.GET("/export").To(r.exportWebhooks).Produces(restful.MIME_JSON, mimeYAML))
+	ws.Route(ws.POST("/import").To(r.importWebhooks).Consumes(restful.MIME_JSON, mimeYAML, "application/x-yaml", "text/yaml"))
 
 	ws.Route(ws.POST("/credentials").To(r.createCredential))
 	ws.Route(ws.GET("/credentials").To(r.getAllCredentials))
+	ws.Route(ws.GET("/credentials/{name}").To(r.getCredential))
 	ws.Route(ws.DELETE("/credentials/{name}").To(r.deleteCredential))
+	ws.Route(ws.POST("/credentials/{name}/verify").To(r.verifyCredential))
 
 	container.Add(ws)
 }
@@ -964,10 +1057,15 @@ func (r Resource) RegisterWeb(container *restful.Container) {
 // createOpenshiftRoute attempts to create an Openshift Route on the service.
 // The Route has the same name as the service
 func (r Resource) createOpenshiftRoute(serviceName string) error {
+	_, err := r.RoutesClient.RouteV1().Routes(r.Defaults.Namespace).Create(newOpenshiftRoute(serviceName))
+	return err
+}
+
+func newOpenshiftRoute(serviceName string) *routesv1.Route {
 	annotations := make(map[string]string)
 	annotations["haproxy.router.openshift.io/timeout"] = "2m"
 
-	route := &routesv1.Route{
+	return &routesv1.Route{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        serviceName,
 			Annotations: annotations,
@@ -979,8 +1077,6 @@ func (r Resource) createOpenshiftRoute(serviceName string) error {
 			},
 		},
 	}
-	_, err := r.RoutesClient.RouteV1().Routes(r.Defaults.Namespace).Create(route)
-	return err
 }
 
 // deleteOpenshiftRoute attempts to delete an Openshift Route
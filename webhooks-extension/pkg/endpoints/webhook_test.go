@@ -109,6 +109,87 @@ func TestNewTrigger(t *testing.T) {
 	}
 }
 
+func TestGetConcurrencyHeaders(t *testing.T) {
+	r := dummyResource()
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: "https://github.com/Owner/Repo",
+		Pipeline:         "pipeline1",
+	}
+	if headers := r.getConcurrencyHeaders(hook); len(headers) != 0 {
+		t.Errorf("Expected no concurrency headers without a maximum, got %+v", headers)
+	}
+
+	hook.MaxConcurrentRuns = 3
+	expectedHeaders := []pipelinesv1alpha1.Param{
+		{Name: "Wext-Max-Concurrent-Runs", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "3"}},
+		{Name: "Wext-Concurrency-Namespace", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "foo"}},
+		{Name: "Wext-Concurrency-Selector", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "webhooks.tekton.dev/gitServer=github.com,webhooks.tekton.dev/gitOrg=owner,webhooks.tekton.dev/gitRepo=repo,tekton.dev/pipeline=pipeline1"}},
+	}
+	headers := r.getConcurrencyHeaders(hook)
+	if !reflect.DeepEqual(headers, expectedHeaders) {
+		t.Errorf("Concurrency headers did not match expectation")
+		t.Errorf("got: %+v", headers)
+		t.Errorf("expected: %+v", expectedHeaders)
+	}
+
+	trigger := r.newTrigger("name1-foo-push-event", "pipeline1-push-binding", "pipeline1-template", hook.GitRepositoryURL, "push", "secret", nil)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, headers...)
+	if getHookFromTrigger(trigger, "-push-event").MaxConcurrentRuns != 3 {
+		t.Error("Maximum concurrent runs was not read back from the trigger")
+	}
+
+	hook.MaxConcurrentRuns = 0
+	hook.CancelSuperseded = true
+	expectedHeaders = []pipelinesv1alpha1.Param{
+		{Name: "Wext-Cancel-Superseded", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "true"}},
+		expectedHeaders[1],
+		expectedHeaders[2],
+	}
+	headers = r.getConcurrencyHeaders(hook)
+	if !reflect.DeepEqual(headers, expectedHeaders) {
+		t.Errorf("Headers cancelling superseded runs did not match expectation")
+		t.Errorf("got: %+v", headers)
+		t.Errorf("expected: %+v", expectedHeaders)
+	}
+
+	trigger = r.newTrigger("name1-foo-push-event", "pipeline1-push-binding", "pipeline1-template", hook.GitRepositoryURL, "push", "secret", nil)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, headers...)
+	if readHook := getHookFromTrigger(trigger, "-push-event"); !readHook.CancelSuperseded || readHook.MaxConcurrentRuns != 0 {
+		t.Errorf("Cancelling superseded runs was not read back from the trigger, got %+v", readHook)
+	}
+}
+
+func TestSanitizeSourceRanges(t *testing.T) {
+	sanitized, err := sanitizeSourceRanges(" 192.30.252.0/22, github,10.1.2.3 ,2001:db8::/32,")
+	if err != nil {
+		t.Fatalf("Unexpected error sanitizing valid source ranges: %s", err)
+	}
+	if sanitized != "192.30.252.0/22,github,10.1.2.3,2001:db8::/32" {
+		t.Errorf("Sanitized source ranges were %s", sanitized)
+	}
+
+	if _, err := sanitizeSourceRanges("192.30.252.0/22,192.30.252.0/33"); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+}
+
+func TestGetSourceRangeHeaders(t *testing.T) {
+	r := dummyResource()
+	hook := webhook{Name: "name1", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo"}
+	if headers := getSourceRangeHeaders(hook); len(headers) != 0 {
+		t.Errorf("Expected no source range headers without an allowlist, got %+v", headers)
+	}
+
+	hook.AllowedSourceRanges = "github,10.0.0.0/8"
+	trigger := r.newTrigger("name1-foo-push-event", "pipeline1-push-binding", "pipeline1-template", hook.GitRepositoryURL, "push", "secret", nil)
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, getSourceRangeHeaders(hook)...)
+	if ranges := getHookFromTrigger(trigger, "-push-event").AllowedSourceRanges; ranges != hook.AllowedSourceRanges {
+		t.Errorf("Allowed source ranges read back from the trigger were %s", ranges)
+	}
+}
+
 func TestGetParams(t *testing.T) {
 	var webHooks = []webhook{
 		{
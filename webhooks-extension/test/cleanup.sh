@@ -15,7 +15,6 @@ else
   kubectl delete service webhooks-extension -n ${DASHBOARD_INSTALL_NS}
   kubectl delete service tekton-webhooks-extension-validator -n ${DASHBOARD_INSTALL_NS}
   kubectl delete task ingress-task -n ${DASHBOARD_INSTALL_NS}
-  kubectl delete task monitor-task -n ${DASHBOARD_INSTALL_NS}
   kubectl delete task route-task -n ${DASHBOARD_INSTALL_NS}
   kubectl delete triggertemplate monitor-task-template -n ${DASHBOARD_INSTALL_NS}
   kubectl delete triggerbinding monitor-task-binding -n ${DASHBOARD_INSTALL_NS}